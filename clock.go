@@ -0,0 +1,50 @@
+package redisdriver
+
+import (
+	"context"
+	"time"
+)
+
+// Clock is the time source of the driver, it can be replaced
+// with WithClock to drive the heartbeat in tests.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker is the subset of time.Ticker used by the driver.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+	Reset(d time.Duration)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return &realTicker{t: time.NewTicker(d)}
+}
+
+type realTicker struct{ t *time.Ticker }
+
+func (rt *realTicker) C() <-chan time.Time   { return rt.t.C }
+func (rt *realTicker) Stop()                 { rt.t.Stop() }
+func (rt *realTicker) Reset(d time.Duration) { rt.t.Reset(d) }
+
+// sleep waits for d on the clock of the driver, or returns the error of ctx
+// once it is done. A ticker that is stopped after its first tick stands in
+// for a timer, so a Clock only needs NewTicker.
+func (rd *RedisDriver) sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	ticker := rd.clock.NewTicker(d)
+	defer ticker.Stop()
+	select {
+	case <-ticker.C():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
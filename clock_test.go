@@ -0,0 +1,129 @@
+package redisdriver_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dcron-contrib/commons"
+	"github.com/dcron-contrib/commons/dlog"
+	"github.com/dcron-contrib/redisdriver"
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+// testClock only ticks when the test sends on a ticker, its time runs
+// ahead of the real time by advance.
+type testClock struct {
+	tickers chan *testTicker
+	offset  atomic.Int64
+}
+
+func newTestClock() *testClock {
+	return &testClock{tickers: make(chan *testTicker, 16)}
+}
+
+func (c *testClock) Now() time.Time { return time.Now().Add(time.Duration(c.offset.Load())) }
+
+// advance moves the time of the clock forward by d.
+func (c *testClock) advance(d time.Duration) { c.offset.Add(int64(d)) }
+
+func (c *testClock) NewTicker(d time.Duration) redisdriver.Ticker {
+	ticker := &testTicker{c: make(chan time.Time), intervals: make(chan time.Duration, 64)}
+	ticker.intervals <- d
+	c.tickers <- ticker
+	return ticker
+}
+
+func (c *testClock) nextTicker(t *testing.T) *testTicker {
+	select {
+	case ticker := <-c.tickers:
+		return ticker
+	case <-time.After(time.Second):
+		t.Fatal("no ticker is created")
+	}
+	return nil
+}
+
+type testTicker struct {
+	c chan time.Time
+	// intervals receives the interval of NewTicker and of every Reset.
+	intervals chan time.Duration
+}
+
+func (tt *testTicker) C() <-chan time.Time { return tt.c }
+func (tt *testTicker) Stop()               {}
+func (tt *testTicker) Reset(d time.Duration) {
+	select {
+	case tt.intervals <- d:
+	default:
+	}
+}
+
+func (tt *testTicker) tick() { tt.c <- time.Now() }
+
+func TestRedisDriver_ClockOption(t *testing.T) {
+	rds := miniredis.RunT(t)
+	clock := newTestClock()
+	var registers int32
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{
+		process: func(ctx context.Context, cmd redis.Cmder, next redis.ProcessHook) error {
+			if testFuncIsRegister(cmd) {
+				defer atomic.AddInt32(&registers, 1)
+			}
+			return next(ctx, cmd)
+		},
+	})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithClock(clock))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+	ticker := clock.nextTicker(t)
+
+	// no real time tick happens, the key lives as long as the test ticks.
+	time.Sleep(600 * time.Millisecond)
+	require.Equal(t, int32(1), atomic.LoadInt32(&registers))
+	for i := 0; i < 3; i++ {
+		ticker.tick()
+	}
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&registers) == 4
+	}, time.Second, time.Millisecond)
+}
+
+func TestRedisDriver_HeartbeatJitter(t *testing.T) {
+	rds := miniredis.RunT(t)
+	clock := newTestClock()
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithHeartbeatInterval(800*time.Millisecond),
+		redisdriver.WithHeartbeatJitter(0.5),
+		redisdriver.WithClock(clock))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+	ticker := clock.nextTicker(t)
+
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 20; i++ {
+		ticker.tick()
+	}
+	for i := 0; i < 21; i++ {
+		var d time.Duration
+		select {
+		case d = <-ticker.intervals:
+		case <-time.After(time.Second):
+			t.Fatal("no interval is set")
+		}
+		// 800ms ±400ms, capped below the 1s timeout.
+		require.GreaterOrEqual(t, d, 400*time.Millisecond)
+		require.Less(t, d, time.Second)
+		seen[d] = true
+	}
+	require.Greater(t, len(seen), 1)
+}
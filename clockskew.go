@@ -0,0 +1,64 @@
+package redisdriver
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// clockSkewAlert is the setting of WithClockSkewAlert.
+type clockSkewAlert struct {
+	threshold time.Duration
+	callback  func(skew time.Duration)
+}
+
+// ClockSkew returns how far the clock of redis is ahead of the local clock,
+// negative if it is behind. The local time is taken halfway through the
+// TIME call, so the round trip does not count as skew.
+func (rd *RedisDriver) ClockSkew(ctx context.Context) (time.Duration, error) {
+	begin := rd.clock.Now()
+	serverTime, err := rd.c.Time(ctx).Result()
+	if err != nil {
+		return 0, fmt.Errorf("get redis time: %w", err)
+	}
+	end := rd.clock.Now()
+	local := begin.Add(end.Sub(begin) / 2)
+	return serverTime.Sub(local), nil
+}
+
+// private function
+
+// watchClockSkew checks the clock skew on Start and then every heartbeat
+// interval until the driver stops.
+func (rd *RedisDriver) watchClockSkew(ctx context.Context) {
+	tick := rd.clock.NewTicker(rd.effectiveHeartbeatInterval())
+	defer tick.Stop()
+	for {
+		rd.checkClockSkew(ctx)
+		select {
+		case <-tick.C():
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (rd *RedisDriver) checkClockSkew(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, rd.getCommandTimeout())
+	defer cancel()
+	skew, err := rd.ClockSkew(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			rd.log("clock_skew").Warnf("check clock skew error %+v", err)
+		}
+		return
+	}
+	threshold := rd.clockSkewAlert.threshold
+	if skew <= threshold && skew >= -threshold {
+		return
+	}
+	rd.log("clock_skew").Warnf("clock of redis is %v off the local clock, more than %v", skew, threshold)
+	if rd.clockSkewAlert.callback != nil {
+		rd.clockSkewAlert.callback(skew)
+	}
+}
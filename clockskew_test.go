@@ -0,0 +1,74 @@
+package redisdriver_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dcron-contrib/commons"
+	"github.com/dcron-contrib/commons/dlog"
+	"github.com/dcron-contrib/redisdriver"
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisDriver_ClockSkewAlert(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{
+		process: func(ctx context.Context, cmd redis.Cmder, next redis.ProcessHook) error {
+			if cmd.Name() != "time" {
+				return next(ctx, cmd)
+			}
+			// redis runs a minute ahead.
+			cmd.(*redis.TimeCmd).SetVal(time.Now().Add(time.Minute))
+			return nil
+		},
+	})
+	skews := make(chan time.Duration, 16)
+	drv.Init(t.Name(),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithClockSkewAlert(time.Second, func(skew time.Duration) {
+			skews <- skew
+		}))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+
+	select {
+	case skew := <-skews:
+		require.InDelta(t, float64(time.Minute), float64(skew), float64(time.Second))
+	case <-time.After(time.Second):
+		t.Fatal("clock skew is not reported")
+	}
+}
+
+func TestRedisDriver_ClockSkewAlertWithinThreshold(t *testing.T) {
+	rds := miniredis.RunT(t)
+	checked := make(chan struct{}, 16)
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{
+		process: func(ctx context.Context, cmd redis.Cmder, next redis.ProcessHook) error {
+			if cmd.Name() != "time" {
+				return next(ctx, cmd)
+			}
+			defer func() { checked <- struct{}{} }()
+			cmd.(*redis.TimeCmd).SetVal(time.Now().Add(-100 * time.Millisecond))
+			return nil
+		},
+	})
+	drv.Init(t.Name(),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithClockSkewAlert(time.Second, func(skew time.Duration) {
+			t.Errorf("unexpected clock skew %v", skew)
+		}))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+
+	select {
+	case <-checked:
+	case <-time.After(time.Second):
+		t.Fatal("clock skew is not checked")
+	}
+	skew, err := drv.ClockSkew(context.Background())
+	require.Nil(t, err)
+	require.InDelta(t, float64(-100*time.Millisecond), float64(skew), float64(50*time.Millisecond))
+}
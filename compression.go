@@ -0,0 +1,54 @@
+package redisdriver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+const (
+	// compressedFrame starts a gzip compressed node value: a zero byte, the
+	// magic "dcz" and the version of the frame. Neither the JSON encoding
+	// nor a bare node id starts with it, a Serializer must not either.
+	compressedFrame = "\x00dcz\x01"
+	// defaultCompressionThreshold is the smallest value that is compressed.
+	defaultCompressionThreshold = 512
+)
+
+// compressNodeValue gzips value if it reaches the compression threshold.
+func (rd *RedisDriver) compressNodeValue(value []byte) ([]byte, error) {
+	if rd.compressionThreshold <= 0 || len(value) < rd.compressionThreshold {
+		return value, nil
+	}
+	var buf bytes.Buffer
+	buf.WriteString(compressedFrame)
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(value); err != nil {
+		return nil, fmt.Errorf("compress node info: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("compress node info: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressNodeValue reverses compressNodeValue, it is applied whether
+// compression is enabled or not so that the nodes can enable it one by one.
+// Only a value in the compressed frame is decompressed, any other value,
+// e.g. a binary one of a custom Serializer, is returned as it is.
+func decompressNodeValue(value []byte) ([]byte, error) {
+	if !bytes.HasPrefix(value, []byte(compressedFrame)) {
+		return value, nil
+	}
+	r, err := gzip.NewReader(bytes.NewReader(value[len(compressedFrame):]))
+	if err != nil {
+		return nil, fmt.Errorf("decompress node info: %w", err)
+	}
+	defer r.Close()
+	decompressed, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("decompress node info: %w", err)
+	}
+	return decompressed, nil
+}
@@ -0,0 +1,149 @@
+package redisdriver_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dcron-contrib/commons"
+	"github.com/dcron-contrib/commons/dlog"
+	"github.com/dcron-contrib/redisdriver"
+	"github.com/stretchr/testify/require"
+)
+
+// testPaddedSerializer pads the JSON value with spaces to size bytes,
+// so that the size of the encoded value is known.
+type testPaddedSerializer struct {
+	redisdriver.JSONSerializer
+	size int
+}
+
+func (s testPaddedSerializer) Marshal(info redisdriver.NodeInfo) ([]byte, error) {
+	value, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	if len(value) > s.size {
+		return nil, fmt.Errorf("value size %d exceeds %d", len(value), s.size)
+	}
+	return append(value, []byte(strings.Repeat(" ", s.size-len(value)))...), nil
+}
+
+func TestRedisDriver_MetadataCompression(t *testing.T) {
+	rds := miniredis.RunT(t)
+	labels := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		labels[fmt.Sprintf("label-%03d", i)] = strings.Repeat("v", 40)
+	}
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(5*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithNodeMetadata(labels),
+		redisdriver.WithMetadataCompression())
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+
+	value, err := rds.Get(testFuncNodeKey(t.Name(), drv.NodeID()))
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(value, "\x00dcz\x01"))
+	// the labels alone are above the 4KiB limit of the node value.
+	require.Less(t, len(value), 4096)
+
+	nodes, err := drv.GetNodesWithMeta(context.Background())
+	require.Nil(t, err)
+	require.Len(t, nodes, 1)
+	require.Equal(t, labels, nodes[0].Labels)
+
+	// a reader without the option decompresses too.
+	reader := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	reader.Init(t.Name(), commons.NewLoggerOption(dlog.NewLoggerForTest(t)))
+	nodes, err = reader.GetNodesWithMeta(context.Background())
+	require.Nil(t, err)
+	require.Len(t, nodes, 1)
+	require.Equal(t, labels, nodes[0].Labels)
+}
+
+func TestRedisDriver_MetadataCompressionThreshold(t *testing.T) {
+	const size = 1024
+	for _, tc := range []struct {
+		threshold  int
+		compressed bool
+	}{
+		{threshold: size - 1, compressed: true},
+		{threshold: size, compressed: true},
+		{threshold: size + 1, compressed: false},
+		{threshold: 0, compressed: false},
+	} {
+		t.Run(fmt.Sprint(tc.threshold), func(t *testing.T) {
+			rds := miniredis.RunT(t)
+			drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+			drv.Init(t.Name(),
+				commons.NewTimeoutOption(5*time.Second),
+				commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+				redisdriver.WithSerializer(testPaddedSerializer{size: size}),
+				redisdriver.MetadataCompressionOption{Threshold: tc.threshold})
+			require.Nil(t, drv.Start(context.Background()))
+			defer testFuncStop(t, rds, drv)
+
+			value, err := rds.Get(testFuncNodeKey(t.Name(), drv.NodeID()))
+			require.Nil(t, err)
+			if tc.compressed {
+				require.True(t, strings.HasPrefix(value, "\x00dcz\x01"))
+				require.Less(t, len(value), size)
+			} else {
+				require.Equal(t, byte('{'), value[0])
+				require.Len(t, value, size)
+			}
+			self, ok, err := drv.GetSelfNode(context.Background())
+			require.Nil(t, err)
+			require.True(t, ok)
+			require.Equal(t, drv.NodeID(), self.ID)
+		})
+	}
+}
+
+func TestRedisDriver_MetadataCompressionOptionInvalid(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name())
+	require.ErrorIs(t, drv.WithOption(redisdriver.MetadataCompressionOption{Threshold: -1}), redisdriver.ErrInvalidOption)
+}
+
+// testBinarySerializer writes the node id behind a zero byte, like a
+// binary encoding whose first field is zero.
+type testBinarySerializer struct{}
+
+func (testBinarySerializer) Marshal(info redisdriver.NodeInfo) ([]byte, error) {
+	return append([]byte{0}, info.Hostname...), nil
+}
+
+func (testBinarySerializer) Unmarshal(data []byte) (redisdriver.NodeInfo, error) {
+	if len(data) == 0 || data[0] != 0 {
+		return redisdriver.NodeInfo{}, fmt.Errorf("unexpected value %q", data)
+	}
+	return redisdriver.NodeInfo{Hostname: string(data[1:])}, nil
+}
+
+func TestRedisDriver_BinarySerializer(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(5*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithSerializer(testBinarySerializer{}))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+
+	// a value starting with a zero byte is no compressed one.
+	self, ok, err := drv.GetSelfNode(context.Background())
+	require.Nil(t, err)
+	require.True(t, ok)
+	hostname, _ := os.Hostname()
+	require.Equal(t, hostname, self.Hostname)
+}
@@ -0,0 +1,89 @@
+package redisdriver
+
+import (
+	"time"
+
+	"github.com/dcron-contrib/commons"
+	"github.com/dcron-contrib/commons/dlog"
+	redis "github.com/redis/go-redis/v9"
+)
+
+// Config bundles the settings of a RedisDriver, zero values keep the defaults.
+type Config struct {
+	// Timeout is the TTL of the node key.
+	Timeout time.Duration
+	// MinTimeout replaces the smallest accepted Timeout, 1s by default.
+	MinTimeout time.Duration
+	// HeartbeatInterval is the refresh period of the node key,
+	// it must be less than Timeout.
+	HeartbeatInterval time.Duration
+	Logger            dlog.Logger
+	KeyPrefix         string
+	ScanCount         int64
+	// NodeID replaces the generated node id.
+	NodeID string
+	// Addr is the host:port of the redis server, NewDriverWithConfig
+	// creates its client on it if no client is given.
+	Addr string
+	// DB is the logical database of the node keys. A client created from
+	// Addr selects it, a given client must already select it, see WithDB.
+	DB int
+}
+
+// NewDriverWithConfig creates a driver like NewDriver and applies cfg,
+// an invalid cfg is reported as an error wrapping ErrInvalidOption.
+// Without redisClient the driver creates its own client on cfg.Addr and
+// cfg.DB, there must be one of them.
+func NewDriverWithConfig(redisClient redis.UniversalClient, cfg Config) (*RedisDriver, error) {
+	if redisClient == nil {
+		if cfg.Addr == "" {
+			return nil, ErrNilClient
+		}
+		client := redis.NewClient(&redis.Options{Addr: cfg.Addr, DB: cfg.DB})
+		rd, err := newDriverWithConfig(client, cfg)
+		if err != nil {
+			client.Close()
+		}
+		return rd, err
+	}
+	return newDriverWithConfig(redisClient, cfg)
+}
+
+func newDriverWithConfig(redisClient redis.UniversalClient, cfg Config) (*RedisDriver, error) {
+	rd := NewDriver(redisClient)
+	for _, opt := range cfg.options() {
+		if err := rd.WithOption(opt); err != nil {
+			return nil, err
+		}
+	}
+	return rd, nil
+}
+
+func (cfg Config) options() []commons.Option {
+	opts := make([]commons.Option, 0)
+	if cfg.MinTimeout != 0 {
+		opts = append(opts, WithMinTimeout(cfg.MinTimeout))
+	}
+	if cfg.Timeout != 0 {
+		opts = append(opts, commons.NewTimeoutOption(cfg.Timeout))
+	}
+	if cfg.HeartbeatInterval != 0 {
+		opts = append(opts, WithHeartbeatInterval(cfg.HeartbeatInterval))
+	}
+	if cfg.Logger != nil {
+		opts = append(opts, commons.NewLoggerOption(cfg.Logger))
+	}
+	if cfg.KeyPrefix != "" {
+		opts = append(opts, WithKeyPrefix(cfg.KeyPrefix))
+	}
+	if cfg.ScanCount != 0 {
+		opts = append(opts, WithScanCount(cfg.ScanCount))
+	}
+	if cfg.DB != 0 {
+		opts = append(opts, WithDB(cfg.DB))
+	}
+	if cfg.NodeID != "" {
+		opts = append(opts, WithNodeID(cfg.NodeID))
+	}
+	return opts
+}
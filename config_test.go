@@ -0,0 +1,91 @@
+package redisdriver_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dcron-contrib/commons/dlog"
+	"github.com/dcron-contrib/redisdriver"
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDriverWithConfig(t *testing.T) {
+	rds := miniredis.RunT(t)
+	redisCli := redis.NewClient(&redis.Options{
+		Addr: rds.Addr(),
+	})
+	drv, err := redisdriver.NewDriverWithConfig(redisCli, redisdriver.Config{
+		Timeout:           2 * time.Second,
+		HeartbeatInterval: 500 * time.Millisecond,
+		Logger:            dlog.NewLoggerForTest(t),
+		KeyPrefix:         "prod:",
+		ScanCount:         10,
+		NodeID:            "node-1",
+	})
+	require.Nil(t, err)
+	drv.Init(t.Name())
+	require.Equal(t, "node-1", drv.NodeID())
+
+	require.Nil(t, drv.Start(context.Background()))
+	key := "prod:" + testFuncNodeKey(t.Name(), "node-1")
+	require.True(t, rds.Exists(key))
+	require.Equal(t, 2*time.Second, rds.TTL(key))
+	nodes, err := drv.GetNodes(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, []string{"node-1"}, nodes)
+	require.Nil(t, drv.Stop(context.Background()))
+}
+
+func TestNewDriverWithConfigMinTimeout(t *testing.T) {
+	rds := miniredis.RunT(t)
+	redisCli := redis.NewClient(&redis.Options{
+		Addr: rds.Addr(),
+	})
+	_, err := redisdriver.NewDriverWithConfig(redisCli, redisdriver.Config{
+		Timeout:    500 * time.Millisecond,
+		MinTimeout: 100 * time.Millisecond,
+	})
+	require.Nil(t, err)
+}
+
+func TestNewDriverWithConfigInvalid(t *testing.T) {
+	rds := miniredis.RunT(t)
+	redisCli := redis.NewClient(&redis.Options{
+		Addr: rds.Addr(),
+	})
+	for _, cfg := range []redisdriver.Config{
+		{Timeout: -time.Second},
+		{Timeout: time.Second, HeartbeatInterval: time.Second},
+		{HeartbeatInterval: -time.Second},
+		{ScanCount: -1},
+		{Timeout: 500 * time.Millisecond},
+		{MinTimeout: -time.Second},
+	} {
+		_, err := redisdriver.NewDriverWithConfig(redisCli, cfg)
+		require.ErrorIs(t, err, redisdriver.ErrInvalidOption, "%+v", cfg)
+	}
+}
+
+func TestNewDriverWithConfigAddr(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv, err := redisdriver.NewDriverWithConfig(nil, redisdriver.Config{
+		Addr:   rds.Addr(),
+		DB:     3,
+		Logger: dlog.NewLoggerForTest(t),
+	})
+	require.Nil(t, err)
+	defer drv.Client().Close()
+	drv.Init(t.Name())
+
+	// the created client selects the db of the config.
+	require.Nil(t, drv.Start(context.Background()))
+	require.True(t, rds.DB(3).Exists(testFuncNodeKey(t.Name(), drv.NodeID())))
+	require.False(t, rds.Exists(testFuncNodeKey(t.Name(), drv.NodeID())))
+	require.Nil(t, drv.Stop(context.Background()))
+
+	_, err = redisdriver.NewDriverWithConfig(nil, redisdriver.Config{Addr: rds.Addr(), DB: -1})
+	require.ErrorIs(t, err, redisdriver.ErrInvalidOption)
+}
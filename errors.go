@@ -0,0 +1,51 @@
+package redisdriver
+
+import "errors"
+
+var (
+	// ErrNotStarted is returned when an operation requires a started driver,
+	// e.g. calling Stop on a driver that was never started.
+	ErrNotStarted = errors.New("this driver is not started")
+	// ErrAlreadyStarted is returned by Start when the driver is running.
+	ErrAlreadyStarted = errors.New("this driver is started")
+	// ErrNilContext is returned by Start when it is called with a nil context.
+	ErrNilContext = errors.New("context must not be nil")
+	// ErrInvalidOption is returned by WithOption when an option value
+	// is rejected. The returned error wraps it with the reason.
+	ErrInvalidOption = errors.New("invalid option")
+	// ErrEmptyServiceName is returned by Start when Init got an empty service
+	// name, and by RegisterService for an empty name.
+	ErrEmptyServiceName = errors.New("service name must not be empty")
+	// ErrNilClient is reported when a driver is created without redis client.
+	ErrNilClient = errors.New("redis client must not be nil")
+	// ErrNodeIDCollision is returned by Start when the node key is held by
+	// another process with the same node ID. The key of a crashed process
+	// blocks the node ID until it expires.
+	ErrNodeIDCollision = errors.New("node id is used by another process")
+	// ErrStaleNodes is wrapped by the error of GetNodes when it returns the
+	// latest known nodes because the scan failed, see WithLastKnownFallback.
+	ErrStaleNodes = errors.New("nodes are stale")
+	// ErrPartialScan is wrapped by the error of a scan that failed midway
+	// and returned the keys found so far, see WithLenientScan.
+	ErrPartialScan = errors.New("scan is incomplete")
+	// ErrPagesUnsupported is returned by GetNodesPage when the node keys
+	// may be spread over the masters of a cluster, or are stored in a hash.
+	ErrPagesUnsupported = errors.New("node pages need all node keys on one redis node")
+	// ErrMutexNotHeld is returned by Mutex.Unlock when the mutex is not
+	// locked by it, e.g. its lease expired and another holder took it.
+	ErrMutexNotHeld = errors.New("mutex is not held")
+)
+
+// staleNodesError wraps the scan error and matches ErrStaleNodes.
+type staleNodesError struct{ err error }
+
+func (e *staleNodesError) Error() string        { return ErrStaleNodes.Error() + ": " + e.err.Error() }
+func (e *staleNodesError) Unwrap() error        { return e.err }
+func (e *staleNodesError) Is(target error) bool { return target == ErrStaleNodes }
+
+// partialScanError wraps the scan error and matches ErrPartialScan.
+type partialScanError struct{ err error }
+
+func (e *partialScanError) Error() string        { return ErrPartialScan.Error() + ": " + e.err.Error() }
+func (e *partialScanError) Unwrap() error        { return e.err }
+func (e *partialScanError) Is(target error) bool { return target == ErrPartialScan }
@@ -0,0 +1,198 @@
+package redisdriver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// hashCountScript counts the fields of the heartbeat hash KEYS[1] written
+// at or after ARGV[1], in unix milliseconds.
+var hashCountScript = redis.NewScript(`
+local count = 0
+local values = redis.call("HVALS", KEYS[1])
+for _, value in ipairs(values) do
+	if tonumber(value) >= tonumber(ARGV[1]) then
+		count = count + 1
+	end
+end
+return count`)
+
+// hashCleanupScript deletes the field ARGV[1] of the hash KEYS[1] only if
+// its value is still ARGV[2], a node that refreshed meanwhile keeps it.
+var hashCleanupScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], ARGV[1]) == ARGV[2] then
+	return redis.call("HDEL", KEYS[1], ARGV[1])
+end
+return 0`)
+
+// hashKey is the hash holding all nodes of the service with
+// WithHashStorage, one field per node id.
+func (rd *RedisDriver) hashKey() string {
+	return rd.serviceKey("nodes")
+}
+
+// heartbeatsKey is the hash of the heartbeat times in unix milliseconds
+// next to hashKey, so that the nodes are counted without decoding them.
+func (rd *RedisDriver) heartbeatsKey() string {
+	return rd.serviceKey("heartbeats")
+}
+
+// writeHashNode writes the field of this node and extends the TTL of the
+// hash, it reports whether the field was missing. With claim the field is
+// only written if no other driver instance holds it, the check and the
+// write are separate calls.
+func (rd *RedisDriver) writeHashNode(ctx context.Context, value string, timeout time.Duration, claim bool) (missing bool, err error) {
+	ctx, span := rd.startSpan(ctx, "redisdriver.register", "HSET")
+	defer func() { endSpan(span, err) }()
+	key := rd.hashKey()
+	if claim {
+		current, err := rd.c.HGet(ctx, key, rd.nodeID).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return false, err
+		}
+		// an expired field is free as well
+		if err == nil {
+			info, err := rd.decodeNodeValue(rd.nodeID, key, current)
+			if err != nil || (info.Instance != rd.instance && !rd.hashExpired(info, rd.clock.Now(), timeout)) {
+				return false, fmt.Errorf("%w: node %s", ErrNodeIDCollision, rd.nodeID)
+			}
+		}
+	}
+	var added *redis.IntCmd
+	pipelined := rd.c.TxPipelined
+	if !rd.sameSlot(key, rd.heartbeatsKey()) {
+		// without cluster mode the hashes may be on different masters
+		// of a cluster, so the writes are not one transaction.
+		pipelined = rd.c.Pipelined
+	}
+	_, err = pipelined(ctx, func(pipe redis.Pipeliner) error {
+		added = pipe.HSet(ctx, key, rd.nodeID, value)
+		pipe.HSet(ctx, rd.heartbeatsKey(), rd.nodeID, rd.clock.Now().UnixMilli())
+		// the hashes go away once no node refreshes them.
+		pipe.PExpire(ctx, key, timeout)
+		pipe.PExpire(ctx, rd.heartbeatsKey(), timeout)
+		if rd.registerHook != nil {
+			return rd.runRegisterHook(ctx, pipe)
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return added.Val() == 1, nil
+}
+
+// hashNodes reads the alive nodes from the hash. The fields whose latest
+// heartbeat is older than the timeout are removed.
+func (rd *RedisDriver) hashNodes(ctx context.Context) ([]NodeInfo, error) {
+	if err := rd.waitScan(ctx); err != nil {
+		return nil, err
+	}
+	return rd.readHashNodes(ctx)
+}
+
+func (rd *RedisDriver) readHashNodes(ctx context.Context) ([]NodeInfo, error) {
+	key := rd.hashKey()
+	values, err := rd.readClient().HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("get node hash: %w", err)
+	}
+	now, timeout := rd.clock.Now(), rd.getTimeout()
+	nodes := make([]NodeInfo, 0, len(values))
+	for nodeID, value := range values {
+		info, err := rd.decodeNodeValue(nodeID, key, value)
+		if err != nil {
+			rd.log("get_nodes").Warnf("decode node field=%s value error=%v", nodeID, err)
+			continue
+		}
+		if rd.hashExpired(info, now, timeout) {
+			rd.deleteExpiredField(ctx, nodeID, value)
+			continue
+		}
+		nodes = append(nodes, info)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes, nil
+}
+
+// deleteExpiredField deletes the expired field of nodeID unless it was
+// refreshed since value was read.
+func (rd *RedisDriver) deleteExpiredField(ctx context.Context, nodeID, value string) {
+	deleted, err := hashCleanupScript.Run(ctx, rd.c, []string{rd.hashKey()}, nodeID, value).Int()
+	if err == nil && deleted == 1 {
+		err = rd.c.HDel(ctx, rd.heartbeatsKey(), nodeID).Err()
+	}
+	if err != nil {
+		rd.log("get_nodes").Warnf("delete expired node field=%s error %+v", nodeID, err)
+	}
+}
+
+// hashNodeCount counts the nodes with a heartbeat within the timeout by
+// one script, without reading the node values. If redis rejects the
+// script, e.g. scripting is disabled, the nodes are read and counted.
+func (rd *RedisDriver) hashNodeCount(ctx context.Context) (int, error) {
+	if err := rd.waitScan(ctx); err != nil {
+		return 0, err
+	}
+	since := rd.clock.Now().Add(-rd.getTimeout()).UnixMilli()
+	count, err := hashCountScript.Run(ctx, rd.readClient(), []string{rd.heartbeatsKey()}, since).Int()
+	var redisErr redis.Error
+	if errors.As(err, &redisErr) {
+		rd.log("get_nodes").Warnf("count nodes script error %+v, fall back to reading the nodes", err)
+		nodes, err := rd.readHashNodes(ctx)
+		if err != nil {
+			return 0, err
+		}
+		rd.metrics.SetNodeCount(len(nodes))
+		return len(nodes), nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("count nodes: %w", err)
+	}
+	rd.metrics.SetNodeCount(count)
+	return count, nil
+}
+
+// hashNodeIDs returns the sorted ids of the alive nodes in the hash.
+func (rd *RedisDriver) hashNodeIDs(ctx context.Context) ([]string, error) {
+	nodes, err := rd.hashNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		ids = append(ids, node.ID)
+	}
+	rd.metrics.SetNodeCount(len(ids))
+	return ids, nil
+}
+
+// hashSelfNode reads the field of this node, an expired field is missing.
+func (rd *RedisDriver) hashSelfNode(ctx context.Context) (info NodeInfo, ok bool, err error) {
+	key := rd.hashKey()
+	value, err := rd.c.HGet(ctx, key, rd.nodeID).Result()
+	if errors.Is(err, redis.Nil) {
+		return info, false, nil
+	}
+	if err != nil {
+		return info, false, fmt.Errorf("get self node: %w", err)
+	}
+	if info, err = rd.decodeNodeValue(rd.nodeID, key, value); err != nil {
+		return info, true, fmt.Errorf("decode self node: %w", err)
+	}
+	if rd.hashExpired(info, rd.clock.Now(), rd.getTimeout()) {
+		return NodeInfo{}, false, nil
+	}
+	return info, true, nil
+}
+
+// hashExpired reports whether the latest heartbeat of info is older than
+// the timeout at now. A value without heartbeat time is expired.
+func (rd *RedisDriver) hashExpired(info NodeInfo, now time.Time, timeout time.Duration) bool {
+	return info.UpdatedAt.IsZero() || now.Sub(info.UpdatedAt) > timeout
+}
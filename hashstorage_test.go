@@ -0,0 +1,205 @@
+package redisdriver_test
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dcron-contrib/commons"
+	"github.com/dcron-contrib/commons/dlog"
+	"github.com/dcron-contrib/redisdriver"
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func testFuncHashKey(serviceName string) string {
+	return strings.TrimSuffix(commons.GetKeyPre(serviceName), ":") + "@nodes"
+}
+
+func testFuncHashFields(t *testing.T, rds *miniredis.Miniredis, key string) []string {
+	fields, err := rds.HKeys(key)
+	require.Nil(t, err)
+	return fields
+}
+
+func TestRedisDriver_HashStorage(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drvs := make([]*redisdriver.RedisDriver, 2)
+	for i := range drvs {
+		drvs[i] = testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+		drvs[i].Init(t.Name(),
+			commons.NewTimeoutOption(time.Second),
+			commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+			redisdriver.WithHashStorage())
+		require.Nil(t, drvs[i].Start(context.Background()))
+	}
+	key := testFuncHashKey(t.Name())
+	require.ElementsMatch(t, []string{drvs[0].NodeID(), drvs[1].NodeID()}, testFuncHashFields(t, rds, key))
+	require.Equal(t, time.Second, rds.TTL(key))
+	require.False(t, rds.Exists(testFuncNodeKey(t.Name(), drvs[0].NodeID())))
+
+	nodes, err := drvs[0].GetNodes(context.Background())
+	require.Nil(t, err)
+	require.ElementsMatch(t, []string{drvs[0].NodeID(), drvs[1].NodeID()}, nodes)
+	count, err := drvs[0].GetNodeCount(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, 2, count)
+	self, ok, err := drvs[1].GetSelfNode(context.Background())
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.Equal(t, drvs[1].NodeID(), self.ID)
+	_, _, err = drvs[0].GetNodesPage(context.Background(), 0, 0)
+	require.ErrorIs(t, err, redisdriver.ErrPagesUnsupported)
+
+	// Stop removes the field of the node only.
+	require.Nil(t, drvs[1].Stop(context.Background()))
+	require.Equal(t, []string{drvs[0].NodeID()}, testFuncHashFields(t, rds, key))
+	nodes, err = drvs[0].GetNodes(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, []string{drvs[0].NodeID()}, nodes)
+	require.Nil(t, drvs[0].Stop(context.Background()))
+	require.False(t, rds.Exists(key))
+}
+
+func TestRedisDriver_HashStorageExpired(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithHashStorage())
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+
+	// a crashed node stopped refreshing its field 2s ago.
+	key := testFuncHashKey(t.Name())
+	rds.HSet(key, "crashed", fmt.Sprintf(`{"id":"crashed","updated_at":%q}`,
+		time.Now().Add(-2*time.Second).Format(time.RFC3339Nano)))
+	rds.HSet(key, "alive", fmt.Sprintf(`{"id":"alive","updated_at":%q}`,
+		time.Now().Add(-500*time.Millisecond).Format(time.RFC3339Nano)))
+
+	nodes, err := drv.GetNodesWithMeta(context.Background())
+	require.Nil(t, err)
+	ids := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		ids = append(ids, node.ID)
+	}
+	require.ElementsMatch(t, []string{drv.NodeID(), "alive"}, ids)
+	// the expired field is cleaned up by the reader.
+	require.ElementsMatch(t, []string{drv.NodeID(), "alive"}, testFuncHashFields(t, rds, key))
+}
+
+func TestRedisDriver_HashStorageCollision(t *testing.T) {
+	rds := miniredis.RunT(t)
+	redisCli := redis.NewClient(&redis.Options{Addr: rds.Addr()})
+	drvs := make([]*redisdriver.RedisDriver, 2)
+	for i := range drvs {
+		drv, err := redisdriver.NewDriverWithConfig(redisCli, redisdriver.Config{
+			Logger: dlog.NewLoggerForTest(t),
+			NodeID: "node-1",
+		})
+		require.Nil(t, err)
+		drv.Init(t.Name(), redisdriver.WithHashStorage())
+		drvs[i] = drv
+	}
+	require.Nil(t, drvs[0].Start(context.Background()))
+	require.ErrorIs(t, drvs[1].Start(context.Background()), redisdriver.ErrNodeIDCollision)
+	require.Nil(t, drvs[0].Stop(context.Background()))
+}
+
+func TestRedisDriver_HashStorageRegistrationLost(t *testing.T) {
+	rds := miniredis.RunT(t)
+	lost := make(chan struct{}, 1)
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name(),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithHashStorage(),
+		redisdriver.WithOnRegistrationLost(func() { lost <- struct{}{} }))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+
+	rds.HDel(testFuncHashKey(t.Name()), drv.NodeID())
+	require.Nil(t, drv.Tick(context.Background()))
+	select {
+	case <-lost:
+	case <-time.After(time.Second):
+		t.Fatal("registration lost is not reported")
+	}
+}
+
+func TestRedisDriver_HashStorageNodeCount(t *testing.T) {
+	rds := miniredis.RunT(t)
+	var scripts int32
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{
+		process: func(ctx context.Context, cmd redis.Cmder, next redis.ProcessHook) error {
+			if cmd.Name() == "evalsha" || cmd.Name() == "eval" {
+				atomic.AddInt32(&scripts, 1)
+			}
+			return next(ctx, cmd)
+		},
+	})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithHashStorage())
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+
+	heartbeats := strings.TrimSuffix(testFuncHashKey(t.Name()), "nodes") + "heartbeats"
+	require.Equal(t, []string{drv.NodeID()}, testFuncHashFields(t, rds, heartbeats))
+	rds.HSet(heartbeats, "alive", strconv.FormatInt(time.Now().Add(-500*time.Millisecond).UnixMilli(), 10))
+	rds.HSet(heartbeats, "crashed", strconv.FormatInt(time.Now().Add(-2*time.Second).UnixMilli(), 10))
+	count, err := drv.GetNodeCount(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, 2, count)
+	require.Greater(t, atomic.LoadInt32(&scripts), int32(0))
+
+	// Stop removes the heartbeat of the node too.
+	require.Nil(t, drv.Stop(context.Background()))
+	require.ElementsMatch(t, []string{"alive", "crashed"}, testFuncHashFields(t, rds, heartbeats))
+	require.Nil(t, drv.Start(context.Background()))
+}
+
+func benchmarkFuncHashCountDriver(b *testing.B) *redisdriver.RedisDriver {
+	rds := miniredis.RunT(b)
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(b.Name(), redisdriver.WithHashStorage())
+	key := testFuncHashKey(b.Name())
+	heartbeats := strings.TrimSuffix(key, "nodes") + "heartbeats"
+	now := time.Now()
+	for i := 0; i < 10000; i++ {
+		nodeID := fmt.Sprintf("node-%d", i)
+		rds.HSet(key, nodeID, fmt.Sprintf(`{"id":%q,"updated_at":%q}`, nodeID, now.Format(time.RFC3339Nano)))
+		rds.HSet(heartbeats, nodeID, strconv.FormatInt(now.UnixMilli(), 10))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	return drv
+}
+
+// BenchmarkRedisDriver_GetNodeCountHash counts by the script, compare it
+// with BenchmarkRedisDriver_GetNodeCount of the key per node storage.
+func BenchmarkRedisDriver_GetNodeCountHash(b *testing.B) {
+	drv := benchmarkFuncHashCountDriver(b)
+	for i := 0; i < b.N; i++ {
+		if _, err := drv.GetNodeCount(context.Background()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRedisDriver_GetNodesLenHash(b *testing.B) {
+	drv := benchmarkFuncHashCountDriver(b)
+	for i := 0; i < b.N; i++ {
+		nodes, err := drv.GetNodes(context.Background())
+		if err != nil {
+			b.Fatal(err)
+		}
+		_ = len(nodes)
+	}
+}
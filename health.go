@@ -0,0 +1,45 @@
+package redisdriver
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// HealthStatus is the result of Healthcheck.
+type HealthStatus struct {
+	// Connected reports whether redis answered the PING.
+	Connected bool
+	// Latency is the round trip time of the PING.
+	Latency time.Duration
+	// Started reports whether the driver is running.
+	Started bool
+	// LastHeartbeat is the time the node key was last written,
+	// zero if it never was.
+	LastHeartbeat time.Time
+}
+
+// Healthcheck pings redis and reports the state of the driver, it is meant
+// for readiness probes. The returned status is filled also when the PING
+// fails, together with the error.
+func (rd *RedisDriver) Healthcheck(ctx context.Context) (status HealthStatus, err error) {
+	status.Started = rd.IsStarted()
+	status.LastHeartbeat = rd.LastHeartbeat()
+
+	begin := rd.clock.Now()
+	err = rd.c.Ping(ctx).Err()
+	status.Latency = rd.clock.Now().Sub(begin)
+	if err != nil {
+		return status, fmt.Errorf("ping redis: %w", err)
+	}
+	status.Connected = true
+	return status, nil
+}
+
+// LastHeartbeat returns the time the node key was last written successfully,
+// zero if it never was. A gap longer than the timeout means the key expired.
+func (rd *RedisDriver) LastHeartbeat() time.Time {
+	rd.statusMu.Lock()
+	defer rd.statusMu.Unlock()
+	return rd.lastHeartbeatOK
+}
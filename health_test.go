@@ -0,0 +1,85 @@
+package redisdriver_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dcron-contrib/commons"
+	"github.com/dcron-contrib/commons/dlog"
+	"github.com/dcron-contrib/redisdriver"
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisDriver_Healthcheck(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name(), commons.NewLoggerOption(dlog.NewLoggerForTest(t)))
+
+	status, err := drv.Healthcheck(context.Background())
+	require.Nil(t, err)
+	require.True(t, status.Connected)
+	require.False(t, status.Started)
+	require.True(t, status.LastHeartbeat.IsZero())
+
+	begin := time.Now()
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+	status, err = drv.Healthcheck(context.Background())
+	require.Nil(t, err)
+	require.True(t, status.Connected)
+	require.True(t, status.Started)
+	require.Greater(t, status.Latency, time.Duration(0))
+	require.False(t, status.LastHeartbeat.Before(begin))
+
+	rds.SetError("server is down")
+	status, err = drv.Healthcheck(context.Background())
+	require.NotNil(t, err)
+	require.False(t, status.Connected)
+	require.True(t, status.Started)
+	rds.SetError("")
+}
+
+func TestRedisDriver_LastHeartbeat(t *testing.T) {
+	rds := miniredis.RunT(t)
+	clock := newTestClock()
+	var failing int32
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{
+		process: func(ctx context.Context, cmd redis.Cmder, next redis.ProcessHook) error {
+			if cmd.Name() == "setex" && atomic.LoadInt32(&failing) == 1 {
+				cmd.SetErr(errors.New("heartbeat failed"))
+				return cmd.Err()
+			}
+			return next(ctx, cmd)
+		},
+	})
+	drv.Init(t.Name(),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithMaxRetries(0),
+		redisdriver.WithClock(clock))
+	require.True(t, drv.LastHeartbeat().IsZero())
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+	ticker := clock.nextTicker(t)
+	first := drv.LastHeartbeat()
+	require.False(t, first.IsZero())
+
+	time.Sleep(10 * time.Millisecond)
+	ticker.tick()
+	require.Eventually(t, func() bool {
+		return drv.LastHeartbeat().After(first)
+	}, time.Second, 10*time.Millisecond)
+
+	atomic.StoreInt32(&failing, 1)
+	last := drv.LastHeartbeat()
+	// a tick is received once the previous one is handled.
+	for i := 0; i < 4; i++ {
+		ticker.tick()
+	}
+	require.Equal(t, last, drv.LastHeartbeat())
+	atomic.StoreInt32(&failing, 0)
+}
@@ -0,0 +1,68 @@
+package redisdriver
+
+import (
+	"context"
+	"fmt"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// NodeIterator streams the node ids of a service, see NodesIterator.
+type NodeIterator struct {
+	rd   *RedisDriver
+	ctx  context.Context
+	iter *redis.ScanIterator
+	val  string
+	err  error
+}
+
+// NodesIterator iterates the node ids of this service without loading
+// them all, it scans the node keys page by page while Next is called.
+// Like GetNodesPage, nodes that join or leave meanwhile may be missed and
+// a node may be returned more than once. The iteration stops with the
+// error of ctx once ctx is done.
+func (rd *RedisDriver) NodesIterator(ctx context.Context) *NodeIterator {
+	it := &NodeIterator{rd: rd, ctx: ctx}
+	client, err := rd.pageClient(ctx)
+	if err != nil {
+		it.err = err
+		return it
+	}
+	it.iter = client.Scan(ctx, 0, rd.nodeMatch(), rd.scanCount).Iterator()
+	return it
+}
+
+// Next advances to the next node id, it returns false at the end of the
+// iteration or on an error, Err tells them apart.
+func (it *NodeIterator) Next() bool {
+	if it.err != nil || it.iter == nil {
+		return false
+	}
+	for {
+		if err := it.ctx.Err(); err != nil {
+			it.err = err
+			return false
+		}
+		if !it.iter.Next(it.ctx) {
+			if err := it.iter.Err(); err != nil {
+				it.err = fmt.Errorf("scan node keys: %w", err)
+			}
+			it.iter = nil
+			return false
+		}
+		if nodeID, ok := it.rd.scannedNodeID(it.iter.Val()); ok {
+			it.val = nodeID
+			return true
+		}
+	}
+}
+
+// Val returns the node id Next advanced to.
+func (it *NodeIterator) Val() string {
+	return it.val
+}
+
+// Err returns the error that stopped the iteration, nil at its end.
+func (it *NodeIterator) Err() error {
+	return it.err
+}
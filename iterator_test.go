@@ -0,0 +1,86 @@
+package redisdriver_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dcron-contrib/commons"
+	"github.com/dcron-contrib/commons/dlog"
+	"github.com/dcron-contrib/redisdriver"
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func testFuncIteratorDriver(t *testing.T, rds *miniredis.Miniredis, nodes int) *redisdriver.RedisDriver {
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(5*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithScanCount(10))
+	for i := 0; i < nodes; i++ {
+		require.Nil(t, rds.Set(testFuncNodeKey(t.Name(), fmt.Sprintf("node-%02d", i)), "{}"))
+	}
+	require.Nil(t, rds.Set(testFuncNodeKey(t.Name()+"-other", "node-x"), "{}"))
+	return drv
+}
+
+func TestRedisDriver_NodesIterator(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := testFuncIteratorDriver(t, rds, 45)
+
+	var ids []string
+	it := drv.NodesIterator(context.Background())
+	for it.Next() {
+		ids = append(ids, it.Val())
+	}
+	require.Nil(t, it.Err())
+	nodes, err := drv.GetNodes(context.Background())
+	require.Nil(t, err)
+	require.Len(t, nodes, 45)
+	require.ElementsMatch(t, nodes, ids)
+	require.False(t, it.Next())
+}
+
+func TestRedisDriver_NodesIteratorCancel(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := testFuncIteratorDriver(t, rds, 45)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	it := drv.NodesIterator(ctx)
+	for i := 0; i < 5; i++ {
+		require.True(t, it.Next())
+	}
+	cancel()
+	require.False(t, it.Next())
+	require.ErrorIs(t, it.Err(), context.Canceled)
+	require.False(t, it.Next())
+}
+
+func TestRedisDriver_NodesIteratorError(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := testFuncIteratorDriver(t, rds, 5)
+
+	rds.SetError("ERR injected")
+	it := drv.NodesIterator(context.Background())
+	require.False(t, it.Next())
+	rds.SetError("")
+	var redisErr redis.Error
+	require.ErrorAs(t, it.Err(), &redisErr)
+	require.Equal(t, "ERR injected", redisErr.Error())
+}
+
+func TestRedisDriver_NodesIteratorCluster(t *testing.T) {
+	rds := miniredis.RunT(t)
+	client := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{rds.Addr()}})
+	defer client.Close()
+	drv := redisdriver.NewDriver(client)
+	drv.Init(t.Name(), commons.NewLoggerOption(dlog.NewLoggerForTest(t)))
+
+	it := drv.NodesIterator(context.Background())
+	require.False(t, it.Next())
+	require.ErrorIs(t, it.Err(), redisdriver.ErrPagesUnsupported)
+}
@@ -0,0 +1,173 @@
+package redisdriver
+
+import (
+	"context"
+	"fmt"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+var (
+	// leaseRenewScript extends the lease only if it is still owned by ARGV[1].
+	leaseRenewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+	// leaseReleaseScript deletes the lease only if it is still owned by ARGV[1].
+	leaseReleaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+// TryAcquireLeadership tries to become the leader of this service.
+// The lease lasts for the driver timeout and is renewed in background
+// until ResignLeadership is called, the driver stops, or the lease is lost.
+func (rd *RedisDriver) TryAcquireLeadership(ctx context.Context) (ok bool, err error) {
+	rd.Lock()
+	if !rd.started {
+		rd.Unlock()
+		return false, ErrNotStarted
+	}
+	if rd.leader {
+		rd.Unlock()
+		return true, nil
+	}
+	runtimeCtx := rd.runtimeCtx
+	rd.Unlock()
+	// the SET runs without the driver lock, it must not block Start, Stop
+	// and the getters for a round trip.
+	spanCtx, span := rd.startSpan(ctx, "redisdriver.acquire_leadership", "SET")
+	ok, err = rd.c.SetNX(spanCtx, rd.leaderKey(), rd.nodeID, rd.getTimeout()).Result()
+	endSpan(span, err)
+	if err != nil {
+		return false, fmt.Errorf("acquire leadership: %w", err)
+	}
+	rd.Lock()
+	if !ok {
+		// a concurrent call of this node may have won it.
+		ok = rd.leader
+		rd.Unlock()
+		return
+	}
+	if !rd.started || rd.runtimeCtx != runtimeCtx {
+		rd.Unlock()
+		// stopped meanwhile, give the lease back to the other nodes.
+		releaseCtx, cancel := context.WithTimeout(context.Background(), rd.getCommandTimeout())
+		defer cancel()
+		if err := leaseReleaseScript.Run(releaseCtx, rd.c, []string{rd.leaderKey()}, rd.nodeID).Err(); err != nil {
+			rd.log("leader").Errorf("release leadership error %+v", err)
+		}
+		return false, ErrNotStarted
+	}
+	rd.leader = true
+	var leaseCtx context.Context
+	leaseCtx, rd.leaderCancel = context.WithCancel(runtimeCtx)
+	rd.Unlock()
+	go rd.renewLeadership(leaseCtx, runtimeCtx)
+	return
+}
+
+// ResignLeadership gives up the leadership. The lease is deleted
+// only if it is still held by this node.
+func (rd *RedisDriver) ResignLeadership(ctx context.Context) (err error) {
+	rd.Lock()
+	if !rd.leader {
+		rd.Unlock()
+		return
+	}
+	rd.leader = false
+	rd.leaderCancel()
+	rd.Unlock()
+	// the release runs without the driver lock, like the acquire.
+	ctx, cancel := context.WithTimeout(ctx, rd.getCommandTimeout())
+	defer cancel()
+	ctx, span := rd.startSpan(ctx, "redisdriver.resign_leadership", "EVALSHA")
+	defer func() { endSpan(span, err) }()
+	if err = leaseReleaseScript.Run(ctx, rd.c, []string{rd.leaderKey()}, rd.nodeID).Err(); err != nil {
+		return fmt.Errorf("resign leadership: %w", err)
+	}
+	return
+}
+
+// IsLeader reports whether this node currently holds the leadership.
+func (rd *RedisDriver) IsLeader() bool {
+	rd.Lock()
+	defer rd.Unlock()
+	return rd.leader
+}
+
+// private function
+
+func (rd *RedisDriver) leaderKey() string {
+	return rd.serviceKey("leader")
+}
+
+// renewLeadership renews the lease until ctx is done. runtimeCtx is the
+// runtime context of the run that acquired it, it tells a resign from a
+// stop of that run.
+func (rd *RedisDriver) renewLeadership(ctx, runtimeCtx context.Context) {
+	tick := rd.clock.NewTicker(rd.effectiveHeartbeatInterval())
+	defer tick.Stop()
+	renewedAt := rd.clock.Now()
+	for {
+		select {
+		case <-tick.C():
+			{
+				timeout := rd.getTimeout()
+				renewCtx, cancel := context.WithTimeout(ctx, rd.getCommandTimeout())
+				renewCtx, span := rd.startSpan(renewCtx, "redisdriver.renew_leadership", "EVALSHA")
+				renewed, err := leaseRenewScript.Run(renewCtx, rd.c, []string{rd.leaderKey()},
+					rd.nodeID, timeout.Milliseconds()).Int()
+				endSpan(span, err)
+				cancel()
+				if err != nil {
+					rd.log("leader").Warnf("renew leadership error %+v", err)
+				} else if renewed == 1 {
+					renewedAt = rd.clock.Now()
+					continue
+				}
+				if err == nil || rd.clock.Now().Sub(renewedAt) >= timeout {
+					rd.leadershipLost(ctx)
+					return
+				}
+			}
+		case <-ctx.Done():
+			{
+				if runtimeCtx.Err() == nil {
+					// resigned
+					return
+				}
+				// the driver is stopping, release the lease for the other nodes.
+				releaseCtx, cancel := context.WithTimeout(context.Background(), rd.getCommandTimeout())
+				if err := leaseReleaseScript.Run(releaseCtx, rd.c, []string{rd.leaderKey()}, rd.nodeID).Err(); err != nil {
+					rd.log("leader").Errorf("release leadership error %+v", err)
+				}
+				cancel()
+				rd.Lock()
+				rd.leader = false
+				rd.Unlock()
+				return
+			}
+		}
+	}
+}
+
+func (rd *RedisDriver) leadershipLost(ctx context.Context) {
+	rd.Lock()
+	if ctx.Err() != nil || !rd.leader {
+		// resigned in the meantime
+		rd.Unlock()
+		return
+	}
+	rd.leader = false
+	rd.leaderCancel()
+	callback := rd.onLeadershipLost
+	rd.Unlock()
+	rd.log("leader").Warnf("leadership of node %s is lost", rd.nodeID)
+	if callback != nil {
+		callback()
+	}
+}
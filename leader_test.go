@@ -0,0 +1,215 @@
+package redisdriver_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dcron-contrib/commons"
+	"github.com/dcron-contrib/commons/dlog"
+	"github.com/dcron-contrib/redisdriver"
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisDriver_Leadership(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv1 := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv1.Init(t.Name(),
+		commons.NewTimeoutOption(2*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithHeartbeatInterval(20*time.Millisecond))
+	drv2 := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv2.Init(t.Name(),
+		commons.NewTimeoutOption(2*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithHeartbeatInterval(20*time.Millisecond))
+
+	_, err := drv1.TryAcquireLeadership(context.Background())
+	require.ErrorIs(t, err, redisdriver.ErrNotStarted)
+
+	require.Nil(t, drv1.Start(context.Background()))
+	require.Nil(t, drv2.Start(context.Background()))
+	defer testFuncStop(t, rds, drv1)
+	defer testFuncStop(t, rds, drv2)
+
+	ok, err := drv1.TryAcquireLeadership(context.Background())
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.True(t, drv1.IsLeader())
+	ok, err = drv2.TryAcquireLeadership(context.Background())
+	require.Nil(t, err)
+	require.False(t, ok)
+	require.False(t, drv2.IsLeader())
+
+	// the lease is renewed past its initial ttl.
+	for i := 0; i < 3; i++ {
+		rds.FastForward(time.Second)
+		time.Sleep(50 * time.Millisecond)
+	}
+	ok, err = drv2.TryAcquireLeadership(context.Background())
+	require.Nil(t, err)
+	require.False(t, ok)
+
+	// resign of a non-leader does not touch the lease.
+	require.Nil(t, drv2.ResignLeadership(context.Background()))
+	require.True(t, drv1.IsLeader())
+
+	require.Nil(t, drv1.ResignLeadership(context.Background()))
+	require.False(t, drv1.IsLeader())
+	ok, err = drv2.TryAcquireLeadership(context.Background())
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.Nil(t, drv2.ResignLeadership(context.Background()))
+}
+
+func TestRedisDriver_LeadershipLost(t *testing.T) {
+	rds := miniredis.RunT(t)
+	lost := make(chan struct{}, 1)
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(2*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithHeartbeatInterval(20*time.Millisecond),
+		redisdriver.WithOnLeadershipLost(func() { lost <- struct{}{} }))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+
+	ok, err := drv.TryAcquireLeadership(context.Background())
+	require.Nil(t, err)
+	require.True(t, ok)
+
+	for _, key := range rds.Keys() {
+		if v, _ := rds.Get(key); v == drv.NodeID() {
+			rds.Set(key, "another-node")
+		}
+	}
+	select {
+	case <-lost:
+	case <-time.After(time.Second):
+		t.Fatal("leadership lost callback is not called")
+	}
+	require.False(t, drv.IsLeader())
+}
+
+func TestRedisDriver_LeadershipReleasedOnStop(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv1 := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv1.Init(t.Name(),
+		commons.NewTimeoutOption(2*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)))
+	drv2 := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv2.Init(t.Name(),
+		commons.NewTimeoutOption(2*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)))
+	require.Nil(t, drv1.Start(context.Background()))
+	require.Nil(t, drv2.Start(context.Background()))
+	defer testFuncStop(t, rds, drv2)
+
+	ok, err := drv1.TryAcquireLeadership(context.Background())
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.Nil(t, drv1.Stop(context.Background()))
+	// a new run does not confuse the release of the stopped one.
+	require.Nil(t, drv1.Start(context.Background()))
+	defer testFuncStop(t, rds, drv1)
+
+	require.Eventually(t, func() bool {
+		ok, err := drv2.TryAcquireLeadership(context.Background())
+		return err == nil && ok
+	}, time.Second, 10*time.Millisecond)
+	require.False(t, drv1.IsLeader())
+	require.Nil(t, drv2.ResignLeadership(context.Background()))
+}
+
+func TestRedisDriver_AcquireLeadershipUnlocked(t *testing.T) {
+	rds := miniredis.RunT(t)
+	acquiring := make(chan struct{})
+	release := make(chan struct{})
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{
+		process: func(ctx context.Context, cmd redis.Cmder, next redis.ProcessHook) error {
+			if cmd.Name() == "set" && strings.HasSuffix(fmt.Sprint(cmd.Args()[1]), "leader") {
+				close(acquiring)
+				<-release
+			}
+			return next(ctx, cmd)
+		},
+	})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(2*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+
+	acquired := make(chan bool)
+	acquireErr := make(chan error, 1)
+	go func() {
+		ok, err := drv.TryAcquireLeadership(context.Background())
+		acquireErr <- err
+		acquired <- ok
+	}()
+	<-acquiring
+	// the driver lock is free while the SET is on the wire.
+	started := make(chan bool)
+	go func() { started <- drv.IsStarted() }()
+	select {
+	case ok := <-started:
+		require.True(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("IsStarted is blocked by TryAcquireLeadership")
+	}
+	close(release)
+	require.Nil(t, <-acquireErr)
+	require.True(t, <-acquired)
+	require.True(t, drv.IsLeader())
+	require.Nil(t, drv.ResignLeadership(context.Background()))
+}
+
+func TestRedisDriver_ResignLeadershipUnlocked(t *testing.T) {
+	rds := miniredis.RunT(t)
+	var slow atomic.Bool
+	releasing := make(chan struct{}, 1)
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{
+		process: func(ctx context.Context, cmd redis.Cmder, next redis.ProcessHook) error {
+			if slow.Load() && (cmd.Name() == "evalsha" || cmd.Name() == "eval") {
+				select {
+				case releasing <- struct{}{}:
+				default:
+				}
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			return next(ctx, cmd)
+		},
+	})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(2*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithCommandTimeout(200*time.Millisecond))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+	ok, err := drv.TryAcquireLeadership(context.Background())
+	require.Nil(t, err)
+	require.True(t, ok)
+
+	slow.Store(true)
+	resigned := make(chan error)
+	go func() { resigned <- drv.ResignLeadership(context.Background()) }()
+	<-releasing
+	// the driver lock is free while the release is on the wire.
+	leader := make(chan bool)
+	go func() { leader <- drv.IsLeader() }()
+	select {
+	case ok := <-leader:
+		require.False(t, ok)
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("IsLeader is blocked by ResignLeadership")
+	}
+	// the release gives up at the command timeout.
+	require.ErrorIs(t, <-resigned, context.DeadlineExceeded)
+	slow.Store(false)
+}
@@ -0,0 +1,149 @@
+package redisdriver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/dcron-contrib/commons"
+	"github.com/dcron-contrib/commons/dlog"
+)
+
+// syncLogger forwards to a logger that can be swapped while
+// the driver goroutines are logging.
+type syncLogger struct {
+	mu sync.RWMutex
+	l  dlog.Logger
+}
+
+func newSyncLogger(l dlog.Logger) *syncLogger {
+	return &syncLogger{l: l}
+}
+
+func (sl *syncLogger) get() dlog.Logger {
+	sl.mu.RLock()
+	defer sl.mu.RUnlock()
+	return sl.l
+}
+
+func (sl *syncLogger) set(l dlog.Logger) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	sl.l = l
+}
+
+func (sl *syncLogger) Printf(format string, args ...any) { sl.get().Printf(format, args...) }
+func (sl *syncLogger) Infof(format string, args ...any)  { sl.get().Infof(format, args...) }
+func (sl *syncLogger) Warnf(format string, args ...any)  { sl.get().Warnf(format, args...) }
+func (sl *syncLogger) Errorf(format string, args ...any) { sl.get().Errorf(format, args...) }
+
+// NopLogger discards every message, see WithQuietLogger.
+type NopLogger struct{}
+
+func (NopLogger) Printf(format string, args ...any) {}
+func (NopLogger) Debugf(format string, args ...any) {}
+func (NopLogger) Infof(format string, args ...any)  {}
+func (NopLogger) Warnf(format string, args ...any)  {}
+func (NopLogger) Errorf(format string, args ...any) {}
+
+// WithQuietLogger silences the driver. The errors the driver returns are
+// not affected, only the messages of the background goroutines, e.g. of
+// failed heartbeats, are lost, Stats keeps their latest error.
+func WithQuietLogger() commons.LoggerOption {
+	return commons.NewLoggerOption(NopLogger{})
+}
+
+// Field is a key value pair attached to a log message.
+type Field struct {
+	Key   string
+	Value any
+}
+
+// FieldLogger is a logger that takes structured fields. When the driver
+// logger implements it, every message of the driver carries the fields
+// service, node_id and op. Other loggers get the plain messages.
+type FieldLogger interface {
+	dlog.Logger
+	WithFields(fields ...Field) dlog.Logger
+}
+
+// DebugLogger is a logger with a debug level below Infof. The driver logs
+// frequent details, e.g. the keys a scan skips, only to a DebugLogger,
+// other loggers do not get them.
+type DebugLogger interface {
+	dlog.Logger
+	Debugf(format string, args ...any)
+}
+
+// ContextLogger is a logger that takes the context of a message, e.g. to
+// add its trace ID. The heartbeat passes the context of Start, so its
+// messages carry the trace of the Start call.
+type ContextLogger interface {
+	dlog.Logger
+	WithContext(ctx context.Context) dlog.Logger
+}
+
+// LogFunc receives a formatted message, its level ("debug", "info", "warn"
+// or "error") and its fields.
+type LogFunc func(level, msg string, fields []Field)
+
+// NewFieldLogger adapts fn to a FieldLogger, e.g. to forward the
+// messages of the driver to zap or logrus with their fields.
+func NewFieldLogger(fn LogFunc) FieldLogger {
+	return &funcLogger{fn: fn}
+}
+
+type funcLogger struct {
+	fn     LogFunc
+	fields []Field
+}
+
+func (l *funcLogger) Printf(format string, args ...any) { l.log("info", format, args) }
+func (l *funcLogger) Debugf(format string, args ...any) { l.log("debug", format, args) }
+func (l *funcLogger) Infof(format string, args ...any)  { l.log("info", format, args) }
+func (l *funcLogger) Warnf(format string, args ...any)  { l.log("warn", format, args) }
+func (l *funcLogger) Errorf(format string, args ...any) { l.log("error", format, args) }
+
+func (l *funcLogger) WithFields(fields ...Field) dlog.Logger {
+	merged := make([]Field, 0, len(l.fields)+len(fields))
+	merged = append(merged, l.fields...)
+	merged = append(merged, fields...)
+	return &funcLogger{fn: l.fn, fields: merged}
+}
+
+func (l *funcLogger) log(level, format string, args []any) {
+	l.fn(level, fmt.Sprintf(format, args...), l.fields)
+}
+
+// log returns the driver logger with the fields of op,
+// if the logger takes fields.
+func (rd *RedisDriver) log(op string) dlog.Logger {
+	return rd.withFields(rd.logger.get(), op)
+}
+
+// debugf logs at debug level of op, if the logger has one.
+func (rd *RedisDriver) debugf(op, format string, args ...any) {
+	if dl, ok := rd.log(op).(DebugLogger); ok {
+		dl.Debugf(format, args...)
+	}
+}
+
+// logCtx is log with the context ctx, if the logger takes a context.
+func (rd *RedisDriver) logCtx(ctx context.Context, op string) dlog.Logger {
+	l := rd.logger.get()
+	if cl, ok := l.(ContextLogger); ok {
+		l = cl.WithContext(ctx)
+	}
+	return rd.withFields(l, op)
+}
+
+func (rd *RedisDriver) withFields(l dlog.Logger, op string) dlog.Logger {
+	fl, ok := l.(FieldLogger)
+	if !ok {
+		return l
+	}
+	return fl.WithFields(
+		Field{Key: "service", Value: rd.serviceName},
+		Field{Key: "node_id", Value: rd.nodeID},
+		Field{Key: "op", Value: op})
+}
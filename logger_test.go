@@ -0,0 +1,150 @@
+package redisdriver_test
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dcron-contrib/commons"
+	"github.com/dcron-contrib/commons/dlog"
+	"github.com/dcron-contrib/redisdriver"
+	"github.com/stretchr/testify/require"
+)
+
+type testEntry struct {
+	level  string
+	msg    string
+	fields map[string]any
+}
+
+func TestRedisDriver_FieldLogger(t *testing.T) {
+	rds := miniredis.RunT(t)
+	var mu sync.Mutex
+	var entries []testEntry
+	logger := redisdriver.NewFieldLogger(func(level, msg string, fields []redisdriver.Field) {
+		entry := testEntry{level: level, msg: msg, fields: map[string]any{}}
+		for _, field := range fields {
+			entry.fields[field.Key] = field.Value
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		entries = append(entries, entry)
+	})
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name(),
+		commons.NewLoggerOption(logger),
+		redisdriver.WithScanCount(0))
+
+	mu.Lock()
+	require.Len(t, entries, 1)
+	require.Equal(t, "error", entries[0].level)
+	require.Contains(t, entries[0].msg, "apply option error=")
+	require.Equal(t, map[string]any{
+		"service": t.Name(),
+		"node_id": drv.NodeID(),
+		"op":      "init",
+	}, entries[0].fields)
+	mu.Unlock()
+
+	// the fields of the adapter are kept.
+	fields := logger.WithFields(redisdriver.Field{Key: "app", Value: "cron"}).(redisdriver.FieldLogger)
+	fields.WithFields(redisdriver.Field{Key: "op", Value: "test"}).Warnf("count=%d", 1)
+	mu.Lock()
+	require.Equal(t, testEntry{level: "warn", msg: "count=1", fields: map[string]any{"app": "cron", "op": "test"}}, entries[1])
+	mu.Unlock()
+}
+
+func TestRedisDriver_PlainLogger(t *testing.T) {
+	rds := miniredis.RunT(t)
+	logger := &testLogger{}
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(time.Second),
+		commons.NewLoggerOption(logger),
+		redisdriver.WithScanCount(0))
+	require.NotNil(t, drv.Start(context.Background()))
+	require.Len(t, logger.Lines(), 1)
+	require.Contains(t, logger.Lines()[0], "[ERROR] apply option error=")
+}
+
+func TestRedisDriver_QuietLogger(t *testing.T) {
+	var out bytes.Buffer
+	previous := log.Writer()
+	log.SetOutput(&out)
+	defer log.SetOutput(previous)
+
+	rds := miniredis.RunT(t)
+	clock := newTestClock()
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name(),
+		redisdriver.WithQuietLogger(),
+		redisdriver.WithMaxRetries(0),
+		redisdriver.WithClock(clock))
+	require.Nil(t, drv.Start(context.Background()))
+	ticker := clock.nextTicker(t)
+
+	rds.SetError("redis failed")
+	ticker.tick()
+	require.Eventually(t, func() bool {
+		return drv.Stats().HeartbeatFailures == 1
+	}, time.Second, time.Millisecond)
+	// the errors are still returned.
+	_, err := drv.GetNodes(context.Background())
+	require.ErrorContains(t, err, "redis failed")
+	require.ErrorContains(t, drv.Stop(context.Background()), "redis failed")
+	rds.SetError("")
+	require.Empty(t, out.String())
+}
+
+type testContextKey struct{}
+
+func TestRedisDriver_HeartbeatLogContext(t *testing.T) {
+	rds := miniredis.RunT(t)
+	clock := newTestClock()
+	logger := &testLogger{}
+	traces := make(chan any, 16)
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name(),
+		commons.NewLoggerOption(&testContextLoggerRoot{testLogger: logger, traces: traces}),
+		redisdriver.WithMaxRetries(1),
+		redisdriver.WithRetryBackoff(time.Millisecond),
+		redisdriver.WithClock(clock))
+	ctx := context.WithValue(context.Background(), testContextKey{}, "trace-1")
+	require.Nil(t, drv.Start(ctx))
+	ticker := clock.nextTicker(t)
+
+	rds.SetError("heartbeat failed")
+	ticker.tick()
+	// the retry waits for the backoff on the clock.
+	clock.nextTicker(t).tick()
+	require.Eventually(t, func() bool {
+		return drv.Stats().HeartbeatFailures == 1
+	}, time.Second, time.Millisecond)
+	rds.SetError("")
+	require.Equal(t, "trace-1", <-traces)
+
+	lines := logger.Lines()
+	require.Len(t, lines, 2)
+	require.Contains(t, lines[0], "node="+drv.NodeID()+", retry 1/1")
+	require.Contains(t, lines[1], "node="+drv.NodeID()+", 1 consecutive failures")
+	testFuncStop(t, rds, drv)
+}
+
+// testContextLoggerRoot logs to testLogger and sends the trace of the
+// context of every contextual logger.
+type testContextLoggerRoot struct {
+	*testLogger
+	traces chan any
+}
+
+func (l *testContextLoggerRoot) WithContext(ctx context.Context) dlog.Logger {
+	select {
+	case l.traces <- ctx.Value(testContextKey{}):
+	default:
+	}
+	return l.testLogger
+}
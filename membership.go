@@ -0,0 +1,82 @@
+package redisdriver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// membershipMessage is the payload of the events channel.
+type membershipMessage struct {
+	Type   string `json:"type"`
+	NodeID string `json:"node_id"`
+}
+
+// SubscribeMembership emits the membership changes of this service until
+// ctx is done, then the channel is closed. The nodes alive when it is
+// called are emitted as NodeJoin first. The drivers with
+// WithMembershipEvents publish their join on Start and their leave on
+// Stop to the channel "<prefix>:events" of the service, e.g.
+// "distributed-cron:<service>:events", so they are seen within a
+// round trip and independently of the keyspace notifications of the
+// server. Nodes that crash or whose key expires
+// publish nothing, they and the messages lost while reconnecting are
+// found by polling GetNodes every heartbeat interval, like Watch does.
+// The driver does not need to be started.
+func (rd *RedisDriver) SubscribeMembership(ctx context.Context) (<-chan NodeEvent, error) {
+	// subscribe before listing the nodes, so no change falls in between.
+	pubsub := rd.c.Subscribe(ctx, rd.eventsChannel())
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, fmt.Errorf("subscribe membership: %w", err)
+	}
+	nodes, err := rd.GetNodes(ctx)
+	if err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+	events := make(chan NodeEvent)
+	go rd.watchNodes(ctx, events, nodes, pubsub, rd.membershipNodeEvent, false)
+	return events, nil
+}
+
+// private function
+
+// eventsChannel is "<prefix>:events". Channels are no keys, so it is not
+// found by the SCAN of the node keys.
+func (rd *RedisDriver) eventsChannel() string {
+	return rd.servicePrefix() + "events"
+}
+
+// publishMembership announces the join or leave of this node, a failure
+// is only logged since the pollers find the change as well.
+func (rd *RedisDriver) publishMembership(ctx context.Context, eventType NodeEventType) {
+	if !rd.membershipEvents {
+		return
+	}
+	payload, err := json.Marshal(membershipMessage{Type: eventType.String(), NodeID: rd.nodeID})
+	if err == nil {
+		err = rd.c.Publish(ctx, rd.eventsChannel(), payload).Err()
+	}
+	if err != nil {
+		rd.logCtx(ctx, "membership").Warnf("publish %s of node %s error %+v", eventType, rd.nodeID, err)
+	}
+}
+
+func (rd *RedisDriver) membershipNodeEvent(msg *redis.Message) (NodeEvent, bool) {
+	var message membershipMessage
+	if err := json.Unmarshal([]byte(msg.Payload), &message); err != nil || message.NodeID == "" {
+		rd.log("membership").Warnf("skip membership message %q", msg.Payload)
+		return NodeEvent{}, false
+	}
+	switch message.Type {
+	case NodeJoin.String():
+		return NodeEvent{Type: NodeJoin, NodeID: message.NodeID}, true
+	case NodeLeave.String():
+		return NodeEvent{Type: NodeLeave, NodeID: message.NodeID}, true
+	}
+	rd.log("membership").Warnf("skip membership message %q", msg.Payload)
+	return NodeEvent{}, false
+}
@@ -0,0 +1,108 @@
+package redisdriver_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dcron-contrib/commons"
+	"github.com/dcron-contrib/commons/dlog"
+	"github.com/dcron-contrib/redisdriver"
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisDriver_MembershipEventsPublish(t *testing.T) {
+	rds := miniredis.RunT(t)
+	redisCli := redis.NewClient(&redis.Options{Addr: rds.Addr()})
+	pubsub := redisCli.Subscribe(context.Background(), "distributed-cron:"+t.Name()+":events")
+	defer pubsub.Close()
+	_, err := pubsub.Receive(context.Background())
+	require.Nil(t, err)
+
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name(),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithMembershipEvents())
+	require.Nil(t, drv.Start(context.Background()))
+	msg, err := pubsub.ReceiveMessage(context.Background())
+	require.Nil(t, err)
+	require.JSONEq(t, `{"type":"join","node_id":"`+drv.NodeID()+`"}`, msg.Payload)
+
+	testFuncStop(t, rds, drv)
+	msg, err = pubsub.ReceiveMessage(context.Background())
+	require.Nil(t, err)
+	require.JSONEq(t, `{"type":"leave","node_id":"`+drv.NodeID()+`"}`, msg.Payload)
+
+	// a node stopped before its delayed registration never joined.
+	delayed := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	delayed.Init(t.Name(),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithMembershipEvents(),
+		redisdriver.WithInitialRegisterDelay(time.Hour))
+	require.Nil(t, delayed.Start(context.Background()))
+	testFuncStop(t, rds, delayed)
+	received, err := pubsub.ReceiveTimeout(context.Background(), 100*time.Millisecond)
+	require.True(t, errors.Is(err, os.ErrDeadlineExceeded), "received %v, error %v", received, err)
+}
+
+func TestRedisDriver_SubscribeMembership(t *testing.T) {
+	rds := miniredis.RunT(t)
+	// polls too rarely to see the changes in time, only the events do.
+	observer := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	observer.Init(t.Name(),
+		commons.NewTimeoutOption(time.Hour),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)))
+	drv1 := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv1.Init(t.Name(),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithMembershipEvents())
+	drv2 := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv2.Init(t.Name(),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithMembershipEvents())
+	require.Nil(t, drv1.Start(context.Background()))
+	defer testFuncStop(t, rds, drv1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := observer.SubscribeMembership(ctx)
+	require.Nil(t, err)
+	require.Equal(t, redisdriver.NodeEvent{Type: redisdriver.NodeJoin, NodeID: drv1.NodeID()}, testFuncNextEvent(t, events))
+
+	require.Nil(t, drv2.Start(context.Background()))
+	require.Equal(t, redisdriver.NodeEvent{Type: redisdriver.NodeJoin, NodeID: drv2.NodeID()}, testFuncNextEvent(t, events))
+	testFuncStop(t, rds, drv2)
+	require.Equal(t, redisdriver.NodeEvent{Type: redisdriver.NodeLeave, NodeID: drv2.NodeID()}, testFuncNextEvent(t, events))
+
+	// a duplicated or foreign message is not emitted.
+	rds.Publish("distributed-cron:"+t.Name()+":events", `{"type":"join","node_id":"`+drv1.NodeID()+`"}`)
+	rds.Publish("distributed-cron:"+t.Name()+":events", "garbage")
+	cancel()
+	for event := range events {
+		t.Fatalf("unexpected event %+v", event)
+	}
+}
+
+func TestRedisDriver_SubscribeMembershipReconcile(t *testing.T) {
+	rds := miniredis.RunT(t)
+	observer := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	observer.Init(t.Name(),
+		commons.NewTimeoutOption(2*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithHeartbeatInterval(20*time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := observer.SubscribeMembership(ctx)
+	require.Nil(t, err)
+
+	// a node without membership events is found by the poll.
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name(), commons.NewLoggerOption(dlog.NewLoggerForTest(t)))
+	require.Nil(t, drv.Start(context.Background()))
+	require.Equal(t, redisdriver.NodeEvent{Type: redisdriver.NodeJoin, NodeID: drv.NodeID()}, testFuncNextEvent(t, events))
+	testFuncStop(t, rds, drv)
+	require.Equal(t, redisdriver.NodeEvent{Type: redisdriver.NodeLeave, NodeID: drv.NodeID()}, testFuncNextEvent(t, events))
+}
@@ -0,0 +1,19 @@
+package redisdriver
+
+import "time"
+
+// MetricsCollector receives the runtime metrics of the driver,
+// e.g. to export them as prometheus counters and gauges.
+type MetricsCollector interface {
+	IncHeartbeatSuccess()
+	IncHeartbeatFailure()
+	ObserveScanDuration(time.Duration)
+	SetNodeCount(int)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) IncHeartbeatSuccess()              {}
+func (nopMetrics) IncHeartbeatFailure()              {}
+func (nopMetrics) ObserveScanDuration(time.Duration) {}
+func (nopMetrics) SetNodeCount(int)                  {}
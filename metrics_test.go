@@ -0,0 +1,56 @@
+package redisdriver_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dcron-contrib/commons"
+	"github.com/dcron-contrib/commons/dlog"
+	"github.com/dcron-contrib/redisdriver"
+	"github.com/stretchr/testify/require"
+)
+
+type testMetrics struct {
+	heartbeatSuccess int64
+	heartbeatFailure int64
+	scans            int64
+	nodeCount        int64
+}
+
+func (m *testMetrics) IncHeartbeatSuccess() { atomic.AddInt64(&m.heartbeatSuccess, 1) }
+func (m *testMetrics) IncHeartbeatFailure() { atomic.AddInt64(&m.heartbeatFailure, 1) }
+func (m *testMetrics) ObserveScanDuration(time.Duration) {
+	atomic.AddInt64(&m.scans, 1)
+}
+func (m *testMetrics) SetNodeCount(n int) { atomic.StoreInt64(&m.nodeCount, int64(n)) }
+
+func TestRedisDriver_Metrics(t *testing.T) {
+	rds := miniredis.RunT(t)
+	metrics := &testMetrics{}
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(2*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithHeartbeatInterval(20*time.Millisecond),
+		redisdriver.WithMetrics(metrics))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&metrics.heartbeatSuccess) >= 2
+	}, time.Second, 10*time.Millisecond)
+
+	_, err := drv.GetNodes(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, int64(1), atomic.LoadInt64(&metrics.scans))
+	require.Equal(t, int64(1), atomic.LoadInt64(&metrics.nodeCount))
+
+	rds.SetError("ERR injected")
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&metrics.heartbeatFailure) >= 1
+	}, time.Second, 10*time.Millisecond)
+	rds.SetError("")
+}
@@ -0,0 +1,175 @@
+package redisdriver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Mutex is a lock shared by the drivers of this service, e.g. so that a
+// cron job fires once cluster-wide. It is held by a lease of the ttl on
+// a redis key, whose value is a random token of the holder, so that only
+// the holder releases or extends it. Without WithMutexAutoExtend a
+// holder running longer than the ttl loses the lock to the next caller.
+// A Mutex is safe for concurrent use and works without Start.
+type Mutex struct {
+	rd         *RedisDriver
+	name       string
+	ttl        time.Duration
+	autoExtend bool
+
+	mu    sync.Mutex
+	token string
+	// stopExtend ends the auto extension, extendDone is closed once it ended.
+	stopExtend context.CancelFunc
+	extendDone chan struct{}
+}
+
+// MutexOption configures a Mutex of NewMutex.
+type MutexOption func(m *Mutex)
+
+// WithMutexAutoExtend extends the lease every third of the ttl while the
+// mutex is held. The extension stops when the lease is lost, e.g. redis
+// was unreachable for longer than the ttl, then Unlock reports
+// ErrMutexNotHeld.
+func WithMutexAutoExtend() MutexOption {
+	return func(m *Mutex) { m.autoExtend = true }
+}
+
+// NewMutex returns the mutex name of this service. Mutexes with the same
+// name on drivers of the same service exclude each other.
+func (rd *RedisDriver) NewMutex(name string, ttl time.Duration, opts ...MutexOption) *Mutex {
+	m := &Mutex{rd: rd, name: name, ttl: ttl}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// TryLock takes the mutex if it is free and reports whether it did. Like
+// sync.Mutex it reports false if this Mutex holds it already.
+func (m *Mutex) TryLock(ctx context.Context) (ok bool, err error) {
+	if m.name == "" {
+		return false, fmt.Errorf("%w: mutex name must not be empty", ErrInvalidOption)
+	}
+	if m.ttl < time.Millisecond {
+		return false, fmt.Errorf("%w: mutex ttl %v must be at least 1ms", ErrInvalidOption, m.ttl)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.token != "" {
+		return false, nil
+	}
+	token := uuid.NewString()
+	ctx, span := m.rd.startSpan(ctx, "redisdriver.lock_mutex", "SET")
+	ok, err = m.rd.c.SetNX(ctx, m.key(), token, m.ttl).Result()
+	endSpan(span, err)
+	if err != nil {
+		return false, fmt.Errorf("lock mutex %s: %w", m.name, err)
+	}
+	if !ok {
+		return false, nil
+	}
+	m.token = token
+	if m.autoExtend {
+		var extendCtx context.Context
+		extendCtx, m.stopExtend = context.WithCancel(context.Background())
+		m.extendDone = make(chan struct{})
+		go m.extend(extendCtx, token, m.extendDone)
+	}
+	return true, nil
+}
+
+// Unlock releases the mutex. The key is only deleted if it still holds
+// the token of this Mutex, otherwise ErrMutexNotHeld is returned.
+func (m *Mutex) Unlock(ctx context.Context) (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.token == "" {
+		return ErrMutexNotHeld
+	}
+	token := m.token
+	m.token = ""
+	if m.stopExtend != nil {
+		m.stopExtend()
+		<-m.extendDone
+		m.stopExtend, m.extendDone = nil, nil
+	}
+	ctx, span := m.rd.startSpan(ctx, "redisdriver.unlock_mutex", "EVALSHA")
+	defer func() { endSpan(span, err) }()
+	deleted, err := leaseReleaseScript.Run(ctx, m.rd.c, []string{m.key()}, token).Int()
+	if err != nil {
+		return fmt.Errorf("unlock mutex %s: %w", m.name, err)
+	}
+	if deleted == 0 {
+		return fmt.Errorf("unlock mutex %s: %w", m.name, ErrMutexNotHeld)
+	}
+	return nil
+}
+
+// AcquireJobSlot claims the firing of jobName for window and reports
+// whether this node got it, so that a job fired by every node at about
+// the same time runs once. The slot is a key set by SET NX PX with the
+// node ID, a second claim within window returns false on every node,
+// also on the one that holds it. The slot is not released, a firing that
+// runs longer than window does not hold off the next one.
+func (rd *RedisDriver) AcquireJobSlot(ctx context.Context, jobName string, window time.Duration) (ok bool, err error) {
+	if jobName == "" {
+		return false, fmt.Errorf("%w: job name must not be empty", ErrInvalidOption)
+	}
+	if window < time.Millisecond {
+		return false, fmt.Errorf("%w: job slot window %v must be at least 1ms", ErrInvalidOption, window)
+	}
+	ctx, span := rd.startSpan(ctx, "redisdriver.acquire_job_slot", "SET")
+	defer func() { endSpan(span, err) }()
+	ok, err = rd.c.SetNX(ctx, rd.jobSlotKey(jobName), rd.nodeID, window).Result()
+	if err != nil {
+		return false, fmt.Errorf("acquire job slot %s: %w", jobName, err)
+	}
+	return ok, nil
+}
+
+// private function
+
+func (rd *RedisDriver) jobSlotKey(jobName string) string {
+	return rd.serviceKey("job-" + jobName)
+}
+
+func (m *Mutex) key() string {
+	return m.rd.serviceKey("mutex-" + m.name)
+}
+
+// extend renews the lease of token until ctx is done or the lease is lost.
+func (m *Mutex) extend(ctx context.Context, token string, done chan<- struct{}) {
+	defer close(done)
+	tick := m.rd.clock.NewTicker(m.ttl / 3)
+	defer tick.Stop()
+	extendedAt := m.rd.clock.Now()
+	for {
+		select {
+		case <-tick.C():
+			extendCtx, cancel := context.WithTimeout(ctx, m.ttl)
+			extended, err := leaseRenewScript.Run(extendCtx, m.rd.c, []string{m.key()}, token, m.ttl.Milliseconds()).Int()
+			cancel()
+			if errors.Is(err, context.Canceled) && ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				m.rd.log("mutex").Warnf("extend mutex %s error %+v", m.name, err)
+			} else if extended == 1 {
+				extendedAt = m.rd.clock.Now()
+				continue
+			}
+			if err == nil || m.rd.clock.Now().Sub(extendedAt) >= m.ttl {
+				m.rd.log("mutex").Warnf("lease of mutex %s is lost", m.name)
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
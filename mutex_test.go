@@ -0,0 +1,191 @@
+package redisdriver_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dcron-contrib/commons"
+	"github.com/dcron-contrib/commons/dlog"
+	"github.com/dcron-contrib/redisdriver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testFuncMutexKey(serviceName, name string) string {
+	return strings.TrimSuffix(commons.GetKeyPre(serviceName), ":") + "@mutex-" + name
+}
+
+func testFuncMutexDrivers(t *testing.T, rds *miniredis.Miniredis, opts ...commons.Option) (*redisdriver.RedisDriver, *redisdriver.RedisDriver) {
+	opts = append([]commons.Option{commons.NewLoggerOption(dlog.NewLoggerForTest(t))}, opts...)
+	drv1 := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv1.Init(t.Name(), opts...)
+	drv2 := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv2.Init(t.Name(), opts...)
+	return drv1, drv2
+}
+
+func TestRedisDriver_Mutex(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv1, drv2 := testFuncMutexDrivers(t, rds)
+	ctx := context.Background()
+	mu1 := drv1.NewMutex("job", time.Second)
+	mu2 := drv2.NewMutex("job", time.Second)
+
+	ok, err := mu1.TryLock(ctx)
+	require.Nil(t, err)
+	require.True(t, ok)
+	ok, err = mu1.TryLock(ctx)
+	require.Nil(t, err)
+	require.False(t, ok)
+	ok, err = mu2.TryLock(ctx)
+	require.Nil(t, err)
+	require.False(t, ok)
+	// another name is another lock.
+	ok, err = drv2.NewMutex("other", time.Second).TryLock(ctx)
+	require.Nil(t, err)
+	require.True(t, ok)
+
+	require.ErrorIs(t, mu2.Unlock(ctx), redisdriver.ErrMutexNotHeld)
+	require.Nil(t, mu1.Unlock(ctx))
+	require.ErrorIs(t, mu1.Unlock(ctx), redisdriver.ErrMutexNotHeld)
+	ok, err = mu2.TryLock(ctx)
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.Nil(t, mu2.Unlock(ctx))
+
+	_, err = drv1.NewMutex("", time.Second).TryLock(ctx)
+	require.ErrorIs(t, err, redisdriver.ErrInvalidOption)
+	_, err = drv1.NewMutex("job", 0).TryLock(ctx)
+	require.ErrorIs(t, err, redisdriver.ErrInvalidOption)
+}
+
+func TestRedisDriver_MutexContention(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv1, drv2 := testFuncMutexDrivers(t, rds)
+	var locked int32
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		drv := drv1
+		if i%2 == 1 {
+			drv = drv2
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := drv.NewMutex("job", time.Second).TryLock(context.Background())
+			assert.Nil(t, err)
+			if ok {
+				atomic.AddInt32(&locked, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(1), atomic.LoadInt32(&locked))
+}
+
+func TestRedisDriver_MutexExpired(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv1, drv2 := testFuncMutexDrivers(t, rds)
+	ctx := context.Background()
+	mu1 := drv1.NewMutex("job", time.Second)
+	mu2 := drv2.NewMutex("job", time.Second)
+	ok, err := mu1.TryLock(ctx)
+	require.Nil(t, err)
+	require.True(t, ok)
+
+	// the lease of a holder that ran too long is taken over, its unlock
+	// leaves the new holder alone.
+	rds.FastForward(time.Second)
+	ok, err = mu2.TryLock(ctx)
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.ErrorIs(t, mu1.Unlock(ctx), redisdriver.ErrMutexNotHeld)
+	ok, err = mu1.TryLock(ctx)
+	require.Nil(t, err)
+	require.False(t, ok)
+	require.Nil(t, mu2.Unlock(ctx))
+}
+
+func TestRedisDriver_MutexAutoExtend(t *testing.T) {
+	rds := miniredis.RunT(t)
+	clock := newTestClock()
+	drv1, drv2 := testFuncMutexDrivers(t, rds, redisdriver.WithClock(clock))
+	ctx := context.Background()
+	mu1 := drv1.NewMutex("job", 3*time.Second, redisdriver.WithMutexAutoExtend())
+	ok, err := mu1.TryLock(ctx)
+	require.Nil(t, err)
+	require.True(t, ok)
+	ticker := clock.nextTicker(t)
+	require.Equal(t, time.Second, <-ticker.intervals)
+
+	key := testFuncMutexKey(t.Name(), "job")
+	rds.FastForward(2 * time.Second)
+	ticker.tick()
+	require.Eventually(t, func() bool {
+		return rds.TTL(key) == 3*time.Second
+	}, time.Second, 10*time.Millisecond)
+	rds.FastForward(2 * time.Second)
+	ok, err = drv2.NewMutex("job", time.Second).TryLock(ctx)
+	require.Nil(t, err)
+	require.False(t, ok)
+	require.Nil(t, mu1.Unlock(ctx))
+	require.False(t, rds.Exists(key))
+}
+
+func TestRedisDriver_AcquireJobSlot(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv1, drv2 := testFuncMutexDrivers(t, rds)
+	ctx := context.Background()
+
+	// the nodes race for every firing, one of them wins.
+	for firing := 0; firing < 3; firing++ {
+		var won int32
+		winner := make(chan string, 20)
+		wg := sync.WaitGroup{}
+		for i := 0; i < 20; i++ {
+			drv := drv1
+			if i%2 == 1 {
+				drv = drv2
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ok, err := drv.AcquireJobSlot(ctx, "report", time.Minute)
+				assert.Nil(t, err)
+				if ok {
+					atomic.AddInt32(&won, 1)
+					winner <- drv.NodeID()
+				}
+			}()
+		}
+		wg.Wait()
+		require.Equal(t, int32(1), atomic.LoadInt32(&won))
+		nodeID, err := rds.Get(testFuncJobSlotKey(t.Name(), "report"))
+		require.Nil(t, err)
+		require.Equal(t, <-winner, nodeID)
+		// the next firing is claimed once the window is over.
+		rds.FastForward(time.Minute)
+	}
+
+	// another job has its own slot.
+	ok, err := drv1.AcquireJobSlot(ctx, "report", time.Minute)
+	require.Nil(t, err)
+	require.True(t, ok)
+	ok, err = drv2.AcquireJobSlot(ctx, "cleanup", time.Minute)
+	require.Nil(t, err)
+	require.True(t, ok)
+
+	_, err = drv1.AcquireJobSlot(ctx, "", time.Minute)
+	require.ErrorIs(t, err, redisdriver.ErrInvalidOption)
+	_, err = drv1.AcquireJobSlot(ctx, "report", 0)
+	require.ErrorIs(t, err, redisdriver.ErrInvalidOption)
+}
+
+func testFuncJobSlotKey(serviceName, jobName string) string {
+	return strings.TrimSuffix(commons.GetKeyPre(serviceName), ":") + "@job-" + jobName
+}
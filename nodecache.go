@@ -0,0 +1,106 @@
+package redisdriver
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// nodeCache is the cached result of GetNodes, see NodeCacheOption.
+type nodeCache struct {
+	ttl time.Duration
+
+	mu        sync.RWMutex
+	nodes     []string
+	updatedAt time.Time
+	// refreshMu lets one scan run at a time.
+	refreshMu sync.Mutex
+	// refreshing is set while a background refresh is running.
+	refreshing atomic.Bool
+	// refreshes tracks the background refresh, Stop waits for it.
+	refreshes sync.WaitGroup
+}
+
+// RefreshNodeCache scans the nodes now and replaces the cached list,
+// it returns the new list. Without WithNodeCache it is GetNodes.
+func (rd *RedisDriver) RefreshNodeCache(ctx context.Context) ([]string, error) {
+	if rd.nodeCache == nil {
+		return rd.scanNodes(ctx)
+	}
+	return rd.refreshNodeCache(ctx, rd.clock.Now())
+}
+
+func (rd *RedisDriver) cachedNodes(ctx context.Context) ([]string, error) {
+	cache := rd.nodeCache
+	now := rd.clock.Now()
+	nodes, updatedAt := cache.get()
+	age := now.Sub(updatedAt)
+	if age >= cache.ttl {
+		return rd.refreshNodeCache(ctx, updatedAt)
+	}
+	if age >= cache.ttl/2 {
+		rd.refreshNodeCacheAsync(updatedAt)
+	}
+	return nodes, nil
+}
+
+// refreshNodeCacheAsync refreshes the cache in background unless a refresh
+// is running already. The refresh ends with the runtime context, a driver
+// that is not started refreshes the cache only once it is expired.
+func (rd *RedisDriver) refreshNodeCacheAsync(updatedAt time.Time) {
+	cache := rd.nodeCache
+	rd.Lock()
+	defer rd.Unlock()
+	if !rd.started || !cache.refreshing.CompareAndSwap(false, true) {
+		return
+	}
+	runtimeCtx := rd.runtimeCtx
+	cache.refreshes.Add(1)
+	go func() {
+		defer cache.refreshes.Done()
+		defer cache.refreshing.Store(false)
+		ctx, cancel := context.WithTimeout(runtimeCtx, rd.getCommandTimeout())
+		defer cancel()
+		if _, err := rd.refreshNodeCache(ctx, updatedAt); err != nil && runtimeCtx.Err() == nil {
+			rd.log("get_nodes").Warnf("refresh node cache error %+v", err)
+		}
+	}()
+}
+
+// refreshNodeCache scans the nodes unless the cache was refreshed after
+// seen while waiting for another scan.
+func (rd *RedisDriver) refreshNodeCache(ctx context.Context, seen time.Time) ([]string, error) {
+	cache := rd.nodeCache
+	cache.refreshMu.Lock()
+	defer cache.refreshMu.Unlock()
+	if nodes, updatedAt := cache.get(); updatedAt.After(seen) {
+		return nodes, nil
+	}
+	nodes, err := rd.scanNodes(ctx)
+	if err != nil {
+		// the stale nodes of WithLastKnownFallback
+		return nodes, err
+	}
+	cache.set(nodes, rd.clock.Now())
+	return copyNodes(nodes), nil
+}
+
+// set replaces the cached nodes by the result of a scan at updatedAt.
+func (c *nodeCache) set(nodes []string, updatedAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nodes = nodes
+	c.updatedAt = updatedAt
+}
+
+// get returns a copy of the cached nodes and the time of the scan.
+func (c *nodeCache) get() ([]string, time.Time) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return copyNodes(c.nodes), c.updatedAt
+}
+
+func copyNodes(nodes []string) []string {
+	return append(make([]string, 0, len(nodes)), nodes...)
+}
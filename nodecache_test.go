@@ -0,0 +1,108 @@
+package redisdriver_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dcron-contrib/commons"
+	"github.com/dcron-contrib/commons/dlog"
+	"github.com/dcron-contrib/redisdriver"
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisDriver_NodeCache(t *testing.T) {
+	rds := miniredis.RunT(t)
+	var scans int32
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{
+		process: func(ctx context.Context, cmd redis.Cmder, next redis.ProcessHook) error {
+			if cmd.Name() == "scan" {
+				atomic.AddInt32(&scans, 1)
+			}
+			return next(ctx, cmd)
+		},
+	})
+	drv.Init(t.Name(),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithNodeCache(time.Second))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+
+	// concurrent readers share one scan.
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			nodes, err := drv.GetNodes(context.Background())
+			assert.Nil(t, err)
+			assert.Equal(t, []string{drv.NodeID()}, nodes)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(1), atomic.LoadInt32(&scans))
+
+	// a join is seen once the cache is refreshed.
+	require.Nil(t, rds.Set(testFuncNodeKey(t.Name(), "node-1"), "{}"))
+	nodes, err := drv.GetNodes(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, []string{drv.NodeID()}, nodes)
+	require.Eventually(t, func() bool {
+		nodes, err := drv.GetNodes(context.Background())
+		return err == nil && len(nodes) == 2
+	}, 2*time.Second, 10*time.Millisecond)
+
+	require.Nil(t, rds.Set(testFuncNodeKey(t.Name(), "node-2"), "{}"))
+	nodes, err = drv.RefreshNodeCache(context.Background())
+	require.Nil(t, err)
+	require.Len(t, nodes, 3)
+	before := atomic.LoadInt32(&scans)
+	nodes, err = drv.GetNodes(context.Background())
+	require.Nil(t, err)
+	require.Len(t, nodes, 3)
+	require.Equal(t, before, atomic.LoadInt32(&scans))
+
+	require.ErrorIs(t, drv.WithOption(redisdriver.WithNodeCache(-time.Second)), redisdriver.ErrInvalidOption)
+}
+
+func TestRedisDriver_NodeCacheStop(t *testing.T) {
+	rds := miniredis.RunT(t)
+	clock := newTestClock()
+	var slow, finished atomic.Bool
+	scanning := make(chan struct{}, 1)
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{
+		process: func(ctx context.Context, cmd redis.Cmder, next redis.ProcessHook) error {
+			if cmd.Name() == "scan" && slow.Load() {
+				scanning <- struct{}{}
+				<-ctx.Done()
+				finished.Store(true)
+				return ctx.Err()
+			}
+			return next(ctx, cmd)
+		},
+	})
+	drv.Init(t.Name(),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithClock(clock),
+		redisdriver.WithCommandTimeout(time.Minute),
+		redisdriver.WithNodeCache(time.Minute))
+	require.Nil(t, drv.Start(context.Background()))
+	nodes, err := drv.GetNodes(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, []string{drv.NodeID()}, nodes)
+
+	// the background refresh ends with the driver, Stop waits for it.
+	clock.advance(40 * time.Second)
+	slow.Store(true)
+	nodes, err = drv.GetNodes(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, []string{drv.NodeID()}, nodes)
+	<-scanning
+	require.Nil(t, drv.Stop(context.Background()))
+	require.True(t, finished.Load())
+}
@@ -0,0 +1,225 @@
+package redisdriver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// maxNodeValueSize is the upper bound of the encoded node value,
+// the value is rewritten on every heartbeat so it should stay small.
+const maxNodeValueSize = 4096
+
+// NodeInfo is the metadata a node stores as the value of its key.
+type NodeInfo struct {
+	ID       string `json:"id"`
+	Hostname string `json:"hostname,omitempty"`
+	PID      int    `json:"pid,omitempty"`
+	// RegisteredAt is the time the node joined, set once per Start and
+	// kept by every heartbeat.
+	RegisteredAt time.Time         `json:"registered_at"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	// UpdatedAt is the time of the heartbeat that wrote this value.
+	UpdatedAt time.Time `json:"updated_at"`
+	// Generation grows on every Start of the node, a node that restarted
+	// with the same ID has a greater generation. The counter expires a day,
+	// or the MaxAge of WithStaleCleanup if longer, after the latest
+	// heartbeat of the node, a node that comes back later starts at 1.
+	Generation int64 `json:"generation,omitempty"`
+	// Instance identifies the driver that wrote the value.
+	Instance string `json:"instance,omitempty"`
+	// Addr is the host:port the node is reachable at, set by WithAdvertiseAddr.
+	Addr string `json:"addr,omitempty"`
+	// Weight is the capacity of the node, e.g. its concurrency, set by
+	// WithNodeWeight or SetNodeWeight. Zero means no weight is set.
+	Weight int `json:"weight,omitempty"`
+	// State tells whether the node accepts work, set by SetNodeState.
+	// It is zero if the node never set it.
+	State NodeState `json:"state,omitempty"`
+}
+
+// GetNodesWithMeta returns the metadata of all alive nodes of this service.
+// Nodes whose key expires between the SCAN and the MGET are skipped.
+func (rd *RedisDriver) GetNodesWithMeta(ctx context.Context) (nodes []NodeInfo, err error) {
+	if rd.hashStorage {
+		return rd.hashNodes(ctx)
+	}
+	keys, scanErr := rd.GetRawNodeKeys(ctx)
+	if scanErr != nil && !errors.Is(scanErr, ErrPartialScan) {
+		return nil, scanErr
+	}
+	nodes = make([]NodeInfo, 0, len(keys))
+	if len(keys) == 0 {
+		return nodes, scanErr
+	}
+	values, err := getValues(ctx, rd.readClient(), keys)
+	if err != nil {
+		return nil, fmt.Errorf("get node values: %w", err)
+	}
+	for i, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			// expired after scan
+			continue
+		}
+		nodeID, ok := rd.nodeIDFromKey(keys[i])
+		if !ok {
+			continue
+		}
+		info, err := rd.decodeNodeValue(nodeID, keys[i], str)
+		if err != nil {
+			rd.log("get_nodes").Warnf("decode node key=%s value error=%v", keys[i], err)
+			continue
+		}
+		nodes = append(nodes, info)
+	}
+	return nodes, scanErr
+}
+
+// getValues reads keys like MGET, a missing key has a nil value. The node
+// keys have no hash tag, so on a cluster a single MGET fails with CROSSSLOT,
+// there every key is read by its own GET in one pipeline, which the
+// cluster client splits by slot.
+func getValues(ctx context.Context, client redis.UniversalClient, keys []string) ([]interface{}, error) {
+	if _, ok := client.(*redis.ClusterClient); !ok {
+		return client.MGet(ctx, keys...).Result()
+	}
+	cmds := make([]*redis.StringCmd, len(keys))
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.Get(ctx, key)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	values := make([]interface{}, len(keys))
+	for i, cmd := range cmds {
+		value, err := cmd.Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// GetNodesByLabel returns the metadata of the alive nodes whose labels
+// contain every key and value of selector. The filter runs on the client
+// after GetNodesWithMeta, there is no index on the server. A node without
+// labels only matches an empty selector.
+func (rd *RedisDriver) GetNodesByLabel(ctx context.Context, selector map[string]string) ([]NodeInfo, error) {
+	nodes, err := rd.GetNodesWithMeta(ctx)
+	matched := make([]NodeInfo, 0, len(nodes))
+	for _, node := range nodes {
+		if node.matches(selector) {
+			matched = append(matched, node)
+		}
+	}
+	return matched, err
+}
+
+// GetSelfNode reads the key of this node. It reports false if the key
+// does not exist, e.g. it was evicted or flushed between two heartbeats.
+// The key is read from the master, also with WithReadFromReplica.
+func (rd *RedisDriver) GetSelfNode(ctx context.Context) (info NodeInfo, ok bool, err error) {
+	if rd.hashStorage {
+		return rd.hashSelfNode(ctx)
+	}
+	key := rd.nodeKey(rd.nodeID)
+	value, err := rd.c.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return info, false, nil
+	}
+	if err != nil {
+		return info, false, fmt.Errorf("get self node: %w", err)
+	}
+	if info, err = rd.decodeNodeValue(rd.nodeID, key, value); err != nil {
+		return info, true, fmt.Errorf("decode self node: %w", err)
+	}
+	return info, true, nil
+}
+
+// SetNodeWeight changes the weight of this node, the next heartbeat
+// stores it in the node value.
+func (rd *RedisDriver) SetNodeWeight(weight int) error {
+	if weight < 0 {
+		return fmt.Errorf("%w: node weight %d must not be negative", ErrInvalidOption, weight)
+	}
+	rd.weight.Store(int64(weight))
+	return nil
+}
+
+// Age returns how long the node has been a member at now, zero if the
+// value has no registration time.
+func (info NodeInfo) Age(now time.Time) time.Duration {
+	if info.RegisteredAt.IsZero() {
+		return 0
+	}
+	return now.Sub(info.RegisteredAt)
+}
+
+func (info NodeInfo) matches(selector map[string]string) bool {
+	for k, v := range selector {
+		if label, ok := info.Labels[k]; !ok || label != v {
+			return false
+		}
+	}
+	return true
+}
+
+// validateAdvertiseAddr checks that addr is a host:port with a valid port.
+func validateAdvertiseAddr(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "" {
+		return fmt.Errorf("address %q has no host", addr)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return fmt.Errorf("address %q has an invalid port", addr)
+	}
+	return nil
+}
+
+func (rd *RedisDriver) nodeInfo() NodeInfo {
+	hostname, _ := os.Hostname()
+	return NodeInfo{
+		ID:           rd.nodeID,
+		Hostname:     hostname,
+		PID:          os.Getpid(),
+		RegisteredAt: rd.registeredAt,
+		Labels:       rd.labels,
+		UpdatedAt:    rd.clock.Now(),
+		Generation:   rd.generation,
+		Instance:     rd.instance,
+		Addr:         rd.advertiseAddr,
+		Weight:       int(rd.weight.Load()),
+		State:        NodeState(rd.state.Load()),
+	}
+}
+
+func (rd *RedisDriver) nodeValue() (string, error) {
+	value, err := rd.serializer.Marshal(rd.nodeInfo())
+	if err != nil {
+		return "", fmt.Errorf("encode node info: %w", err)
+	}
+	if value, err = rd.compressNodeValue(value); err != nil {
+		return "", err
+	}
+	if len(value) > maxNodeValueSize {
+		return "", fmt.Errorf("node info size %d exceeds %d bytes", len(value), maxNodeValueSize)
+	}
+	return string(value), nil
+}
@@ -0,0 +1,381 @@
+package redisdriver_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dcron-contrib/commons"
+	"github.com/dcron-contrib/commons/dlog"
+	"github.com/dcron-contrib/redisdriver"
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisDriver_GetNodesWithMeta(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv1 := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv1.Init(t.Name(),
+		commons.NewTimeoutOption(5*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)))
+	drv2 := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv2.Init(t.Name(),
+		commons.NewTimeoutOption(5*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)))
+
+	begin := time.Now()
+	require.Nil(t, drv1.Start(context.Background()))
+	require.Nil(t, drv2.Start(context.Background()))
+	defer testFuncStop(t, rds, drv1)
+	defer testFuncStop(t, rds, drv2)
+
+	nodes, err := drv1.GetNodesWithMeta(context.Background())
+	require.Nil(t, err)
+	require.Len(t, nodes, 2)
+	hostname, _ := os.Hostname()
+	ids := make([]string, 0)
+	for _, node := range nodes {
+		ids = append(ids, node.ID)
+		require.Equal(t, hostname, node.Hostname)
+		require.Equal(t, os.Getpid(), node.PID)
+		require.False(t, node.RegisteredAt.Before(begin))
+	}
+	require.ElementsMatch(t, []string{drv1.NodeID(), drv2.NodeID()}, ids)
+}
+
+// testFuncNewClusterClient returns a cluster client of the single shard rds
+// that fails a multi-key MGET with CROSSSLOT, like a cluster whose keys
+// live in different slots.
+func testFuncNewClusterClient(t *testing.T, rds *miniredis.Miniredis) *redis.ClusterClient {
+	client := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{rds.Addr()}})
+	t.Cleanup(func() { client.Close() })
+	client.AddHook(&testHook{
+		process: func(ctx context.Context, cmd redis.Cmder, next redis.ProcessHook) error {
+			if cmd.Name() == "mget" && len(cmd.Args()) > 2 {
+				cmd.SetErr(errors.New("CROSSSLOT Keys in request don't hash to the same slot"))
+				return cmd.Err()
+			}
+			return next(ctx, cmd)
+		},
+	})
+	return client
+}
+
+func TestRedisDriver_GetNodesWithMetaCluster(t *testing.T) {
+	rds := miniredis.RunT(t)
+	client := testFuncNewClusterClient(t, rds)
+	drvs := make([]*redisdriver.RedisDriver, 2)
+	for i := range drvs {
+		drvs[i] = redisdriver.NewDriver(client)
+		drvs[i].Init(t.Name(), commons.NewLoggerOption(dlog.NewLoggerForTest(t)))
+		require.Nil(t, drvs[i].Start(context.Background()))
+		defer testFuncStop(t, rds, drvs[i])
+	}
+
+	nodes, err := drvs[0].GetNodesWithMeta(context.Background())
+	require.Nil(t, err)
+	ids := make([]string, 0)
+	for _, node := range nodes {
+		ids = append(ids, node.ID)
+	}
+	require.ElementsMatch(t, []string{drvs[0].NodeID(), drvs[1].NodeID()}, ids)
+}
+
+func TestRedisDriver_GetNodesWithMetaExpired(t *testing.T) {
+	rds := miniredis.RunT(t)
+	var expired string
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{
+		process: func(ctx context.Context, cmd redis.Cmder, next redis.ProcessHook) error {
+			if cmd.Name() == "mget" {
+				rds.Del(expired)
+			}
+			return next(ctx, cmd)
+		},
+	})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(5*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+
+	other := testFuncNewRedisDriver(rds.Addr())
+	other.Init(t.Name(),
+		commons.NewTimeoutOption(5*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)))
+	require.Nil(t, other.Start(context.Background()))
+	defer testFuncStop(t, rds, other)
+	expired = testFuncNodeKey(t.Name(), other.NodeID())
+
+	nodes, err := drv.GetNodesWithMeta(context.Background())
+	require.Nil(t, err)
+	require.Len(t, nodes, 1)
+	require.Equal(t, drv.NodeID(), nodes[0].ID)
+}
+
+func TestRedisDriver_NodeMetadataOption(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	labels := map[string]string{"region": "eu", "zone": "eu-1"}
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(5*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithHeartbeatInterval(20*time.Millisecond),
+		redisdriver.WithNodeMetadata(labels))
+	labels["region"] = "us"
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+
+	nodes, err := drv.GetNodesWithMeta(context.Background())
+	require.Nil(t, err)
+	require.Len(t, nodes, 1)
+	first := nodes[0]
+	require.Equal(t, map[string]string{"region": "eu", "zone": "eu-1"}, first.Labels)
+
+	require.Eventually(t, func() bool {
+		nodes, err := drv.GetNodesWithMeta(context.Background())
+		return err == nil && len(nodes) == 1 && nodes[0].UpdatedAt.After(first.UpdatedAt)
+	}, time.Second, 10*time.Millisecond)
+	nodes, err = drv.GetNodesWithMeta(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, first.Labels, nodes[0].Labels)
+}
+
+func TestRedisDriver_NodeMetadataOptionTooLarge(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name(), redisdriver.WithNodeMetadata(map[string]string{
+		"blob": strings.Repeat("x", 8192),
+	}))
+	require.ErrorIs(t, drv.Start(context.Background()), redisdriver.ErrInvalidOption)
+	require.False(t, drv.IsStarted())
+	require.Empty(t, rds.Keys())
+}
+
+func TestRedisDriver_AdvertiseAddr(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv1 := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv1.Init(t.Name(),
+		commons.NewTimeoutOption(5*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithAdvertiseAddr("10.0.0.1:7000"))
+	drv2 := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv2.Init(t.Name(),
+		commons.NewTimeoutOption(5*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithAdvertiseAddr("[fd00::2]:7000"))
+	require.Nil(t, drv1.Start(context.Background()))
+	require.Nil(t, drv2.Start(context.Background()))
+	defer testFuncStop(t, rds, drv1)
+	defer testFuncStop(t, rds, drv2)
+
+	nodes, err := drv1.GetNodesWithMeta(context.Background())
+	require.Nil(t, err)
+	peers := make(map[string]string)
+	for _, node := range nodes {
+		peers[node.ID] = node.Addr
+	}
+	require.Equal(t, map[string]string{
+		drv1.NodeID(): "10.0.0.1:7000",
+		drv2.NodeID(): "[fd00::2]:7000",
+	}, peers)
+}
+
+func TestRedisDriver_AdvertiseAddrInvalid(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name())
+
+	for _, addr := range []string{"", "10.0.0.1", ":7000", "10.0.0.1:http", "10.0.0.1:0", "10.0.0.1:70000"} {
+		require.ErrorIs(t, drv.WithOption(redisdriver.WithAdvertiseAddr(addr)), redisdriver.ErrInvalidOption, addr)
+	}
+	require.Nil(t, drv.WithOption(redisdriver.WithAdvertiseAddr("node-1.svc:7000")))
+}
+
+func TestRedisDriver_NodeWeight(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(5*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithHeartbeatInterval(20*time.Millisecond),
+		redisdriver.WithNodeWeight(4))
+	require.ErrorIs(t, drv.WithOption(redisdriver.WithNodeWeight(-1)), redisdriver.ErrInvalidOption)
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+
+	nodes, err := drv.GetNodesWithMeta(context.Background())
+	require.Nil(t, err)
+	require.Len(t, nodes, 1)
+	require.Equal(t, 4, nodes[0].Weight)
+
+	require.ErrorIs(t, drv.SetNodeWeight(-1), redisdriver.ErrInvalidOption)
+	require.Nil(t, drv.SetNodeWeight(16))
+	require.Eventually(t, func() bool {
+		nodes, err := drv.GetNodesWithMeta(context.Background())
+		return err == nil && len(nodes) == 1 && nodes[0].Weight == 16
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestRedisDriver_GetSelfNode(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name(),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithNodeMetadata(map[string]string{"zone": "a"}))
+	_, ok, err := drv.GetSelfNode(context.Background())
+	require.Nil(t, err)
+	require.False(t, ok)
+
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+	info, ok, err := drv.GetSelfNode(context.Background())
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.Equal(t, drv.NodeID(), info.ID)
+	require.Equal(t, "a", info.Labels["zone"])
+
+	// removed out of band, e.g. by a flush.
+	rds.Del(testFuncNodeKey(t.Name(), drv.NodeID()))
+	_, ok, err = drv.GetSelfNode(context.Background())
+	require.Nil(t, err)
+	require.False(t, ok)
+
+	require.Nil(t, drv.Tick(context.Background()))
+	_, ok, err = drv.GetSelfNode(context.Background())
+	require.Nil(t, err)
+	require.True(t, ok)
+}
+
+func TestRedisDriver_RegisteredAt(t *testing.T) {
+	rds := miniredis.RunT(t)
+	clock := newTestClock()
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name(),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithClock(clock))
+	begin := time.Now()
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+	ticker := clock.nextTicker(t)
+	first, ok, err := drv.GetSelfNode(context.Background())
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.False(t, first.RegisteredAt.Before(begin))
+
+	for i := 0; i < 3; i++ {
+		ticker.tick()
+		require.Eventually(t, func() bool {
+			info, ok, err := drv.GetSelfNode(context.Background())
+			return err == nil && ok && info.UpdatedAt.After(first.UpdatedAt)
+		}, time.Second, time.Millisecond)
+		info, _, err := drv.GetSelfNode(context.Background())
+		require.Nil(t, err)
+		require.True(t, info.RegisteredAt.Equal(first.RegisteredAt))
+		first.UpdatedAt = info.UpdatedAt
+	}
+
+	require.Equal(t, time.Minute, first.Age(first.RegisteredAt.Add(time.Minute)))
+	require.Zero(t, redisdriver.NodeInfo{}.Age(time.Now()))
+}
+
+func TestRedisDriver_Generation(t *testing.T) {
+	rds := miniredis.RunT(t)
+	redisCli := redis.NewClient(&redis.Options{Addr: rds.Addr()})
+	var generations []int64
+	for i := 0; i < 2; i++ {
+		drv, err := redisdriver.NewDriverWithConfig(redisCli, redisdriver.Config{
+			Logger: dlog.NewLoggerForTest(t),
+			NodeID: "node-1",
+		})
+		require.Nil(t, err)
+		drv.Init(t.Name())
+		// Start and Stop twice on the same driver, then restart with a new driver.
+		for j := 0; j < 2; j++ {
+			require.Nil(t, drv.Start(context.Background()))
+			info, ok, err := drv.GetSelfNode(context.Background())
+			require.Nil(t, err)
+			require.True(t, ok)
+			generations = append(generations, info.Generation)
+			testFuncStop(t, rds, drv)
+		}
+	}
+	require.Equal(t, []int64{1, 2, 3, 4}, generations)
+	require.Greater(t, rds.TTL("distributed-cron:"+t.Name()+"@generation-node-1"), time.Hour)
+
+	// a node running for longer than the expiry keeps its counter.
+	clock := newTestClock()
+	drv, err := redisdriver.NewDriverWithConfig(redisCli, redisdriver.Config{
+		Logger: dlog.NewLoggerForTest(t),
+		NodeID: "node-1",
+	})
+	require.Nil(t, err)
+	drv.Init(t.Name(), commons.NewTimeoutOption(time.Hour), redisdriver.WithClock(clock))
+	require.Nil(t, drv.Start(context.Background()))
+	generationKey := "distributed-cron:" + t.Name() + "@generation-node-1"
+	require.Equal(t, 24*time.Hour, rds.TTL(generationKey))
+	for i := 0; i < 3; i++ {
+		clock.advance(13 * time.Hour)
+		rds.FastForward(13 * time.Hour)
+		require.Nil(t, drv.Tick(context.Background()))
+		require.Equal(t, 24*time.Hour, rds.TTL(generationKey))
+	}
+	testFuncStop(t, rds, drv)
+	require.Nil(t, drv.Start(context.Background()))
+	info, _, err := drv.GetSelfNode(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, int64(6), info.Generation)
+	testFuncStop(t, rds, drv)
+
+	rds.SetError("incr failed")
+	failing := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	failing.Init(t.Name(), commons.NewLoggerOption(dlog.NewLoggerForTest(t)))
+	require.NotNil(t, failing.Start(context.Background()))
+	rds.SetError("")
+}
+
+func TestRedisDriver_GetNodesByLabel(t *testing.T) {
+	rds := miniredis.RunT(t)
+	labels := []map[string]string{
+		{"region": "eu", "zone": "eu-1"},
+		{"region": "eu", "zone": "eu-2"},
+		{"region": "us"},
+		nil,
+	}
+	ids := make([]string, len(labels))
+	for i, nodeLabels := range labels {
+		drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+		drv.Init(t.Name(),
+			commons.NewTimeoutOption(5*time.Second),
+			commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+			redisdriver.WithNodeMetadata(nodeLabels))
+		require.Nil(t, drv.Start(context.Background()))
+		defer testFuncStop(t, rds, drv)
+		ids[i] = drv.NodeID()
+	}
+	reader := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	reader.Init(t.Name(), commons.NewLoggerOption(dlog.NewLoggerForTest(t)))
+
+	for _, tc := range []struct {
+		selector map[string]string
+		want     []string
+	}{
+		{selector: map[string]string{"region": "eu"}, want: []string{ids[0], ids[1]}},
+		{selector: map[string]string{"region": "eu", "zone": "eu-2"}, want: []string{ids[1]}},
+		{selector: map[string]string{"region": "us"}, want: []string{ids[2]}},
+		{selector: map[string]string{"region": "ap"}, want: []string{}},
+		{selector: map[string]string{"zone": ""}, want: []string{}},
+		{selector: nil, want: ids},
+	} {
+		nodes, err := reader.GetNodesByLabel(context.Background(), tc.selector)
+		require.Nil(t, err)
+		got := make([]string, 0, len(nodes))
+		for _, node := range nodes {
+			got = append(got, node.ID)
+		}
+		require.ElementsMatch(t, tc.want, got, "%v", tc.selector)
+	}
+}
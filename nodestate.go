@@ -0,0 +1,62 @@
+package redisdriver
+
+import "fmt"
+
+// NodeState tells whether a node accepts work, it is stored in the node
+// value and returned as NodeInfo.State.
+type NodeState int
+
+const (
+	NodeIdle NodeState = iota + 1
+	NodeBusy
+	NodeDraining
+)
+
+func (s NodeState) String() string {
+	switch s {
+	case NodeIdle:
+		return "idle"
+	case NodeBusy:
+		return "busy"
+	case NodeDraining:
+		return "draining"
+	}
+	return "unknown"
+}
+
+// MarshalText encodes the state by its name.
+func (s NodeState) MarshalText() ([]byte, error) {
+	switch s {
+	case NodeIdle, NodeBusy, NodeDraining:
+		return []byte(s.String()), nil
+	}
+	return nil, fmt.Errorf("unknown node state %d", int(s))
+}
+
+// UnmarshalText decodes a state name, an unknown name decodes
+// to the zero state so newer states do not break older readers.
+func (s *NodeState) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "idle":
+		*s = NodeIdle
+	case "busy":
+		*s = NodeBusy
+	case "draining":
+		*s = NodeDraining
+	default:
+		*s = 0
+	}
+	return nil
+}
+
+// SetNodeState changes the state of this node, the next heartbeat
+// stores it in the node value. Call Tick to store it right away.
+func (rd *RedisDriver) SetNodeState(state NodeState) error {
+	switch state {
+	case NodeIdle, NodeBusy, NodeDraining:
+	default:
+		return fmt.Errorf("%w: unknown node state %d", ErrInvalidOption, int(state))
+	}
+	rd.state.Store(int32(state))
+	return nil
+}
@@ -0,0 +1,61 @@
+package redisdriver_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dcron-contrib/commons"
+	"github.com/dcron-contrib/commons/dlog"
+	"github.com/dcron-contrib/redisdriver"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisDriver_SetNodeState(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(5*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithHeartbeatInterval(20*time.Millisecond))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+
+	nodes, err := drv.GetNodesWithMeta(context.Background())
+	require.Nil(t, err)
+	require.Len(t, nodes, 1)
+	require.Equal(t, redisdriver.NodeState(0), nodes[0].State)
+
+	for _, state := range []redisdriver.NodeState{redisdriver.NodeBusy, redisdriver.NodeIdle, redisdriver.NodeDraining} {
+		require.Nil(t, drv.SetNodeState(state))
+		require.Eventually(t, func() bool {
+			nodes, err := drv.GetNodesWithMeta(context.Background())
+			return err == nil && len(nodes) == 1 && nodes[0].State == state
+		}, time.Second, 10*time.Millisecond, state.String())
+	}
+
+	require.Nil(t, drv.SetNodeState(redisdriver.NodeBusy))
+	require.Nil(t, drv.Tick(context.Background()))
+	nodes, err = drv.GetNodesWithMeta(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, redisdriver.NodeBusy, nodes[0].State)
+
+	require.ErrorIs(t, drv.SetNodeState(0), redisdriver.ErrInvalidOption)
+	require.ErrorIs(t, drv.SetNodeState(redisdriver.NodeState(42)), redisdriver.ErrInvalidOption)
+}
+
+func TestNodeState_JSON(t *testing.T) {
+	value, err := json.Marshal(redisdriver.NodeInfo{ID: "node-1", State: redisdriver.NodeBusy})
+	require.Nil(t, err)
+	require.Contains(t, string(value), `"state":"busy"`)
+
+	var info redisdriver.NodeInfo
+	require.Nil(t, json.Unmarshal(value, &info))
+	require.Equal(t, redisdriver.NodeBusy, info.State)
+
+	require.Nil(t, json.Unmarshal([]byte(`{"id":"node-1","state":"sleeping"}`), &info))
+	require.Equal(t, redisdriver.NodeState(0), info.State)
+	require.Equal(t, "unknown", info.State.String())
+}
@@ -0,0 +1,592 @@
+package redisdriver
+
+import (
+	"context"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	OptionTypeHeartbeatInterval = 0x700 + iota
+	OptionTypeScanCount
+	OptionTypeNodeMetadata
+	OptionTypeOnLeadershipLost
+	OptionTypeKeyspaceNotifications
+	OptionTypeKeyPrefix
+	OptionTypeMetrics
+	OptionTypeTracer
+	OptionTypeMaxRetries
+	OptionTypeRetryBackoff
+	OptionTypeClock
+	OptionTypeHeartbeatJitter
+	OptionTypeOnRegistrationLost
+	OptionTypeClusterMode
+	OptionTypeReadFromReplica
+	OptionTypeNodeID
+	OptionTypeKeyBuilder
+	OptionTypeNodeCache
+	OptionTypeLastKnownFallback
+	OptionTypeLenientScan
+	OptionTypeHeartbeatTimeout
+	OptionTypeStopGracePeriod
+	OptionTypeClockSkewAlert
+	OptionTypeExternalHeartbeat
+	OptionTypeAdvertiseAddr
+	OptionTypeNodeWeight
+	OptionTypeSerializer
+	OptionTypeMetadataCompression
+	OptionTypeMinTimeout
+	OptionTypeOnNodeJoin
+	OptionTypeOnNodeLeave
+	OptionTypeDB
+	OptionTypeTTLRefreshRatio
+	OptionTypeInitialRegisterDelay
+	OptionTypeStaleCleanup
+	OptionTypeHashStorage
+	OptionTypeMembershipEvents
+	OptionTypeReconnectRegister
+	OptionTypeRegisterHook
+	OptionTypeScanRateLimit
+	OptionTypeRefreshOnly
+	OptionTypeCommandTimeout
+	OptionTypeConsistentSnapshot
+	OptionTypeTopologyWatch
+)
+
+// HeartbeatIntervalOption sets how often the node key is refreshed.
+// When unset, the driver refreshes at half of the timeout.
+type HeartbeatIntervalOption struct{ Interval time.Duration }
+
+func (o HeartbeatIntervalOption) Type() int { return OptionTypeHeartbeatInterval }
+func WithHeartbeatInterval(interval time.Duration) HeartbeatIntervalOption {
+	return HeartbeatIntervalOption{Interval: interval}
+}
+
+// ScanCountOption sets the COUNT hint used by SCAN during node discovery,
+// default is 100. A larger count means fewer round trips per GetNodes
+// at the cost of a larger reply per call. It must be positive.
+type ScanCountOption struct{ Count int64 }
+
+func (o ScanCountOption) Type() int { return OptionTypeScanCount }
+func WithScanCount(count int64) ScanCountOption {
+	return ScanCountOption{Count: count}
+}
+
+// NodeMetadataOption attaches labels to the local node, they are stored
+// in the node value and rewritten on every heartbeat. The encoded node
+// value is limited to 4KiB.
+type NodeMetadataOption struct{ Labels map[string]string }
+
+func (o NodeMetadataOption) Type() int { return OptionTypeNodeMetadata }
+func WithNodeMetadata(labels map[string]string) NodeMetadataOption {
+	return NodeMetadataOption{Labels: labels}
+}
+
+// OnLeadershipLostOption sets a callback invoked when the leadership
+// is lost without ResignLeadership, e.g. the lease could not be renewed.
+type OnLeadershipLostOption struct{ Callback func() }
+
+func (o OnLeadershipLostOption) Type() int { return OptionTypeOnLeadershipLost }
+func WithOnLeadershipLost(callback func()) OnLeadershipLostOption {
+	return OnLeadershipLostOption{Callback: callback}
+}
+
+// KeyspaceNotificationsOption makes Watch subscribe the expired and del
+// key events of the server to detect leaving nodes without polling delay.
+// The server must have notify-keyspace-events configured (e.g. "Exg"),
+// otherwise Watch falls back to polling. A server publishes the events of
+// its own keys only, so on a cluster client Watch subscribes every master
+// the cluster has when it is called, the leaves on masters added later are
+// found by polling.
+type KeyspaceNotificationsOption struct{ Enabled bool }
+
+func (o KeyspaceNotificationsOption) Type() int { return OptionTypeKeyspaceNotifications }
+func WithKeyspaceNotifications(enabled bool) KeyspaceNotificationsOption {
+	return KeyspaceNotificationsOption{Enabled: enabled}
+}
+
+// KeyPrefixOption prepends a namespace (e.g. "prod:dcron:") to every key
+// the driver reads and writes, so deployments sharing a redis instance
+// do not see each other's nodes. Changing it after Start is unsupported.
+type KeyPrefixOption struct{ Prefix string }
+
+func (o KeyPrefixOption) Type() int { return OptionTypeKeyPrefix }
+func WithKeyPrefix(prefix string) KeyPrefixOption {
+	return KeyPrefixOption{Prefix: prefix}
+}
+
+// MetricsOption sets the collector of the driver metrics,
+// the default collector discards them.
+type MetricsOption struct{ Collector MetricsCollector }
+
+func (o MetricsOption) Type() int { return OptionTypeMetrics }
+func WithMetrics(collector MetricsCollector) MetricsOption {
+	return MetricsOption{Collector: collector}
+}
+
+// TracerOption sets the tracer used to start spans around the redis
+// round trips of the driver, the default tracer is a no-op.
+type TracerOption struct{ Tracer trace.Tracer }
+
+func (o TracerOption) Type() int { return OptionTypeTracer }
+func WithTracer(tracer trace.Tracer) TracerOption {
+	return TracerOption{Tracer: tracer}
+}
+
+// MaxRetriesOption sets how many times a failed heartbeat is retried
+// before waiting for the next tick, default is 3. Zero disables retries.
+type MaxRetriesOption struct{ MaxRetries int }
+
+func (o MaxRetriesOption) Type() int { return OptionTypeMaxRetries }
+func WithMaxRetries(retries int) MaxRetriesOption {
+	return MaxRetriesOption{MaxRetries: retries}
+}
+
+// RetryBackoffOption sets the wait before the first heartbeat retry,
+// it doubles on every following retry. Default is 100ms.
+type RetryBackoffOption struct{ Backoff time.Duration }
+
+func (o RetryBackoffOption) Type() int { return OptionTypeRetryBackoff }
+func WithRetryBackoff(backoff time.Duration) RetryBackoffOption {
+	return RetryBackoffOption{Backoff: backoff}
+}
+
+// ClockOption replaces the real time clock of the driver,
+// it is meant for deterministic tests of the heartbeat.
+type ClockOption struct{ Clock Clock }
+
+func (o ClockOption) Type() int { return OptionTypeClock }
+func WithClock(clock Clock) ClockOption {
+	return ClockOption{Clock: clock}
+}
+
+// HeartbeatJitterOption randomizes every heartbeat interval by up to
+// ±Fraction of it, so nodes started together do not refresh at the same
+// instant. Fraction must be in [0, 1), zero disables the jitter.
+type HeartbeatJitterOption struct{ Fraction float64 }
+
+func (o HeartbeatJitterOption) Type() int { return OptionTypeHeartbeatJitter }
+func WithHeartbeatJitter(fraction float64) HeartbeatJitterOption {
+	return HeartbeatJitterOption{Fraction: fraction}
+}
+
+// OnRegistrationLostOption sets a callback invoked when a heartbeat finds
+// that the key of this node was gone, e.g. evicted or flushed, right
+// before it wrote the key again. It needs redis 6.2 or later, unless
+// WithRefreshOnly is set.
+type OnRegistrationLostOption struct{ Callback func() }
+
+func (o OnRegistrationLostOption) Type() int { return OptionTypeOnRegistrationLost }
+func WithOnRegistrationLost(callback func()) OnRegistrationLostOption {
+	return OnRegistrationLostOption{Callback: callback}
+}
+
+// ClusterModeOption wraps the service part of every key in a hash tag,
+// e.g. {distributed-cron:<service>}:<node id>, so that all keys of a
+// service live in one slot of a redis cluster and SCAN on that shard
+// finds every node. The trade-off is that a single shard carries the
+// whole membership of the service. Nodes of a service must agree on the
+// mode, the keys with and without hash tag do not see each other.
+type ClusterModeOption struct{ Enabled bool }
+
+func (o ClusterModeOption) Type() int { return OptionTypeClusterMode }
+func WithClusterMode() ClusterModeOption {
+	return ClusterModeOption{Enabled: true}
+}
+
+// ReadFromReplicaOption sends the discovery reads, the SCAN of GetNodes,
+// GetNodeCount and Watch and the MGET of GetNodesWithMeta, to a replica
+// client, e.g. a cluster client with ReadOnly set or a failover client
+// with ReplicaOnly set. The heartbeat still writes to the master. Replicas
+// lag behind, so a node that just registered may be missing from the
+// reads for a moment. A nil client reads from the master again.
+type ReadFromReplicaOption struct{ Replica redis.UniversalClient }
+
+func (o ReadFromReplicaOption) Type() int { return OptionTypeReadFromReplica }
+func WithReadFromReplica(replica redis.UniversalClient) ReadFromReplicaOption {
+	return ReadFromReplicaOption{Replica: replica}
+}
+
+// NodeIDOption replaces the generated node id, e.g. by the pod name.
+// The id is part of the node key, so it must not contain ':' or the glob
+// characters of SCAN.
+type NodeIDOption struct{ NodeID string }
+
+func (o NodeIDOption) Type() int { return OptionTypeNodeID }
+func WithNodeID(nodeID string) NodeIDOption {
+	return NodeIDOption{NodeID: nodeID}
+}
+
+// KeyBuilder returns the key of a node of a service. Called with the node
+// id "*" it returns the SCAN pattern of all node keys of the service, so
+// "*" must be the only glob character of the result and the node id the
+// only part that differs between the nodes. The pattern must not match
+// the keys "<key prefix>distributed-cron:<service>@<name>" of the leader,
+// the mutexes and the other service wide resources, e.g.
+//
+//	func(serviceName, nodeID string) string {
+//		return "cron:v2:" + serviceName + ":node:" + nodeID
+//	}
+type KeyBuilder func(serviceName, nodeID string) string
+
+// KeyBuilderOption replaces the layout of the node keys, the key prefix
+// and the cluster mode only apply to the default layout. A nil builder
+// restores the default.
+type KeyBuilderOption struct{ Builder KeyBuilder }
+
+func (o KeyBuilderOption) Type() int { return OptionTypeKeyBuilder }
+func WithKeyBuilder(builder KeyBuilder) KeyBuilderOption {
+	return KeyBuilderOption{Builder: builder}
+}
+
+// NodeCacheOption makes GetNodes return a cached list of nodes that is at
+// most TTL old, so a join or leave is seen up to TTL later. Once the list
+// is older than half the TTL it is refreshed in background while the
+// driver is started, Stop waits for that refresh. A list older than TTL
+// is refreshed before GetNodes returns. Zero disables the cache.
+type NodeCacheOption struct{ TTL time.Duration }
+
+func (o NodeCacheOption) Type() int { return OptionTypeNodeCache }
+func WithNodeCache(ttl time.Duration) NodeCacheOption {
+	return NodeCacheOption{TTL: ttl}
+}
+
+// LastKnownFallbackOption makes GetNodes return the latest successful list
+// of nodes when the scan fails, together with an error wrapping
+// ErrStaleNodes and the scan error. A short redis outage then does not
+// look like a service without nodes.
+type LastKnownFallbackOption struct{ Enabled bool }
+
+func (o LastKnownFallbackOption) Type() int { return OptionTypeLastKnownFallback }
+func WithLastKnownFallback() LastKnownFallbackOption {
+	return LastKnownFallbackOption{Enabled: true}
+}
+
+// LenientScanOption makes a scan that fails midway return the keys found
+// so far, together with an error wrapping ErrPartialScan and the scan
+// error. By default the found keys are dropped and only the error is
+// returned. A partial list of GetNodes does not replace the list of
+// WithLastKnownFallback or WithNodeCache.
+type LenientScanOption struct{ Enabled bool }
+
+func (o LenientScanOption) Type() int { return OptionTypeLenientScan }
+func WithLenientScan() LenientScanOption {
+	return LenientScanOption{Enabled: true}
+}
+
+// HeartbeatTimeoutOption bounds every heartbeat write of the node key, so
+// a stuck call is abandoned and counted as a failed heartbeat instead of
+// delaying the next ticks. The default is half the timeout.
+type HeartbeatTimeoutOption struct{ Timeout time.Duration }
+
+func (o HeartbeatTimeoutOption) Type() int { return OptionTypeHeartbeatTimeout }
+func WithHeartbeatTimeout(timeout time.Duration) HeartbeatTimeoutOption {
+	return HeartbeatTimeoutOption{Timeout: timeout}
+}
+
+// StopGracePeriodOption makes Stop set the TTL of the node key to Period
+// instead of deleting it, so the node stays discoverable while the
+// in-flight coordination completes. Zero, the default, deletes the key.
+type StopGracePeriodOption struct{ Period time.Duration }
+
+func (o StopGracePeriodOption) Type() int { return OptionTypeStopGracePeriod }
+func WithStopGracePeriod(period time.Duration) StopGracePeriodOption {
+	return StopGracePeriodOption{Period: period}
+}
+
+// ClockSkewAlertOption compares the clock of redis with the local clock on
+// Start and then every heartbeat interval. When they are more than
+// Threshold apart the skew is logged and Callback, if set, is called with
+// it. The TTL of the node keys assumes that both clocks agree.
+type ClockSkewAlertOption struct {
+	Threshold time.Duration
+	Callback  func(skew time.Duration)
+}
+
+func (o ClockSkewAlertOption) Type() int { return OptionTypeClockSkewAlert }
+func WithClockSkewAlert(threshold time.Duration, callback func(skew time.Duration)) ClockSkewAlertOption {
+	return ClockSkewAlertOption{Threshold: threshold, Callback: callback}
+}
+
+// ExternalHeartbeatOption makes Start register the node once without
+// starting the heartbeat goroutine. The caller then keeps the node key
+// alive by calling Heartbeat more often than the timeout, e.g. from its
+// own scheduler or only while the node is healthy. Stop still releases
+// the key.
+type ExternalHeartbeatOption struct{ Enabled bool }
+
+func (o ExternalHeartbeatOption) Type() int { return OptionTypeExternalHeartbeat }
+func WithExternalHeartbeat() ExternalHeartbeatOption {
+	return ExternalHeartbeatOption{Enabled: true}
+}
+
+// AdvertiseAddrOption stores the host:port the node is reachable at in the
+// node value, GetNodesWithMeta returns it as NodeInfo.Addr. It lets the
+// nodes build a peer map for direct calls from the discovery alone.
+type AdvertiseAddrOption struct{ Addr string }
+
+func (o AdvertiseAddrOption) Type() int { return OptionTypeAdvertiseAddr }
+func WithAdvertiseAddr(addr string) AdvertiseAddrOption {
+	return AdvertiseAddrOption{Addr: addr}
+}
+
+// NodeWeightOption stores the capacity of the node, e.g. its CPU count or
+// concurrency, in the node value as NodeInfo.Weight, so that the jobs can
+// be spread in proportion. SetNodeWeight changes it at runtime.
+type NodeWeightOption struct{ Weight int }
+
+func (o NodeWeightOption) Type() int { return OptionTypeNodeWeight }
+func WithNodeWeight(weight int) NodeWeightOption {
+	return NodeWeightOption{Weight: weight}
+}
+
+// SerializerOption replaces the JSON encoding of the node values, e.g. by
+// a more compact or an encrypted one. The encoded value is still limited
+// to 4KiB. Its output may be binary, but must not start with the 5 bytes
+// "\x00dcz\x01" of the frame of WithMetadataCompression, every reader
+// takes such a value for a compressed one.
+type SerializerOption struct{ Serializer Serializer }
+
+func (o SerializerOption) Type() int { return OptionTypeSerializer }
+func WithSerializer(serializer Serializer) SerializerOption {
+	return SerializerOption{Serializer: serializer}
+}
+
+// MetadataCompressionOption gzips the encoded node values of at least
+// Threshold bytes, a 5 byte frame tells the readers to decompress them.
+// Smaller values are stored as they are. The 4KiB limit of the node value
+// applies to the compressed size, Start checks it with all options
+// applied. Zero disables the compression.
+type MetadataCompressionOption struct{ Threshold int }
+
+func (o MetadataCompressionOption) Type() int { return OptionTypeMetadataCompression }
+
+// WithMetadataCompression compresses the node values from 512 bytes on.
+func WithMetadataCompression() MetadataCompressionOption {
+	return MetadataCompressionOption{Threshold: defaultCompressionThreshold}
+}
+
+// MinTimeoutOption lowers or raises the smallest timeout the driver
+// accepts, 1s by default. A shorter timeout leaves the heartbeat at half
+// of it too little room for the network latency, the key expires before
+// it is refreshed and the node flaps. Pass it before the timeout option.
+type MinTimeoutOption struct{ MinTimeout time.Duration }
+
+func (o MinTimeoutOption) Type() int { return OptionTypeMinTimeout }
+func WithMinTimeout(minTimeout time.Duration) MinTimeoutOption {
+	return MinTimeoutOption{MinTimeout: minTimeout}
+}
+
+// OnNodeJoinOption sets a callback invoked with the id of every node that
+// joins the service while the driver runs, like the NodeJoin events of
+// Watch. The nodes alive on Start, this one included, join first. Every
+// call runs in its own goroutine, so under churn the calls may run out of
+// order, e.g. the leave of a node before its join.
+type OnNodeJoinOption struct{ Callback func(nodeID string) }
+
+func (o OnNodeJoinOption) Type() int { return OptionTypeOnNodeJoin }
+func WithOnNodeJoin(callback func(nodeID string)) OnNodeJoinOption {
+	return OnNodeJoinOption{Callback: callback}
+}
+
+// OnNodeLeaveOption sets a callback invoked with the id of every node that
+// leaves the service while the driver runs, like the NodeLeave events of
+// Watch. The calls run like the ones of WithOnNodeJoin.
+type OnNodeLeaveOption struct{ Callback func(nodeID string) }
+
+func (o OnNodeLeaveOption) Type() int { return OptionTypeOnNodeLeave }
+func WithOnNodeLeave(callback func(nodeID string)) OnNodeLeaveOption {
+	return OnNodeLeaveOption{Callback: callback}
+}
+
+// DBOption is the logical database of the node keys. NewTLSDriver, and
+// NewDriverWithConfig without a client, create their client on it. A given
+// client must already select it, otherwise the keys would silently land
+// in another database. A cluster only has database 0, any other is
+// rejected.
+type DBOption struct{ DB int }
+
+func (o DBOption) Type() int { return OptionTypeDB }
+func WithDB(db int) DBOption {
+	return DBOption{DB: db}
+}
+
+// TTLRefreshRatioOption sets the heartbeat interval to the timeout divided
+// by Ratio, 2 by default. A greater ratio refreshes the key more often and
+// survives more lost heartbeats, a ratio close to 1 writes less but lets
+// the key expire on a single slow refresh. WithHeartbeatInterval wins
+// over the ratio.
+type TTLRefreshRatioOption struct{ Ratio float64 }
+
+func (o TTLRefreshRatioOption) Type() int { return OptionTypeTTLRefreshRatio }
+func WithTTLRefreshRatio(ratio float64) TTLRefreshRatioOption {
+	return TTLRefreshRatioOption{Ratio: ratio}
+}
+
+// InitialRegisterDelayOption makes Start return without registering the
+// node, the heartbeat claims the node key Delay later and refreshes it
+// from then on as usual. Until then the node is not discoverable, e.g. it
+// waits for a readiness check to pass. The delay does not count against
+// the timeout, the key lives a timeout from its first write on. A failed
+// first write is retried every heartbeat interval and, unlike in Start,
+// only logged. With WithExternalHeartbeat the delay is ignored.
+type InitialRegisterDelayOption struct{ Delay time.Duration }
+
+func (o InitialRegisterDelayOption) Type() int { return OptionTypeInitialRegisterDelay }
+func WithInitialRegisterDelay(delay time.Duration) InitialRegisterDelayOption {
+	return InitialRegisterDelayOption{Delay: delay}
+}
+
+// StaleCleanupOption makes GetNodes delete the node keys whose latest
+// heartbeat, the UpdatedAt of the value, is older than MaxAge. Such keys
+// outlive their TTL, e.g. restored from a snapshot without it, or belong
+// to a crashed node under a very long timeout. The age is measured by the
+// clock of the reader, so MaxAge below twice the timeout is raised to it
+// to keep the keys of healthy nodes with skewed clocks. A key is only
+// deleted if it was not refreshed since it was read. It costs an MGET
+// per GetNodes.
+type StaleCleanupOption struct{ MaxAge time.Duration }
+
+func (o StaleCleanupOption) Type() int { return OptionTypeStaleCleanup }
+func WithStaleCleanup(maxAge time.Duration) StaleCleanupOption {
+	return StaleCleanupOption{MaxAge: maxAge}
+}
+
+// HashStorageOption stores all nodes of the service as the fields of one
+// hash instead of one key per node, GetNodes reads them by one HGETALL
+// instead of a SCAN. The fields have no TTL, a field whose UpdatedAt is
+// older than the timeout is filtered out and deleted by the reader, so
+// the clocks of the nodes must agree to well within the timeout minus the
+// heartbeat interval. The hash itself expires a timeout after the latest
+// heartbeat of any node. All nodes of a service must use the same
+// storage. GetNodesPage and NodesIterator return ErrPagesUnsupported,
+// keyspace notifications and the stop grace period are not used, and
+// ListServices does not find the service. It suits small services, every
+// GetNodes reads all fields.
+type HashStorageOption struct{ Enabled bool }
+
+func (o HashStorageOption) Type() int { return OptionTypeHashStorage }
+func WithHashStorage() HashStorageOption {
+	return HashStorageOption{Enabled: true}
+}
+
+// MembershipEventsOption makes the driver publish the join of its node on
+// Start and the leave on Stop, for the subscribers of SubscribeMembership.
+// A Stop with a grace period publishes no leave, the key expires later.
+type MembershipEventsOption struct{ Enabled bool }
+
+func (o MembershipEventsOption) Type() int { return OptionTypeMembershipEvents }
+func WithMembershipEvents() MembershipEventsOption {
+	return MembershipEventsOption{Enabled: true}
+}
+
+// ReconnectRegisterOption makes the heartbeat register the node as soon
+// as the client dials a new connection after failed heartbeats, instead
+// of waiting for the next, possibly backed off, tick. The node key may
+// have expired during the outage. The client dials when it is used, by
+// the driver or by other code sharing it. The hook does not notice a
+// reconnect by itself, the signal only fires once a command dials again.
+// The registrations on reconnect are at least a second apart and dials
+// while the heartbeat succeeds are ignored. It adds a hook to the client
+// that stays after Stop. On a cluster client the shard clients are hooked,
+// once per client for all its drivers.
+type ReconnectRegisterOption struct{ Enabled bool }
+
+func (o ReconnectRegisterOption) Type() int { return OptionTypeReconnectRegister }
+func WithReconnectRegister() ReconnectRegisterOption {
+	return ReconnectRegisterOption{Enabled: true}
+}
+
+// RegisterHook adds commands to pipe, they are sent with the write of the
+// node key. An error fails the write.
+type RegisterHook func(ctx context.Context, pipe redis.Pipeliner) error
+
+// RegisterHookOption runs Hook on every write of the node key, by Start,
+// the heartbeat, Heartbeat, Tick and Register, e.g. to refresh a related
+// lease. The commands of the hook and the write of the node key run in
+// one MULTI/EXEC, so on a cluster client their keys must be in the slot
+// of the node key, see WithClusterMode. On Start the node key is watched
+// while it is claimed, the claim and the hook are one MULTI/EXEC too.
+// With WithHashStorage the hook is queued with the writes of the hashes,
+// in one MULTI/EXEC unless the client is a cluster client without
+// WithClusterMode, then the commands are only pipelined. With
+// WithRefreshOnly a key that is gone is claimed again after the hook ran,
+// by separate calls. RefreshAndList falls back to separate calls.
+type RegisterHookOption struct{ Hook RegisterHook }
+
+func (o RegisterHookOption) Type() int { return OptionTypeRegisterHook }
+func WithRegisterHook(hook RegisterHook) RegisterHookOption {
+	return RegisterHookOption{Hook: hook}
+}
+
+// ScanRateLimitOption starts at most RPS discovery scans per second, e.g.
+// to protect a shared redis from a caller polling GetNodes in a loop. It
+// limits the SCANs of GetNodes, GetNodeCount, GetRawNodeKeys,
+// GetNodesWithMeta and ListServices, and the hash reads of
+// WithHashStorage. A call over the limit waits for its turn or until its
+// context is done, there is no burst. With WithNodeCache only the scans
+// that refresh the cache are limited, so callers get the cached list
+// instead of waiting. Zero disables the limit.
+type ScanRateLimitOption struct{ RPS float64 }
+
+func (o ScanRateLimitOption) Type() int { return OptionTypeScanRateLimit }
+func WithScanRateLimit(rps float64) ScanRateLimitOption {
+	return ScanRateLimitOption{RPS: rps}
+}
+
+// RefreshOnlyOption makes the heartbeat refresh the node key by SET XX,
+// which only writes a key that exists. A key that expired or was removed
+// is claimed again like by Start, so a heartbeat tells a refresh from
+// a registration that was lost meanwhile: the loss is logged and the
+// callback of WithOnRegistrationLost runs once, the following heartbeats
+// refresh the new key. If another process took the node ID meanwhile the
+// heartbeat fails with ErrNodeIDCollision. It costs more round trips only
+// on a loss and works on servers older than redis 6.2, unlike the SET GET
+// used for WithOnRegistrationLost alone. WithHashStorage detects losses
+// by itself and RefreshAndList falls back to separate calls.
+type RefreshOnlyOption struct{ Enabled bool }
+
+func (o RefreshOnlyOption) Type() int { return OptionTypeRefreshOnly }
+func WithRefreshOnly() RefreshOnlyOption {
+	return RefreshOnlyOption{Enabled: true}
+}
+
+// CommandTimeoutOption bounds the redis calls of the driver separately
+// from the timeout, which stays the TTL of the node key, e.g. a TTL of
+// 30s with calls that fail after 1s. It bounds the writes and deletes of
+// the node key and the scans of the discovery, a scan as a whole. The
+// heartbeat write is bounded by the shorter one of it and
+// WithHeartbeatTimeout. Zero, the default, bounds the calls and the scans
+// by the timeout.
+type CommandTimeoutOption struct{ Timeout time.Duration }
+
+func (o CommandTimeoutOption) Type() int { return OptionTypeCommandTimeout }
+func WithCommandTimeout(timeout time.Duration) CommandTimeoutOption {
+	return CommandTimeoutOption{Timeout: timeout}
+}
+
+// ConsistentSnapshotOption makes GetNodes and GetNodeCount answer from the
+// latest Snapshot, so a scheduler that takes a snapshot at the start of a
+// tick gets the same nodes from a count and a following list. A snapshot
+// is used until the next one or for one heartbeat interval, then the
+// calls scan again. It takes precedence over WithNodeCache.
+type ConsistentSnapshotOption struct{ Enabled bool }
+
+func (o ConsistentSnapshotOption) Type() int { return OptionTypeConsistentSnapshot }
+func WithConsistentSnapshot() ConsistentSnapshotOption {
+	return ConsistentSnapshotOption{Enabled: true}
+}
+
+// TopologyWatchOption makes the heartbeat check the node key right away
+// when a shard of a cluster client redirects a command by MOVED or ASK,
+// the key may have been lost while its slot moved. The shard clients are
+// hooked on Start, once per cluster client for all its drivers. It has no
+// effect on other clients.
+type TopologyWatchOption struct{ Enabled bool }
+
+func (o TopologyWatchOption) Type() int { return OptionTypeTopologyWatch }
+func WithTopologyWatch() TopologyWatchOption {
+	return TopologyWatchOption{Enabled: true}
+}
@@ -0,0 +1,600 @@
+package redisdriver_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dcron-contrib/commons"
+	"github.com/dcron-contrib/commons/dlog"
+	"github.com/dcron-contrib/redisdriver"
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisDriver_HeartbeatIntervalOption(t *testing.T) {
+	rds := miniredis.RunT(t)
+	var registers int32
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{
+		process: func(ctx context.Context, cmd redis.Cmder, next redis.ProcessHook) error {
+			if cmd.Name() == "setex" {
+				atomic.AddInt32(&registers, 1)
+			}
+			return next(ctx, cmd)
+		},
+	})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(5*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithHeartbeatInterval(50*time.Millisecond))
+
+	require.Nil(t, drv.Start(context.Background()))
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&registers) >= 5
+	}, time.Second, 10*time.Millisecond)
+	require.Nil(t, drv.Stop(context.Background()))
+}
+
+func TestRedisDriver_HeartbeatIntervalOptionInvalid(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name(), commons.NewTimeoutOption(5*time.Second))
+
+	require.ErrorIs(t, drv.WithOption(redisdriver.WithHeartbeatInterval(5*time.Second)), redisdriver.ErrInvalidOption)
+	require.ErrorIs(t, drv.WithOption(redisdriver.WithHeartbeatInterval(0)), redisdriver.ErrInvalidOption)
+	require.Nil(t, drv.WithOption(redisdriver.WithHeartbeatInterval(time.Second)))
+	require.ErrorIs(t, drv.WithOption(commons.NewTimeoutOption(time.Second)), redisdriver.ErrInvalidOption)
+	require.Nil(t, drv.WithOption(commons.NewTimeoutOption(2*time.Second)))
+}
+
+func TestRedisDriver_MinTimeout(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name(), commons.NewTimeoutOption(100*time.Millisecond))
+	require.ErrorIs(t, drv.Start(context.Background()), redisdriver.ErrInvalidOption)
+
+	drv = testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name())
+	require.ErrorIs(t, drv.WithOption(commons.NewTimeoutOption(999*time.Millisecond)), redisdriver.ErrInvalidOption)
+	require.ErrorIs(t, drv.SetTimeout(500*time.Millisecond), redisdriver.ErrInvalidOption)
+	require.Nil(t, drv.WithOption(commons.NewTimeoutOption(time.Second)))
+
+	require.ErrorIs(t, drv.WithOption(redisdriver.WithMinTimeout(0)), redisdriver.ErrInvalidOption)
+	require.ErrorIs(t, drv.WithOption(redisdriver.WithMinTimeout(2*time.Second)), redisdriver.ErrInvalidOption)
+	require.Nil(t, drv.WithOption(redisdriver.WithMinTimeout(100*time.Millisecond)))
+	require.Nil(t, drv.WithOption(commons.NewTimeoutOption(200*time.Millisecond)))
+	require.ErrorIs(t, drv.WithOption(commons.NewTimeoutOption(50*time.Millisecond)), redisdriver.ErrInvalidOption)
+}
+
+func TestRedisDriver_ScanCountOptionInvalid(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name())
+
+	require.ErrorIs(t, drv.WithOption(redisdriver.WithScanCount(0)), redisdriver.ErrInvalidOption)
+	require.ErrorIs(t, drv.WithOption(redisdriver.WithScanCount(-1)), redisdriver.ErrInvalidOption)
+	require.Nil(t, drv.WithOption(redisdriver.WithScanCount(1000)))
+}
+
+func TestRedisDriver_KeyPrefixOption(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv1 := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv1.Init(t.Name(),
+		commons.NewTimeoutOption(5*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithKeyPrefix("prod:"))
+	drv2 := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv2.Init(t.Name(),
+		commons.NewTimeoutOption(5*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithKeyPrefix("dev:"))
+	require.Nil(t, drv1.Start(context.Background()))
+	require.Nil(t, drv2.Start(context.Background()))
+
+	require.True(t, rds.Exists("prod:"+testFuncNodeKey(t.Name(), drv1.NodeID())))
+	require.True(t, rds.Exists("dev:"+testFuncNodeKey(t.Name(), drv2.NodeID())))
+	nodes, err := drv1.GetNodes(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, []string{drv1.NodeID()}, nodes)
+	nodes, err = drv2.GetNodes(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, []string{drv2.NodeID()}, nodes)
+
+	require.Nil(t, drv1.Stop(context.Background()))
+	require.Nil(t, drv2.Stop(context.Background()))
+	// only the generation counters are left.
+	require.ElementsMatch(t, []string{
+		"prod:distributed-cron:" + t.Name() + "@generation-" + drv1.NodeID(),
+		"dev:distributed-cron:" + t.Name() + "@generation-" + drv2.NodeID(),
+	}, rds.Keys())
+
+	require.ErrorIs(t, drv1.WithOption(redisdriver.WithKeyPrefix("")), redisdriver.ErrInvalidOption)
+}
+
+func TestRedisDriver_HeartbeatJitterOptionInvalid(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name())
+
+	require.ErrorIs(t, drv.WithOption(redisdriver.WithHeartbeatJitter(-0.1)), redisdriver.ErrInvalidOption)
+	require.ErrorIs(t, drv.WithOption(redisdriver.WithHeartbeatJitter(1)), redisdriver.ErrInvalidOption)
+	require.Nil(t, drv.WithOption(redisdriver.WithHeartbeatJitter(0.2)))
+}
+
+func TestRedisDriver_ClusterModeOption(t *testing.T) {
+	rds := miniredis.RunT(t)
+	client := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{rds.Addr()}})
+	defer client.Close()
+	drvs := make([]*redisdriver.RedisDriver, 2)
+	for i := range drvs {
+		drvs[i] = redisdriver.NewDriver(client)
+		drvs[i].Init(t.Name(),
+			commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+			redisdriver.WithClusterMode())
+		require.Nil(t, drvs[i].Start(context.Background()))
+		defer drvs[i].Stop(context.Background())
+	}
+	ok, err := drvs[0].TryAcquireLeadership(context.Background())
+	require.Nil(t, err)
+	require.True(t, ok)
+
+	// two node keys, two generation counters and the leader key
+	keys := rds.Keys()
+	require.Len(t, keys, 5)
+	slot, err := client.ClusterKeySlot(context.Background(), keys[0]).Result()
+	require.Nil(t, err)
+	for _, key := range keys {
+		require.True(t, strings.HasPrefix(key, "{distributed-cron:"+t.Name()+"}"), key)
+		keySlot, err := client.ClusterKeySlot(context.Background(), key).Result()
+		require.Nil(t, err)
+		require.Equal(t, slot, keySlot)
+	}
+	nodes, err := drvs[1].GetNodes(context.Background())
+	require.Nil(t, err)
+	require.ElementsMatch(t, []string{drvs[0].NodeID(), drvs[1].NodeID()}, nodes)
+}
+
+func TestRedisDriver_ReadFromReplicaOption(t *testing.T) {
+	master, replica := miniredis.RunT(t), miniredis.RunT(t)
+	var mu sync.Mutex
+	commands := map[string][]string{}
+	recorder := func(name string) *testHook {
+		return &testHook{
+			process: func(ctx context.Context, cmd redis.Cmder, next redis.ProcessHook) error {
+				mu.Lock()
+				commands[name] = append(commands[name], cmd.Name())
+				mu.Unlock()
+				return next(ctx, cmd)
+			},
+		}
+	}
+	replicaCli := redis.NewClient(&redis.Options{Addr: replica.Addr()})
+	replicaCli.AddHook(recorder("replica"))
+	defer replicaCli.Close()
+	drv := testFuncNewRedisDriverWithHook(master.Addr(), recorder("master"))
+	drv.Init(t.Name(),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithReadFromReplica(replicaCli))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, master, drv)
+
+	// not replicated yet
+	nodes, err := drv.GetNodes(context.Background())
+	require.Nil(t, err)
+	require.Empty(t, nodes)
+
+	key := testFuncNodeKey(t.Name(), drv.NodeID())
+	value, err := master.Get(key)
+	require.Nil(t, err)
+	require.Nil(t, replica.Set(key, value))
+	nodes, err = drv.GetNodes(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, []string{drv.NodeID()}, nodes)
+	infos, err := drv.GetNodesWithMeta(context.Background())
+	require.Nil(t, err)
+	require.Len(t, infos, 1)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []string{"set"}, commands["master"])
+	require.Equal(t, []string{"scan", "scan", "scan", "mget"}, commands["replica"])
+}
+
+func TestRedisDriver_NodeIDOption(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name(),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithNodeID("pod-1"))
+	require.Equal(t, "pod-1", drv.NodeID())
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+	require.True(t, rds.Exists(testFuncNodeKey(t.Name(), "pod-1")))
+	nodes, err := drv.GetNodes(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, []string{"pod-1"}, nodes)
+
+	require.ErrorIs(t, drv.WithOption(redisdriver.WithNodeID("pod-2")), redisdriver.ErrInvalidOption)
+	require.Equal(t, "pod-1", drv.NodeID())
+}
+
+func TestRedisDriver_NodeIDOptionInvalid(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name())
+
+	for _, nodeID := range []string{"", "pod:1", "pod*", "pod?", "pod[1]"} {
+		require.ErrorIs(t, drv.WithOption(redisdriver.WithNodeID(nodeID)), redisdriver.ErrInvalidOption, nodeID)
+	}
+	// the option given to NewDriver is kept by Init
+	drv = redisdriver.NewDriver(drv.Client(), redisdriver.WithNodeID("pod-1"))
+	drv.Init(t.Name())
+	require.Equal(t, "pod-1", drv.NodeID())
+}
+
+func TestRedisDriver_KeyBuilderOption(t *testing.T) {
+	rds := miniredis.RunT(t)
+	builder := func(serviceName, nodeID string) string {
+		return "cron:v2:" + serviceName + ":node:" + nodeID + ":alive"
+	}
+	drvs := make([]*redisdriver.RedisDriver, 2)
+	for i := range drvs {
+		drvs[i] = testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+		drvs[i].Init(t.Name(),
+			commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+			redisdriver.WithKeyBuilder(builder))
+		require.Nil(t, drvs[i].Start(context.Background()))
+	}
+	// a key of another layout is not a node.
+	require.Nil(t, rds.Set("cron:v2:"+t.Name()+":node:other", "{}"))
+	for _, drv := range drvs {
+		require.True(t, rds.Exists(builder(t.Name(), drv.NodeID())))
+	}
+	nodes, err := drvs[0].GetNodes(context.Background())
+	require.Nil(t, err)
+	require.ElementsMatch(t, []string{drvs[0].NodeID(), drvs[1].NodeID()}, nodes)
+	infos, err := drvs[0].GetNodesWithMeta(context.Background())
+	require.Nil(t, err)
+	require.Len(t, infos, 2)
+
+	for _, drv := range drvs {
+		require.Nil(t, drv.Stop(context.Background()))
+		require.False(t, rds.Exists(builder(t.Name(), drv.NodeID())))
+	}
+
+	for _, invalid := range []redisdriver.KeyBuilder{
+		func(serviceName, nodeID string) string { return serviceName },
+		func(serviceName, nodeID string) string { return "*" + serviceName + nodeID },
+		func(serviceName, nodeID string) string { return serviceName + "?" + nodeID },
+		// no literal prefix, the pattern scans the whole keyspace.
+		func(serviceName, nodeID string) string { return nodeID + ":" + serviceName },
+		// the pattern matches the leader, events, mutex and job keys.
+		func(serviceName, nodeID string) string { return "distributed-cron:" + serviceName + nodeID },
+		func(serviceName, nodeID string) string { return "distributed-cron:" + nodeID },
+		func(serviceName, nodeID string) string { return "distributed-cron:" + serviceName + "@nodes:" + nodeID },
+	} {
+		require.ErrorIs(t, drvs[0].WithOption(redisdriver.WithKeyBuilder(invalid)), redisdriver.ErrInvalidOption)
+	}
+}
+
+func TestRedisDriver_TTLRefreshRatio(t *testing.T) {
+	drv := redisdriver.NewDriver(redis.NewClient(&redis.Options{}))
+	drv.Init(t.Name(), commons.NewTimeoutOption(3*time.Second), redisdriver.WithTTLRefreshRatio(3))
+	require.Equal(t, time.Second, drv.HeartbeatInterval())
+	require.Nil(t, drv.SetTimeout(6*time.Second))
+	require.Equal(t, 2*time.Second, drv.HeartbeatInterval())
+	require.Nil(t, drv.WithOption(redisdriver.WithTTLRefreshRatio(1.5)))
+	require.Equal(t, 4*time.Second, drv.HeartbeatInterval())
+
+	for _, invalid := range []float64{1, 0.5, 0, -2, math.NaN(), math.Inf(1)} {
+		require.ErrorIs(t, drv.WithOption(redisdriver.WithTTLRefreshRatio(invalid)), redisdriver.ErrInvalidOption)
+	}
+	require.Equal(t, 4*time.Second, drv.HeartbeatInterval())
+
+	// an explicit interval wins over the ratio.
+	require.Nil(t, drv.WithOption(redisdriver.WithHeartbeatInterval(time.Second)))
+	require.Equal(t, time.Second, drv.HeartbeatInterval())
+}
+
+func TestRedisDriver_HeartbeatTimeoutOption(t *testing.T) {
+	drv := redisdriver.NewDriver(redis.NewClient(&redis.Options{}))
+	require.Nil(t, drv.WithOption(redisdriver.WithHeartbeatTimeout(time.Second)))
+	require.ErrorIs(t, drv.WithOption(redisdriver.WithHeartbeatTimeout(0)), redisdriver.ErrInvalidOption)
+	require.ErrorIs(t, drv.WithOption(redisdriver.WithHeartbeatTimeout(-time.Second)), redisdriver.ErrInvalidOption)
+}
+
+func TestRedisDriver_StopGracePeriodOption(t *testing.T) {
+	drv := redisdriver.NewDriver(redis.NewClient(&redis.Options{}))
+	require.Nil(t, drv.WithOption(redisdriver.WithStopGracePeriod(0)))
+	require.Nil(t, drv.WithOption(redisdriver.WithStopGracePeriod(time.Second)))
+	require.ErrorIs(t, drv.WithOption(redisdriver.WithStopGracePeriod(-time.Second)), redisdriver.ErrInvalidOption)
+}
+
+func TestRedisDriver_ClockSkewAlertOption(t *testing.T) {
+	drv := redisdriver.NewDriver(redis.NewClient(&redis.Options{}))
+	require.Nil(t, drv.WithOption(redisdriver.WithClockSkewAlert(time.Second, nil)))
+	require.ErrorIs(t, drv.WithOption(redisdriver.WithClockSkewAlert(0, nil)), redisdriver.ErrInvalidOption)
+}
+
+func TestRedisDriver_DBOption(t *testing.T) {
+	rds := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: rds.Addr(), DB: 2})
+	defer client.Close()
+	drv, err := redisdriver.NewDriverWithConfig(client, redisdriver.Config{DB: 2})
+	require.Nil(t, err)
+	drv.Init(t.Name(), commons.NewLoggerOption(dlog.NewLoggerForTest(t)))
+	require.Nil(t, drv.Start(context.Background()))
+	defer drv.Stop(context.Background())
+	require.True(t, rds.DB(2).Exists(testFuncNodeKey(t.Name(), drv.NodeID())))
+
+	// the keys would land in another db than the one asked for.
+	_, err = redisdriver.NewDriverWithConfig(client, redisdriver.Config{DB: 1})
+	require.ErrorIs(t, err, redisdriver.ErrInvalidOption)
+	require.ErrorIs(t, drv.WithOption(redisdriver.WithDB(0)), redisdriver.ErrInvalidOption)
+	require.ErrorIs(t, drv.WithOption(redisdriver.WithDB(-1)), redisdriver.ErrInvalidOption)
+}
+
+func TestRedisDriver_DBOptionCluster(t *testing.T) {
+	rds := miniredis.RunT(t)
+	client := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{rds.Addr()}})
+	defer client.Close()
+
+	_, err := redisdriver.NewDriverWithConfig(client, redisdriver.Config{DB: 1})
+	require.ErrorIs(t, err, redisdriver.ErrInvalidOption)
+	drv := redisdriver.NewDriver(client, redisdriver.WithDB(1))
+	drv.Init(t.Name(), commons.NewLoggerOption(dlog.NewLoggerForTest(t)))
+	require.ErrorIs(t, drv.Start(context.Background()), redisdriver.ErrInvalidOption)
+
+	_, err = redisdriver.NewDriverWithConfig(client, redisdriver.Config{DB: 0})
+	require.Nil(t, err)
+	require.Nil(t, redisdriver.NewDriver(client).WithOption(redisdriver.WithDB(0)))
+}
+
+func TestRedisDriver_RegisterHookOption(t *testing.T) {
+	rds := miniredis.RunT(t)
+	var fail atomic.Bool
+	hookErr := errors.New("hook failed")
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(10*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithRegisterHook(func(ctx context.Context, pipe redis.Pipeliner) error {
+			if fail.Load() {
+				return hookErr
+			}
+			pipe.Incr(ctx, "counter")
+			return nil
+		}))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+	counter, err := rds.Get("counter")
+	require.Nil(t, err)
+	require.Equal(t, "1", counter)
+
+	key := testFuncNodeKey(t.Name(), drv.NodeID())
+	rds.Del(key)
+	require.Nil(t, drv.Tick(context.Background()))
+	require.True(t, rds.Exists(key))
+	require.Equal(t, 10*time.Second, rds.TTL(key))
+	counter, _ = rds.Get("counter")
+	require.Equal(t, "2", counter)
+
+	fail.Store(true)
+	rds.Del(key)
+	require.ErrorIs(t, drv.Tick(context.Background()), hookErr)
+	require.False(t, rds.Exists(key))
+	counter, _ = rds.Get("counter")
+	require.Equal(t, "2", counter)
+}
+
+func TestRedisDriver_RegisterHookTransaction(t *testing.T) {
+	hook := func(ctx context.Context, pipe redis.Pipeliner) error {
+		pipe.Incr(ctx, "counter")
+		return nil
+	}
+	cases := map[string]struct {
+		write string
+		opts  []commons.Option
+	}{
+		"claim":       {"set", nil},
+		"hashStorage": {"hset", []commons.Option{redisdriver.WithHashStorage()}},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			rds := miniredis.RunT(t)
+			var mu sync.Mutex
+			var pipelines [][]string
+			drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{
+				pipeline: func(ctx context.Context, cmds []redis.Cmder, next redis.ProcessPipelineHook) error {
+					names := make([]string, 0, len(cmds))
+					for _, cmd := range cmds {
+						names = append(names, cmd.Name())
+					}
+					mu.Lock()
+					pipelines = append(pipelines, names)
+					mu.Unlock()
+					return next(ctx, cmds)
+				},
+			})
+			drv.Init(t.Name(), append([]commons.Option{
+				commons.NewTimeoutOption(10 * time.Second),
+				commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+				redisdriver.WithRegisterHook(hook),
+			}, c.opts...)...)
+			require.Nil(t, drv.Start(context.Background()))
+			defer testFuncStop(t, rds, drv)
+
+			// the claim of Start writes the node and runs the hook in one MULTI/EXEC.
+			mu.Lock()
+			defer mu.Unlock()
+			require.NotEmpty(t, pipelines)
+			claim := pipelines[len(pipelines)-1]
+			require.Equal(t, "multi", claim[0])
+			require.Contains(t, claim, c.write)
+			require.Contains(t, claim, "incr")
+			require.Equal(t, "exec", claim[len(claim)-1])
+		})
+	}
+
+	// another instance holds the key, the hook does not run.
+	rds := miniredis.RunT(t)
+	drv := testFuncNewRedisDriver(rds.Addr())
+	drv.Init(t.Name(),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithNodeID("node-1"),
+		redisdriver.WithRegisterHook(hook))
+	require.Nil(t, rds.Set(testFuncNodeKey(t.Name(), "node-1"), `{"instance":"other"}`))
+	require.ErrorIs(t, drv.Start(context.Background()), redisdriver.ErrNodeIDCollision)
+	require.False(t, rds.Exists("counter"))
+}
+
+func TestRedisDriver_RefreshOnlyOption(t *testing.T) {
+	rds := miniredis.RunT(t)
+	var lost int32
+	var mu sync.Mutex
+	var sets []string
+	var taken atomic.Bool
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{
+		process: func(ctx context.Context, cmd redis.Cmder, next redis.ProcessHook) error {
+			if testFuncIsRegister(cmd) {
+				name := cmd.Name()
+				for _, arg := range cmd.Args() {
+					switch arg {
+					case "XX":
+						name += " xx"
+					case "nx":
+						name += " nx"
+					}
+				}
+				mu.Lock()
+				sets = append(sets, name)
+				mu.Unlock()
+				if name == "set nx" && taken.Load() {
+					// another process takes the node ID after the SET XX.
+					require.Nil(t, rds.Set(cmd.Args()[1].(string), `{"instance":"other"}`))
+				}
+			}
+			return next(ctx, cmd)
+		},
+	})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(2*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithClock(newTestClock()),
+		redisdriver.WithRefreshOnly(),
+		redisdriver.WithOnRegistrationLost(func() { atomic.AddInt32(&lost, 1) }))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+	key := testFuncNodeKey(t.Name(), drv.NodeID())
+	lastSets := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		recorded := sets
+		sets = nil
+		return recorded
+	}
+	lastSets()
+
+	// a refresh writes the existing key only.
+	require.Nil(t, drv.Tick(context.Background()))
+	require.Equal(t, []string{"set xx"}, lastSets())
+	require.Equal(t, int32(0), atomic.LoadInt32(&lost))
+
+	// the expired key is claimed again and the loss is reported once.
+	rds.FastForward(2 * time.Second)
+	require.False(t, rds.Exists(key))
+	require.Nil(t, drv.Tick(context.Background()))
+	require.Equal(t, []string{"set xx", "set nx"}, lastSets())
+	require.True(t, rds.Exists(key))
+	require.Equal(t, 2*time.Second, rds.TTL(key))
+	require.Equal(t, int32(1), atomic.LoadInt32(&lost))
+	require.Nil(t, drv.Tick(context.Background()))
+	require.Equal(t, int32(1), atomic.LoadInt32(&lost))
+
+	// the key of another process is not overwritten.
+	rds.Del(key)
+	taken.Store(true)
+	require.ErrorIs(t, drv.Tick(context.Background()), redisdriver.ErrNodeIDCollision)
+	value, err := rds.Get(key)
+	require.Nil(t, err)
+	require.Equal(t, `{"instance":"other"}`, value)
+	rds.Del(key)
+}
+
+func TestRedisDriver_CommandTimeoutOption(t *testing.T) {
+	rds := miniredis.RunT(t)
+	var slow atomic.Bool
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{
+		process: func(ctx context.Context, cmd redis.Cmder, next redis.ProcessHook) error {
+			if slow.Load() && (testFuncIsRegister(cmd) || cmd.Name() == "scan") {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(5 * time.Second):
+				}
+			}
+			return next(ctx, cmd)
+		},
+	})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(30*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithClock(newTestClock()),
+		redisdriver.WithCommandTimeout(50*time.Millisecond))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+	key := testFuncNodeKey(t.Name(), drv.NodeID())
+	require.Equal(t, 30*time.Second, rds.TTL(key))
+
+	// a slow write and a slow scan are cut off at the command timeout.
+	slow.Store(true)
+	begin := time.Now()
+	require.ErrorIs(t, drv.Tick(context.Background()), context.DeadlineExceeded)
+	_, err := drv.GetNodes(context.Background())
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(begin), time.Second)
+	slow.Store(false)
+
+	// the TTL of the node key is still the timeout.
+	rds.FastForward(10 * time.Second)
+	require.Nil(t, drv.Tick(context.Background()))
+	require.Equal(t, 30*time.Second, rds.TTL(key))
+
+	require.ErrorIs(t, drv.WithOption(redisdriver.WithCommandTimeout(-time.Second)), redisdriver.ErrInvalidOption)
+}
+
+func TestRedisDriver_CommandTimeoutDefault(t *testing.T) {
+	rds := miniredis.RunT(t)
+	var slow atomic.Bool
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{
+		process: func(ctx context.Context, cmd redis.Cmder, next redis.ProcessHook) error {
+			if slow.Load() && cmd.Name() == "scan" {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(5 * time.Second):
+				}
+			}
+			return next(ctx, cmd)
+		},
+	})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithClock(newTestClock()))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+
+	// without the option a scan is bounded by the timeout, like the writes.
+	slow.Store(true)
+	begin := time.Now()
+	_, err := drv.GetNodes(context.Background())
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(begin), 2*time.Second)
+}
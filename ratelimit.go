@@ -0,0 +1,87 @@
+package redisdriver
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// scanLimiter spaces the discovery scans by interval, a token bucket
+// with a single token, see ScanRateLimitOption.
+type scanLimiter struct {
+	interval time.Duration
+
+	mu sync.Mutex
+	// next is the earliest start of the next scan.
+	next time.Time
+	// free are the slots before next given back by cancelled waiters,
+	// in order.
+	free []time.Time
+}
+
+func newScanLimiter(rps float64) *scanLimiter {
+	return &scanLimiter{interval: time.Duration(float64(time.Second) / rps)}
+}
+
+// reserve takes the next free slot and returns it.
+func (l *scanLimiter) reserve(now time.Time) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// a slot given back in the past is too close to the ones after it.
+	for len(l.free) > 0 && l.free[0].Before(now) {
+		l.free = l.free[1:]
+	}
+	if len(l.free) > 0 {
+		slot := l.free[0]
+		l.free = l.free[1:]
+		return slot
+	}
+	if l.next.Before(now) {
+		l.next = now
+	}
+	slot := l.next
+	l.next = l.next.Add(l.interval)
+	return slot
+}
+
+// cancel gives back the slot of a waiter that gave up, so it does not
+// delay the callers after it.
+func (l *scanLimiter) cancel(slot time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !slot.Add(l.interval).Equal(l.next) {
+		// a later caller holds the slot after it, keep it for the next one.
+		i := sort.Search(len(l.free), func(i int) bool { return !l.free[i].Before(slot) })
+		l.free = append(l.free, time.Time{})
+		copy(l.free[i+1:], l.free[i:])
+		l.free[i] = slot
+		return
+	}
+	l.next = slot
+	// the slots given back right before it are free again, too.
+	for n := len(l.free); n > 0 && l.free[n-1].Add(l.interval).Equal(l.next); n-- {
+		l.next = l.free[n-1]
+		l.free = l.free[:n-1]
+	}
+}
+
+// waitScan blocks until the rate limit of WithScanRateLimit lets the next
+// scan run on the clock of the driver, or returns the error of ctx.
+func (rd *RedisDriver) waitScan(ctx context.Context) error {
+	if rd.scanLimiter == nil {
+		return nil
+	}
+	now := rd.clock.Now()
+	slot := rd.scanLimiter.reserve(now)
+	wait := slot.Sub(now)
+	if wait <= 0 {
+		return nil
+	}
+	rd.stats.scansThrottled.Add(1)
+	if err := rd.sleep(ctx, wait); err != nil {
+		rd.scanLimiter.cancel(slot)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package redisdriver_test
+
+import (
+	"context"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dcron-contrib/commons"
+	"github.com/dcron-contrib/commons/dlog"
+	"github.com/dcron-contrib/redisdriver"
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisDriver_ScanRateLimit(t *testing.T) {
+	rds := miniredis.RunT(t)
+	var mu sync.Mutex
+	var scans []time.Time
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{
+		process: func(ctx context.Context, cmd redis.Cmder, next redis.ProcessHook) error {
+			if cmd.Name() == "scan" {
+				mu.Lock()
+				scans = append(scans, time.Now())
+				mu.Unlock()
+			}
+			return next(ctx, cmd)
+		},
+	})
+	drv.Init(t.Name(),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithScanRateLimit(20))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+
+	// a tight loop of callers is paced to one scan per 50ms.
+	begin := time.Now()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			nodes, err := drv.GetNodes(context.Background())
+			assert.Nil(t, err)
+			assert.Equal(t, []string{drv.NodeID()}, nodes)
+		}()
+	}
+	wg.Wait()
+	require.GreaterOrEqual(t, time.Since(begin), 200*time.Millisecond)
+	mu.Lock()
+	require.Len(t, scans, 5)
+	for i := 1; i < len(scans); i++ {
+		require.GreaterOrEqual(t, scans[i].Sub(scans[0]), time.Duration(i)*45*time.Millisecond)
+	}
+	mu.Unlock()
+	require.GreaterOrEqual(t, drv.Stats().ScansThrottled, int64(4))
+
+	// a waiting call returns once its context is done.
+	_, err := drv.GetNodeCount(context.Background())
+	require.Nil(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = drv.GetNodes(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestRedisDriver_ScanRateLimitCancel(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := testFuncNewRedisDriver(rds.Addr())
+	drv.Init(t.Name(),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithScanRateLimit(5))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+
+	begin := time.Now()
+	_, err := drv.GetNodes(context.Background())
+	require.Nil(t, err)
+	// waiters that give up hand their slots back.
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			_, err := drv.GetNodes(ctx)
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+		}()
+	}
+	wg.Wait()
+	_, err = drv.GetNodes(context.Background())
+	require.Nil(t, err)
+	elapsed := time.Since(begin)
+	require.GreaterOrEqual(t, elapsed, 190*time.Millisecond)
+	require.Less(t, elapsed, 400*time.Millisecond)
+}
+
+func TestRedisDriver_ScanRateLimitOption(t *testing.T) {
+	drv := redisdriver.NewDriver(redis.NewClient(&redis.Options{}))
+	for _, rps := range []float64{-1, math.NaN(), math.Inf(1)} {
+		require.ErrorIs(t, drv.WithOption(redisdriver.WithScanRateLimit(rps)), redisdriver.ErrInvalidOption)
+	}
+	require.Nil(t, drv.WithOption(redisdriver.WithScanRateLimit(0)))
+}
+
+func TestRedisDriver_ScanRateLimitClock(t *testing.T) {
+	rds := miniredis.RunT(t)
+	clock := newTestClock()
+	drv := testFuncNewRedisDriver(rds.Addr())
+	drv.Init(t.Name(),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithClock(clock),
+		redisdriver.WithScanRateLimit(0.1))
+	_, err := drv.GetNodes(context.Background())
+	require.Nil(t, err)
+
+	// the next scan waits for the clock, not for the 10s of the limit.
+	done := make(chan error, 1)
+	go func() {
+		_, err := drv.GetNodes(context.Background())
+		done <- err
+	}()
+	ticker := clock.nextTicker(t)
+	select {
+	case <-done:
+		t.Fatal("scan runs before the clock ticks")
+	case <-time.After(50 * time.Millisecond):
+	}
+	ticker.tick()
+	select {
+	case err := <-done:
+		require.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("scan does not run once the clock ticked")
+	}
+}
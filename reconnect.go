@@ -0,0 +1,33 @@
+package redisdriver
+
+import (
+	"context"
+	"net"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// reconnectHook signals every connection the client dials, the signals
+// are coalesced while the heartbeat does not read them.
+type reconnectHook struct {
+	reconnected chan<- struct{}
+}
+
+func (h reconnectHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := next(ctx, network, addr)
+		if err == nil {
+			select {
+			case h.reconnected <- struct{}{}:
+			default:
+			}
+		}
+		return conn, err
+	}
+}
+
+func (h reconnectHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook { return next }
+
+func (h reconnectHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
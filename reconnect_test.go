@@ -0,0 +1,85 @@
+package redisdriver_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dcron-contrib/commons"
+	"github.com/dcron-contrib/commons/dlog"
+	"github.com/dcron-contrib/redisdriver"
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisDriver_ReconnectRegister(t *testing.T) {
+	rds := miniredis.RunT(t)
+	clock := newTestClock()
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithMaxRetries(0),
+		redisdriver.WithReconnectRegister(),
+		redisdriver.WithClock(clock))
+	require.Nil(t, drv.Start(context.Background()))
+	ticker := clock.nextTicker(t)
+	key := testFuncNodeKey(t.Name(), drv.NodeID())
+
+	// the connection drops and the heartbeat fails.
+	rds.Close()
+	ticker.tick()
+	require.Eventually(t, func() bool {
+		return drv.Stats().ConsecutiveFailures == 1
+	}, 5*time.Second, time.Millisecond)
+
+	// redis recovers without the key, e.g. it expired meanwhile.
+	require.Nil(t, rds.Restart())
+	rds.Del(key)
+	// any use of the client reconnects, the node registers without a tick.
+	_, _ = drv.GetNodes(context.Background())
+	require.Eventually(t, func() bool { return rds.Exists(key) }, time.Second, time.Millisecond)
+	require.Equal(t, int64(0), drv.Stats().ConsecutiveFailures)
+
+	// while the heartbeat succeeds a reconnect does not register.
+	rds.Close()
+	require.Nil(t, rds.Restart())
+	rds.Del(key)
+	_, _ = drv.GetNodes(context.Background())
+	time.Sleep(50 * time.Millisecond)
+	require.False(t, rds.Exists(key))
+	testFuncStop(t, rds, drv)
+}
+
+func TestRedisDriver_ReconnectRegisterCluster(t *testing.T) {
+	rds := miniredis.RunT(t)
+	client := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{rds.Addr()}})
+	defer client.Close()
+	// the shard client exists before the driver, Start hooks it.
+	require.Nil(t, client.Ping(context.Background()).Err())
+	clock := newTestClock()
+	drv := redisdriver.NewDriver(client)
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithMaxRetries(0),
+		redisdriver.WithReconnectRegister(),
+		redisdriver.WithClock(clock))
+	require.Nil(t, drv.Start(context.Background()))
+	ticker := clock.nextTicker(t)
+	key := testFuncNodeKey(t.Name(), drv.NodeID())
+
+	rds.Close()
+	ticker.tick()
+	require.Eventually(t, func() bool {
+		return drv.Stats().ConsecutiveFailures == 1
+	}, 5*time.Second, time.Millisecond)
+
+	require.Nil(t, rds.Restart())
+	rds.Del(key)
+	// the shard client dials again, the node registers without a tick.
+	_, _ = drv.GetNodes(context.Background())
+	require.Eventually(t, func() bool { return rds.Exists(key) }, time.Second, time.Millisecond)
+	testFuncStop(t, rds, drv)
+}
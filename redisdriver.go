@@ -5,52 +5,292 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dcron-contrib/commons"
 	"github.com/dcron-contrib/commons/dlog"
+	"github.com/google/uuid"
 	redis "github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
-	redisDefaultTimeout = 5 * time.Second
+	redisDefaultTimeout      = 5 * time.Second
+	redisDefaultScanCount    = 100
+	redisDefaultMaxRetries   = 3
+	redisDefaultRetryBackoff = 100 * time.Millisecond
+	// redisDefaultMinTimeout is the smallest accepted timeout. Below it the
+	// heartbeat at half the timeout plus the network latency can miss the
+	// TTL, and the node flaps.
+	redisDefaultMinTimeout = time.Second
+	// generationTTL keeps the generation counter of a node for that long
+	// after its latest heartbeat, the heartbeat extends it once half of
+	// it passed. A node that comes back later starts at 1 again.
+	generationTTL = 24 * time.Hour
+	// maxHeartbeatBackoff bounds the heartbeat interval while redis is down,
+	// a short timeout bounds it further, see heartbeatBackoffLimit.
+	maxHeartbeatBackoff = 30 * time.Second
+	// minReconnectRegisterInterval spaces the registrations on reconnect
+	// and the checks on cluster topology changes, so a flapping connection
+	// or a resharding does not flood redis.
+	minReconnectRegisterInterval = time.Second
+	// closeTimeout bounds the Stop of Close.
+	closeTimeout = 10 * time.Second
 )
 
+// scanner is implemented by the standalone and the cluster clients.
+type scanner interface {
+	Scan(ctx context.Context, cursor uint64, match string, count int64) *redis.ScanCmd
+}
+
 type RedisDriver struct {
-	c           redis.UniversalClient
+	c redis.UniversalClient
+	// replica serves the discovery reads when it is set,
+	// the writes always go to c.
+	replica     redis.UniversalClient
 	serviceName string
 	nodeID      string
-	timeout     time.Duration
-	logger      dlog.Logger
-	started     bool
+	// configuredNodeID replaces the generated node id in Init.
+	configuredNodeID string
+	// configErr is the first invalid setting found by NewDriver or Init.
+	configErr error
+	timeout   time.Duration
+	logger    *syncLogger
+	started   bool
+
+	// heartbeatInterval is the refresh period of the node key,
+	// zero means timeout/refreshRatio.
+	heartbeatInterval time.Duration
+	// refreshRatio divides the timeout into the default heartbeat
+	// interval, zero means 2.
+	refreshRatio float64
+	// heartbeatTimeout bounds every heartbeat write, zero means timeout/2.
+	heartbeatTimeout time.Duration
+	// commandTimeout bounds the redis calls of the driver, zero means
+	// timeout, which stays the TTL of the node key.
+	commandTimeout time.Duration
+	// minTimeout is the smallest accepted timeout.
+	minTimeout time.Duration
+	// cfgMu guards timeout, minTimeout, heartbeatInterval, refreshRatio,
+	// heartbeatTimeout and commandTimeout, they are read by the background
+	// goroutines.
+	cfgMu sync.RWMutex
+	// scanCount is the COUNT hint of each SCAN call.
+	scanCount int64
+	// a failed heartbeat is retried up to maxRetries times,
+	// waiting retryBackoff doubled on every attempt.
+	maxRetries   int
+	retryBackoff time.Duration
+	// heartbeatJitter randomizes every heartbeat interval
+	// by up to this fraction of the interval.
+	heartbeatJitter float64
+	// stopGracePeriod keeps the node key alive that long after Stop,
+	// zero deletes it.
+	stopGracePeriod time.Duration
+	// clockSkewAlert watches the clock of redis when it is set.
+	clockSkewAlert *clockSkewAlert
+	// initialRegisterDelay defers the first registration after Start.
+	initialRegisterDelay time.Duration
+	// registeredAt is the time of the latest Start, or of the delayed
+	// registration after it.
+	registeredAt time.Time
+	// lastHeartbeatOK is the time of the latest successful write of the
+	// node key, guarded by statusMu.
+	statusMu        sync.Mutex
+	lastHeartbeatOK time.Time
+	// lastKnownNodes is the latest successful GetNodes result,
+	// kept with lastKnownFallback and guarded by statusMu.
+	lastKnownFallback bool
+	lastKnownNodes    []string
+	// lenientScan keeps the keys found before a scan error.
+	lenientScan bool
+	// hashStorage keeps all nodes in the fields of one hash.
+	hashStorage bool
+	// staleCleanup is the max age of the node values GetNodes keeps,
+	// zero disables the cleanup.
+	staleCleanup time.Duration
+	// labels are the user defined metadata of this node.
+	labels map[string]string
+	// serializer encodes the node values.
+	serializer Serializer
+	// compressionThreshold is the size from which the node values are
+	// compressed, zero disables the compression.
+	compressionThreshold int
+	// advertiseAddr is the address other nodes reach this node at.
+	advertiseAddr string
+	// weight is the capacity of this node, it changes at runtime.
+	weight atomic.Int64
+	// state is the NodeState of this node, it changes at runtime.
+	state atomic.Int32
+
+	keyspaceNotifications bool
+	// membershipEvents publishes the join and leave of this node.
+	membershipEvents bool
+	// registerHook adds commands to every write of the node key.
+	registerHook RegisterHook
+	// reconnected receives a value when the client dialed a connection,
+	// it is set with WithReconnectRegister.
+	reconnected chan struct{}
+	// clusterWatch forwards the events of the shards of a cluster client.
+	clusterWatch *clusterWatch
+	// topologyChanged receives a value when a shard of a cluster client
+	// redirected a command, it is set with WithTopologyWatch.
+	topologyChanged chan struct{}
+	// keyPrefix is prepended to every key of the driver.
+	keyPrefix string
+	// clusterMode hash tags the service part of the keys.
+	clusterMode bool
+	// keyBuilder replaces the layout of the node keys.
+	keyBuilder KeyBuilder
+	// prefix and match cache servicePrefix and nodeMatch, they are
+	// computed by Init and by the options changing the key layout.
+	prefix string
+	match  string
+	// nodeCache keeps the result of GetNodes when it is set.
+	nodeCache *nodeCache
+	// scanLimiter paces the discovery scans, nil without a limit.
+	scanLimiter *scanLimiter
+	// consistentSnapshot makes GetNodes and GetNodeCount answer from the
+	// latest snapshot of Snapshot, guarded by snapshotMu.
+	consistentSnapshot bool
+	snapshotMu         sync.Mutex
+	snapshot           *NodeSnapshot
+	metrics            MetricsCollector
+	stats              driverStats
+	tracer             trace.Tracer
+	clock              Clock
+
+	// onNodeJoin and onNodeLeave are called on the membership changes.
+	onNodeJoin  func(nodeID string)
+	onNodeLeave func(nodeID string)
+
+	leader           bool
+	leaderCancel     context.CancelFunc
+	onLeadershipLost func()
 
 	// this context is used to define
 	// the lifetime of this driver.
 	runtimeCtx    context.Context
 	runtimeCancel context.CancelFunc
+	registerMu    sync.Mutex
+	// drained and draining stop the registration of the node,
+	// guarded by registerMu.
+	drained  bool
+	draining bool
+	// registered tells whether the latest write of the node key succeeded,
+	// a heartbeat finding no key after it means the registration was lost.
+	// It is guarded by registerMu.
+	registered bool
+	// joined tells whether the node key was written since the latest
+	// Start, Stop only publishes the leave of a node that joined. It is
+	// guarded by registerMu.
+	joined             bool
+	onRegistrationLost func()
+	// refreshOnly refreshes the node key by SET XX, see RefreshOnlyOption.
+	refreshOnly bool
+	// services are the other services the node is registered in by
+	// RegisterService, guarded by servicesMu.
+	servicesMu sync.Mutex
+	services   map[string]struct{}
+	// generation is incremented on every Start, guarded by registerMu.
+	generation int64
+	// generationExtended is the latest time the expiry of the generation
+	// counter was set, guarded by registerMu.
+	generationExtended time.Time
+	// instance tells this driver apart from other processes
+	// that use the same node ID.
+	instance string
+	// externalHeartbeat leaves the refresh of the node key to Heartbeat,
+	// releaseOnStop then tells Stop to release the key itself.
+	externalHeartbeat bool
+	releaseOnStop     bool
+	// heartbeatStop is closed by Stop before it cancels the runtime
+	// context, the heartbeat then exits without releasing the key.
+	heartbeatStop chan struct{}
+	// heartbeatExit is closed when the heartbeat goroutine of the latest
+	// Start has exited, so Restart does not overlap with it.
+	heartbeatExit chan struct{}
 
 	sync.Mutex
 }
 
-func NewDriver(redisClient redis.UniversalClient) *RedisDriver {
+// NewDriver creates a driver on top of redisClient, opts are applied
+// like in WithOption before anything else runs.
+// It panics if redisClient is nil.
+//
+// On a *redis.ClusterClient the driver hooks the shard clients, a MOVED
+// or ASK redirect makes the heartbeat check that the node key still
+// exists and write it again if it does not. Other clients never see
+// redirects. Pass a cluster client before it connected to its shards,
+// the shards it already knows are not hooked.
+func NewDriver(redisClient redis.UniversalClient, opts ...commons.Option) *RedisDriver {
+	if redisClient == nil {
+		panic(fmt.Sprintf("redisdriver: %v", ErrNilClient))
+	}
 	rd := &RedisDriver{
 		c: redisClient,
-		logger: &dlog.StdLogger{
+		logger: newSyncLogger(&dlog.StdLogger{
 			Log: log.Default(),
-		},
-		timeout: redisDefaultTimeout,
+		}),
+		timeout:      redisDefaultTimeout,
+		minTimeout:   redisDefaultMinTimeout,
+		scanCount:    redisDefaultScanCount,
+		maxRetries:   redisDefaultMaxRetries,
+		retryBackoff: redisDefaultRetryBackoff,
+		metrics:      nopMetrics{},
+		tracer:       defaultTracer(),
+		clock:        realClock{},
+		instance:     uuid.NewString(),
+		serializer:   JSONSerializer{},
 	}
 	rd.started = false
+	if cluster, ok := redisClient.(*redis.ClusterClient); ok {
+		rd.clusterWatch = clusterWatchOf(cluster)
+	}
+	for _, opt := range opts {
+		if err := rd.WithOption(opt); err != nil {
+			rd.log("init").Errorf("apply option error=%v", err)
+			rd.setConfigErr(err)
+		}
+	}
 	return rd
 }
 
 func (rd *RedisDriver) Init(serviceName string, opts ...commons.Option) {
 	rd.serviceName = serviceName
-	rd.nodeID = commons.GetNodeId(rd.serviceName)
+	if rd.configuredNodeID != "" {
+		rd.nodeID = rd.configuredNodeID
+	} else {
+		// the node id is the unique part of commons.GetNodeId,
+		// the service prefix only belongs to the redis key.
+		rd.nodeID = strings.TrimPrefix(commons.GetNodeId(rd.serviceName), commons.GetKeyPre(rd.serviceName))
+	}
 
+	if serviceName == "" {
+		rd.log("init").Errorf("init driver error=%v", ErrEmptyServiceName)
+		rd.setConfigErr(ErrEmptyServiceName)
+	}
+
+	rd.updateKeyLayout()
 	for _, opt := range opts {
-		rd.WithOption(opt)
+		if err := rd.WithOption(opt); err != nil {
+			rd.log("init").Errorf("apply option error=%v", err)
+			rd.setConfigErr(err)
+		}
+	}
+	if err := checkMatchPattern(rd.nodeMatch()); err != nil {
+		rd.log("init").Errorf("init driver error=%v", err)
+		rd.setConfigErr(err)
+	}
+	if err := rd.checkServiceKeys(rd.nodeMatch()); err != nil {
+		rd.log("init").Errorf("init driver error=%v", err)
+		rd.setConfigErr(err)
 	}
 }
 
@@ -58,88 +298,1662 @@ func (rd *RedisDriver) NodeID() string {
 	return rd.nodeID
 }
 
+// Client returns the redis client the driver was built with.
+// The client is shared with the driver, so changing its state
+// while the driver is running is the caller's responsibility.
+func (rd *RedisDriver) Client() redis.UniversalClient {
+	return rd.c
+}
+
 func (rd *RedisDriver) Start(ctx context.Context) (err error) {
 	rd.Lock()
 	defer rd.Unlock()
 	if rd.started {
-		err = errors.New("this driver is started")
+		err = ErrAlreadyStarted
 		return
 	}
-	rd.runtimeCtx, rd.runtimeCancel = context.WithCancel(context.TODO())
-	rd.started = true
-	// register
-	err = rd.registerServiceNode()
+	if ctx == nil {
+		err = ErrNilContext
+		return
+	}
+	if rd.configErr != nil {
+		err = rd.configErr
+		return
+	}
+	// the size limit applies to the value of all options, e.g. the labels
+	// compressed by WithMetadataCompression.
+	if _, err = rd.nodeValue(); err != nil {
+		err = fmt.Errorf("%w: %v", ErrInvalidOption, err)
+		return
+	}
+	rd.runtimeCtx, rd.runtimeCancel = context.WithCancel(ctx)
+	rd.registeredAt = rd.clock.Now()
+	generation, err := rd.nextGeneration(rd.runtimeCtx)
 	if err != nil {
-		rd.logger.Errorf("register service error=%v", err)
+		rd.log("start").Errorf("increment node generation error=%v", err)
+		err = fmt.Errorf("increment node generation: %w", err)
+		rd.runtimeCancel()
 		return
 	}
-	// heartbeat timer
-	go rd.heartBeat()
+	rd.registerMu.Lock()
+	rd.drained = false
+	rd.draining = false
+	rd.registered = false
+	rd.joined = false
+	rd.generation = generation
+	rd.generationExtended = rd.clock.Now()
+	rd.registerMu.Unlock()
+	// register, unless the heartbeat does it after the initial delay
+	delay := rd.initialRegisterDelay
+	if rd.externalHeartbeat {
+		delay = 0
+	}
+	if delay == 0 {
+		_, err = rd.writeServiceNode(rd.runtimeCtx, true)
+		if err != nil {
+			rd.log("start").Errorf("register service error=%v", err)
+			err = fmt.Errorf("register service node: %w", err)
+			rd.runtimeCancel()
+			return
+		}
+	}
+	// the driver is only started once it is registered, so a failed
+	// Start can be retried.
+	rd.started = true
+	rd.watchShards(rd.runtimeCtx)
+	if delay == 0 {
+		rd.publishMembership(rd.runtimeCtx, NodeJoin)
+	}
+	if rd.externalHeartbeat {
+		// the caller refreshes the key, Stop releases it.
+		rd.releaseOnStop = true
+	} else {
+		// heartbeat timer
+		rd.heartbeatStop = make(chan struct{})
+		rd.heartbeatExit = make(chan struct{})
+		go rd.heartBeat(rd.runtimeCtx, delay, rd.heartbeatStop, rd.heartbeatExit)
+	}
+	if rd.clockSkewAlert != nil {
+		go rd.watchClockSkew(rd.runtimeCtx)
+	}
+	if rd.onNodeJoin != nil || rd.onNodeLeave != nil {
+		go rd.notifyMembership(rd.runtimeCtx)
+	}
 	return
 }
 
-func (rd *RedisDriver) Stop(ctx context.Context) (err error) {
+// Restart starts the driver again after Stop, with a new runtime context,
+// registration and heartbeat. Unlike Start, it first waits until the
+// heartbeat of the previous run has exited, e.g. after a Stop that timed
+// out, so a late write or release of it cannot touch the new
+// registration. It returns ErrAlreadyStarted while the driver is running.
+func (rd *RedisDriver) Restart(ctx context.Context) error {
+	rd.Lock()
+	running := rd.started
+	exit := rd.heartbeatExit
+	rd.Unlock()
+	if running {
+		return ErrAlreadyStarted
+	}
+	if exit != nil {
+		select {
+		case <-exit:
+		case <-ctx.Done():
+			return fmt.Errorf("wait for previous heartbeat: %w", ctx.Err())
+		}
+	}
+	return rd.Start(ctx)
+}
+
+// IsStarted reports whether Start succeeded and Stop is not called yet.
+func (rd *RedisDriver) IsStarted() bool {
+	rd.Lock()
+	defer rd.Unlock()
+	return rd.started
+}
+
+// Context returns the runtime context of the driver, it is done once the
+// driver stops, so goroutines of extensions can derive their context from
+// it. After Stop it is the done context of the latest run, up to the next
+// Start. It is never nil, a driver that was never started returns
+// context.Background().
+func (rd *RedisDriver) Context() context.Context {
 	rd.Lock()
 	defer rd.Unlock()
+	if rd.runtimeCtx == nil {
+		return context.Background()
+	}
+	return rd.runtimeCtx
+}
+
+// Tick registers the node once right now, e.g. after a known reconnect,
+// instead of waiting for the next heartbeat. It does not reset the
+// heartbeat schedule.
+func (rd *RedisDriver) Tick(ctx context.Context) error {
+	if !rd.IsStarted() {
+		return ErrNotStarted
+	}
+	if err := rd.registerServiceNode(ctx); err != nil {
+		return fmt.Errorf("register service node: %w", err)
+	}
+	return nil
+}
+
+// Heartbeat registers the node once like a tick of the internal heartbeat,
+// it is meant for WithExternalHeartbeat. The caller must then call it
+// often enough to refresh the node key within the timeout, otherwise the
+// key expires and the node is gone for the other nodes. It is not retried.
+func (rd *RedisDriver) Heartbeat(ctx context.Context) error {
+	if !rd.IsStarted() {
+		return ErrNotStarted
+	}
+	err := rd.registerServiceNode(ctx)
+	rd.recordHeartbeat(err)
+	if err != nil {
+		return fmt.Errorf("register service node: %w", err)
+	}
+	return nil
+}
+
+// Unregister removes the node key right away, so other nodes stop seeing
+// this node while the driver keeps running. The heartbeat does not
+// register the node again until Register is called.
+func (rd *RedisDriver) Unregister(ctx context.Context) error {
+	if !rd.IsStarted() {
+		return ErrNotStarted
+	}
+	rd.registerMu.Lock()
+	defer rd.registerMu.Unlock()
+	rd.drained = true
+	rd.registered = false
+	if err := rd.deleteNodeKey(ctx); err != nil {
+		return fmt.Errorf("unregister service node: %w", err)
+	}
+	return nil
+}
+
+// Register adds the node back after Unregister and resumes the heartbeat.
+func (rd *RedisDriver) Register(ctx context.Context) error {
+	if !rd.IsStarted() {
+		return ErrNotStarted
+	}
+	rd.registerMu.Lock()
+	rd.drained = false
+	rd.registerMu.Unlock()
+	if err := rd.registerServiceNode(ctx); err != nil {
+		return fmt.Errorf("register service node: %w", err)
+	}
+	return nil
+}
+
+// Drain stops refreshing the node key, so it expires after the timeout
+// while the driver keeps running. Drain returns once no heartbeat write is
+// in flight, such a write is bounded by the command timeout and not by
+// ctx. If ctx is done before the node is drained, Drain returns its error
+// and the node stays registered. Undrain resumes the heartbeat.
+func (rd *RedisDriver) Drain(ctx context.Context) error {
+	if !rd.IsStarted() {
+		return ErrNotStarted
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	rd.registerMu.Lock()
+	defer rd.registerMu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	rd.draining = true
+	rd.registered = false
+	return nil
+}
+
+// Undrain resumes the heartbeat after Drain, the node key is written
+// again on the next tick.
+func (rd *RedisDriver) Undrain() {
+	rd.registerMu.Lock()
+	defer rd.registerMu.Unlock()
+	rd.draining = false
+}
+
+// IsDraining reports whether Drain was called without a following Undrain.
+func (rd *RedisDriver) IsDraining() bool {
+	rd.registerMu.Lock()
+	defer rd.registerMu.Unlock()
+	return rd.draining
+}
+
+// Stop tells the heartbeat to exit, waits until it did and then deletes
+// the node key itself, so the node is deregistered when Stop returns nil.
+// The wait and the delete are bounded by ctx, if it is done first the
+// error of ctx is returned wrapped and the heartbeat still exits, but the
+// node key may be left to expire. The deregister error is returned.
+func (rd *RedisDriver) Stop(ctx context.Context) (err error) {
+	rd.Lock()
+	if !rd.started || rd.runtimeCancel == nil {
+		rd.Unlock()
+		err = ErrNotStarted
+		return
+	}
+	stop, exit := rd.heartbeatStop, rd.heartbeatExit
+	rd.heartbeatStop = nil
+	if stop != nil {
+		close(stop)
+	}
 	rd.runtimeCancel()
 	rd.started = false
+	rd.unwatchShards()
+	release := rd.releaseOnStop
+	rd.releaseOnStop = false
+	rd.Unlock()
+	if rd.nodeCache != nil {
+		// the runtime context ends a background refresh of the cache.
+		rd.nodeCache.refreshes.Wait()
+	}
+
+	if stop == nil && !release {
+		// the registration in Start failed, there is no heartbeat.
+		return
+	}
+	if stop != nil {
+		// a heartbeat write in flight would register the node again.
+		select {
+		case <-exit:
+		case <-ctx.Done():
+			err = fmt.Errorf("wait for heartbeat exit: %w", ctx.Err())
+			return
+		}
+	}
+	releaseCtx, cancel := context.WithTimeout(ctx, rd.getCommandTimeout())
+	defer cancel()
+	if err = rd.releaseNodeKey(releaseCtx); err != nil {
+		rd.log("stop").Errorf("unregister service node error %+v", err)
+		err = fmt.Errorf("unregister service node: %w", err)
+	}
 	return
 }
 
+// Close stops the driver like Stop, bounded by closeTimeout, so the
+// driver is an io.Closer. Closing a driver that is not started, never or
+// no more, returns nil. The redis client is not closed, it belongs to
+// the caller.
+func (rd *RedisDriver) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	if err := rd.Stop(ctx); err != nil && !errors.Is(err, ErrNotStarted) {
+		return err
+	}
+	return nil
+}
+
+// GetNodes returns the ids of all alive nodes of this service,
+// in the same form as NodeID. The ids are sorted, so that callers
+// partitioning work by the list get the same order on every call.
 func (rd *RedisDriver) GetNodes(ctx context.Context) (nodes []string, err error) {
-	mathStr := fmt.Sprintf("%s*", commons.GetKeyPre(rd.serviceName))
-	return rd.scan(ctx, mathStr)
+	if snapshot := rd.currentSnapshot(); snapshot != nil {
+		return snapshot.IDs(), nil
+	}
+	if rd.nodeCache != nil {
+		return rd.cachedNodes(ctx)
+	}
+	return rd.scanNodes(ctx)
+}
+
+// GetNodesPage returns the node ids found by one SCAN call from cursor on,
+// and the cursor of the next page, zero once the scan is complete. The
+// first page starts at cursor zero. count is the COUNT hint of SCAN, zero
+// means the scan count of the driver, so a page may hold more or fewer
+// ids than count and even none before the end. The membership may change
+// between two pages, a node that joins or leaves meanwhile may be missed
+// and a node may be returned on more than one page. GetNodes returns all
+// pages at once.
+//
+// On a cluster client it needs WithClusterMode, so that all node keys are
+// on the same master, otherwise it returns ErrPagesUnsupported. The same
+// holds for NodesIterator.
+func (rd *RedisDriver) GetNodesPage(ctx context.Context, cursor uint64, count int64) (ids []string, nextCursor uint64, err error) {
+	if count < 0 {
+		return nil, 0, fmt.Errorf("%w: page count %d must not be negative", ErrInvalidOption, count)
+	}
+	if count == 0 {
+		count = rd.scanCount
+	}
+	client, err := rd.pageClient(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	ctx, span := rd.startSpan(ctx, "redisdriver.scan_page", "SCAN")
+	defer func() { endSpan(span, err) }()
+	keys, nextCursor, err := client.Scan(ctx, cursor, rd.nodeMatch(), count).Result()
+	if err != nil {
+		return nil, 0, fmt.Errorf("scan node keys: %w", err)
+	}
+	ids = make([]string, 0, len(keys))
+	for _, key := range keys {
+		if nodeID, ok := rd.scannedNodeID(key); ok {
+			ids = append(ids, nodeID)
+		}
+	}
+	return ids, nextCursor, nil
+}
+
+// pageClient returns the client that holds all node keys, a cluster
+// only has one with WithClusterMode.
+func (rd *RedisDriver) pageClient(ctx context.Context) (scanner, error) {
+	if rd.hashStorage {
+		return nil, ErrPagesUnsupported
+	}
+	if err := checkMatchPattern(rd.nodeMatch()); err != nil {
+		return nil, err
+	}
+	client := rd.readClient()
+	cluster, ok := client.(*redis.ClusterClient)
+	if !ok {
+		return client, nil
+	}
+	if !rd.clusterMode || rd.keyBuilder != nil {
+		return nil, ErrPagesUnsupported
+	}
+	master, err := cluster.MasterForKey(ctx, rd.nodeKey(rd.nodeID))
+	if err != nil {
+		return nil, fmt.Errorf("get node keys master: %w", err)
+	}
+	return master, nil
+}
+
+func (rd *RedisDriver) scanNodes(ctx context.Context) (nodes []string, err error) {
+	if rd.hashStorage {
+		return rd.hashNodeIDs(ctx)
+	}
+	keys, err := rd.GetRawNodeKeys(ctx)
+	partial := errors.Is(err, ErrPartialScan)
+	if err != nil && !partial {
+		if rd.lastKnownFallback {
+			rd.statusMu.Lock()
+			lastKnown := rd.lastKnownNodes
+			rd.statusMu.Unlock()
+			if lastKnown != nil {
+				return copyNodes(lastKnown), &staleNodesError{err: err}
+			}
+		}
+		return nil, err
+	}
+	if rd.staleCleanup > 0 {
+		keys = rd.removeStaleKeys(ctx, keys)
+	}
+	nodes = make([]string, 0, len(keys))
+	for _, key := range keys {
+		nodeID, ok := rd.nodeIDFromKey(key)
+		if !ok {
+			continue
+		}
+		nodes = append(nodes, nodeID)
+	}
+	sort.Strings(nodes)
+	if partial {
+		return nodes, err
+	}
+	rd.keepScannedNodes(nodes)
+	return
+}
+
+// keepScannedNodes records the nodes of a complete scan, the node count
+// metric and the list of WithLastKnownFallback.
+func (rd *RedisDriver) keepScannedNodes(nodes []string) {
+	rd.metrics.SetNodeCount(len(nodes))
+	if rd.lastKnownFallback {
+		rd.statusMu.Lock()
+		rd.lastKnownNodes = copyNodes(nodes)
+		rd.statusMu.Unlock()
+	}
+}
+
+// GetNodeCount returns the number of alive nodes of this service,
+// without building the list of node IDs. The node keys are counted by a
+// SCAN, a best effort count since keys may expire or be added meanwhile,
+// and a key that SCAN returns twice is counted twice.
+// With WithHashStorage a script counts the recent heartbeats in one call.
+func (rd *RedisDriver) GetNodeCount(ctx context.Context) (count int, err error) {
+	if snapshot := rd.currentSnapshot(); snapshot != nil {
+		return snapshot.Count(), nil
+	}
+	if rd.hashStorage {
+		return rd.hashNodeCount(ctx)
+	}
+	matchStr := rd.nodeMatch()
+	err = rd.scanEach(ctx, matchStr, func(key string) {
+		if _, ok := rd.scannedNodeID(key); ok {
+			count++
+		}
+	})
+	if errors.Is(err, ErrPartialScan) {
+		return count, err
+	}
+	if err != nil {
+		return 0, err
+	}
+	rd.metrics.SetNodeCount(count)
+	return count, nil
+}
+
+// GetRawNodeKeys returns the sorted redis keys of all alive nodes of this service.
+func (rd *RedisDriver) GetRawNodeKeys(ctx context.Context) (keys []string, err error) {
+	scanned, err := rd.scan(ctx, rd.nodeMatch())
+	if err != nil && !errors.Is(err, ErrPartialScan) {
+		return nil, err
+	}
+	keys = make([]string, 0, len(scanned))
+	for _, key := range scanned {
+		if _, ok := rd.scannedNodeID(key); ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, err
 }
 
 // private function
 
-func (rd *RedisDriver) heartBeat() {
-	tick := time.NewTicker(rd.timeout / 2)
+// setConfigErr keeps the first configuration error, it is returned by Start
+// since Init can not report it.
+func (rd *RedisDriver) setConfigErr(err error) {
+	if rd.configErr == nil {
+		rd.configErr = err
+	}
+}
+
+// heartBeat refreshes the node key until ctx, the runtime context of the
+// Start that launched it, is done. It never reads rd.runtimeCtx, which a
+// later Start replaces while this goroutine may still run. A positive
+// delay makes the first tick, after delay, claim the node key that Start
+// did not write. Stop closes stop and releases the key itself, if ctx is
+// done otherwise, e.g. the context of Start was canceled, the heartbeat
+// releases the key.
+func (rd *RedisDriver) heartBeat(ctx context.Context, delay time.Duration, stop <-chan struct{}, exit chan<- struct{}) {
+	defer close(exit)
+	// every driver has its own source, so that nodes started together
+	// do not draw the same jitter.
+	random := rand.New(rand.NewSource(rd.clock.Now().UnixNano()))
+	scheduled := rd.jitterInterval(random, rd.effectiveHeartbeatInterval())
+	pending := delay > 0
+	if pending {
+		scheduled = delay
+	}
+	tick := rd.clock.NewTicker(scheduled)
+	defer tick.Stop()
+	// failures counts the consecutive failed ticks of an outage.
+	failures := 0
+	beat := func() {
+		if err := rd.registerServiceNodeWithRetry(ctx); err != nil {
+			failures++
+			rd.recordHeartbeat(err)
+			// a long outage is only logged on the 1st, 2nd, 4th, 8th... failure.
+			if failures&(failures-1) == 0 {
+				rd.logCtx(ctx, "heartbeat").Errorf("register service node error %+v, node=%s, %d consecutive failures", err, rd.nodeID, failures)
+			}
+		} else {
+			if failures > 0 {
+				rd.logCtx(ctx, "heartbeat").Infof("register service node recovered after %d consecutive failures, node=%s", failures, rd.nodeID)
+			}
+			failures = 0
+			rd.recordHeartbeat(nil)
+		}
+		next := rd.jitterInterval(random, rd.effectiveHeartbeatInterval())
+		if failures > 1 {
+			next = heartbeatBackoff(rd.effectiveHeartbeatInterval(), rd.heartbeatBackoffLimit(), failures)
+		}
+		if next != scheduled || rd.heartbeatJitter > 0 {
+			// the timeout changed, the next tick is jittered or backed off
+			scheduled = next
+			tick.Reset(next)
+		}
+	}
+	var reconnectedAt, verifiedAt time.Time
 	for {
 		select {
-		case <-tick.C:
-			{
-				if err := rd.registerServiceNode(); err != nil {
-					rd.logger.Errorf("register service node error %+v", err)
-				}
+		case <-tick.C():
+			if pending {
+				// the delayed registration is retried every interval until it succeeds.
+				err := rd.registerDelayed(ctx)
+				rd.recordHeartbeat(err)
+				pending = err != nil
+				scheduled = rd.jitterInterval(random, rd.effectiveHeartbeatInterval())
+				tick.Reset(scheduled)
+				continue
+			}
+			beat()
+		case <-rd.reconnected:
+			// a reconnect during an outage registers right away, at most
+			// once per minReconnectRegisterInterval.
+			if failures == 0 || pending || rd.clock.Now().Sub(reconnectedAt) < minReconnectRegisterInterval {
+				continue
+			}
+			reconnectedAt = rd.clock.Now()
+			rd.logCtx(ctx, "heartbeat").Infof("redis reconnected, register node=%s after %d consecutive failures", rd.nodeID, failures)
+			beat()
+		case <-rd.topologyChanged:
+			// the slot of the node key may have moved, a key lost on the
+			// way is written again right away.
+			if pending || rd.clock.Now().Sub(verifiedAt) < minReconnectRegisterInterval {
+				continue
+			}
+			verifiedAt = rd.clock.Now()
+			ok, err := rd.verifyRegistration(ctx)
+			if err != nil {
+				rd.logCtx(ctx, "heartbeat").Warnf("cluster topology changed, %v, node=%s", err, rd.nodeID)
+				continue
+			}
+			if !ok {
+				rd.logCtx(ctx, "heartbeat").Warnf("cluster topology changed and the key of node=%s is missing, register", rd.nodeID)
+				beat()
 			}
-		case <-rd.runtimeCtx.Done():
+		case <-ctx.Done():
 			{
-				if err := rd.c.Del(context.Background(), rd.nodeID, rd.nodeID).Err(); err != nil {
-					rd.logger.Errorf("unregister service node error %+v", err)
+				select {
+				case <-stop:
+					return
+				default:
 				}
+				releaseCtx, cancel := context.WithTimeout(context.Background(), rd.getCommandTimeout())
+				if err := rd.releaseNodeKey(releaseCtx); err != nil {
+					rd.log("stop").Errorf("unregister service node error %+v", err)
+				}
+				cancel()
 				return
 			}
 		}
 	}
 }
 
-func (rd *RedisDriver) registerServiceNode() error {
-	return rd.c.SetEx(context.Background(), rd.nodeID, rd.nodeID, rd.timeout).Err()
+// registerDelayed claims the node key after the initial register delay,
+// like Start does without the delay.
+func (rd *RedisDriver) registerDelayed(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, rd.effectiveHeartbeatTimeout())
+	defer cancel()
+	rd.registerMu.Lock()
+	rd.registeredAt = rd.clock.Now()
+	rd.registerMu.Unlock()
+	_, err := rd.writeServiceNode(ctx, true)
+	if err != nil {
+		rd.logCtx(ctx, "heartbeat").Errorf("delayed register service node error %+v, node=%s", err, rd.nodeID)
+		return err
+	}
+	rd.publishMembership(ctx, NodeJoin)
+	return nil
 }
 
-func (rd *RedisDriver) scan(ctx context.Context, matchStr string) ([]string, error) {
-	ret := make([]string, 0)
-	iter := rd.c.Scan(ctx, 0, matchStr, -1).Iterator()
-	for iter.Next(ctx) {
-		err := iter.Err()
-		if err != nil {
-			return nil, err
+// heartbeatBackoff doubles interval for every consecutive failure after
+// the first one, up to limit. An interval above limit is kept.
+func heartbeatBackoff(interval, limit time.Duration, failures int) time.Duration {
+	backoff := interval
+	for i := 1; i < failures && backoff < limit; i++ {
+		backoff *= 2
+	}
+	if backoff > limit && interval < limit {
+		backoff = limit
+	}
+	return backoff
+}
+
+// heartbeatBackoffLimit is the longest heartbeat interval of an outage.
+// The key expires while the heartbeat backs off, so the write after redis
+// recovered must still land within one TTL: the limit is the smaller one
+// of timeout minus the heartbeat timeout and half the timeout, and at most
+// maxHeartbeatBackoff.
+func (rd *RedisDriver) heartbeatBackoffLimit() time.Duration {
+	timeout := rd.getTimeout()
+	limit := timeout - rd.effectiveHeartbeatTimeout()
+	if half := timeout / 2; half < limit {
+		limit = half
+	}
+	if limit > maxHeartbeatBackoff {
+		limit = maxHeartbeatBackoff
+	}
+	return limit
+}
+
+// releaseNodeKey deletes the node key on Stop and publishes the leave if
+// the node joined, or lets the key expire after the stop grace period.
+func (rd *RedisDriver) releaseNodeKey(ctx context.Context) error {
+	if rd.stopGracePeriod > 0 && !rd.hashStorage {
+		_, err := rd.c.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Expire(ctx, rd.nodeKey(rd.nodeID), rd.stopGracePeriod)
+			for _, service := range rd.registeredServices() {
+				pipe.Expire(ctx, rd.nodeKeyOf(service, rd.nodeID), rd.stopGracePeriod)
+			}
+			return nil
+		})
+		return err
+	}
+	if err := rd.deleteNodeKey(ctx); err != nil {
+		return err
+	}
+	rd.registerMu.Lock()
+	joined := rd.joined
+	rd.registerMu.Unlock()
+	if joined {
+		// a node whose delayed registration never ran did not join
+		rd.publishMembership(ctx, NodeLeave)
+	}
+	return nil
+}
+
+// deleteNodeKey deletes the node key and the keys of RegisterService, or
+// the field of the node with WithHashStorage.
+func (rd *RedisDriver) deleteNodeKey(ctx context.Context) error {
+	if rd.hashStorage {
+		_, err := rd.c.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HDel(ctx, rd.hashKey(), rd.nodeID)
+			pipe.HDel(ctx, rd.heartbeatsKey(), rd.nodeID)
+			return nil
+		})
+		return err
+	}
+	services := rd.registeredServices()
+	if len(services) == 0 {
+		return rd.c.Del(ctx, rd.nodeKey(rd.nodeID)).Err()
+	}
+	// one DEL per key, the keys of the services may be in other slots.
+	_, err := rd.c.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, rd.nodeKey(rd.nodeID))
+		for _, service := range services {
+			pipe.Del(ctx, rd.nodeKeyOf(service, rd.nodeID))
+		}
+		return nil
+	})
+	return err
+}
+
+// jitterInterval moves interval by up to ±heartbeatJitter of it,
+// the result stays below the timeout so the key does not expire.
+func (rd *RedisDriver) jitterInterval(random *rand.Rand, interval time.Duration) time.Duration {
+	if rd.heartbeatJitter <= 0 {
+		return interval
+	}
+	delta := (random.Float64()*2 - 1) * rd.heartbeatJitter
+	jittered := interval + time.Duration(delta*float64(interval))
+	if jittered >= rd.getTimeout() {
+		return interval
+	}
+	return jittered
+}
+
+func (rd *RedisDriver) getTimeout() time.Duration {
+	rd.cfgMu.RLock()
+	defer rd.cfgMu.RUnlock()
+	return rd.timeout
+}
+
+// getCommandTimeout bounds a redis call of the driver, see
+// CommandTimeoutOption.
+func (rd *RedisDriver) getCommandTimeout() time.Duration {
+	rd.cfgMu.RLock()
+	defer rd.cfgMu.RUnlock()
+	if rd.commandTimeout > 0 {
+		return rd.commandTimeout
+	}
+	return rd.timeout
+}
+
+func (rd *RedisDriver) effectiveHeartbeatTimeout() time.Duration {
+	rd.cfgMu.RLock()
+	defer rd.cfgMu.RUnlock()
+	if rd.heartbeatTimeout > 0 {
+		return rd.heartbeatTimeout
+	}
+	return rd.timeout / 2
+}
+
+func (rd *RedisDriver) effectiveHeartbeatInterval() time.Duration {
+	rd.cfgMu.RLock()
+	defer rd.cfgMu.RUnlock()
+	if rd.heartbeatInterval > 0 {
+		return rd.heartbeatInterval
+	}
+	if rd.refreshRatio > 0 {
+		return time.Duration(float64(rd.timeout) / rd.refreshRatio)
+	}
+	return rd.timeout / 2
+}
+
+// registerServiceNodeWithRetry retries a failed registration with
+// exponential backoff on the clock of the driver, it gives up when the
+// driver is stopped.
+func (rd *RedisDriver) registerServiceNodeWithRetry(ctx context.Context) (err error) {
+	err = rd.heartbeatOnce(ctx)
+	backoff := rd.retryBackoff
+	for attempt := 1; err != nil && attempt <= rd.maxRetries; attempt++ {
+		rd.logCtx(ctx, "heartbeat").Warnf("register service node error %+v, node=%s, retry %d/%d in %v", err, rd.nodeID, attempt, rd.maxRetries, backoff)
+		if rd.sleep(ctx, backoff) != nil {
+			return
+		}
+		err = rd.heartbeatOnce(ctx)
+		backoff *= 2
+	}
+	return
+}
+
+// heartbeatOnce registers the node within the heartbeat timeout,
+// so a stuck call does not hold up the following ticks.
+func (rd *RedisDriver) heartbeatOnce(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, rd.effectiveHeartbeatTimeout())
+	defer cancel()
+	err := rd.registerServiceNode(ctx)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		rd.logCtx(ctx, "heartbeat").Warnf("register service node timed out after %v, node=%s", rd.effectiveHeartbeatTimeout(), rd.nodeID)
+	}
+	return err
+}
+
+// registerServiceNode writes the node key unless the node is drained or draining,
+// the background heartbeat, Tick and Unregister are serialized by registerMu.
+func (rd *RedisDriver) registerServiceNode(ctx context.Context) (err error) {
+	lost, err := rd.writeServiceNode(ctx, false)
+	if lost {
+		rd.logCtx(ctx, "heartbeat").Warnf("registration of node %s is lost", rd.nodeID)
+		if rd.onRegistrationLost != nil {
+			rd.onRegistrationLost()
+		}
+	}
+	return err
+}
+
+// writeServiceNode writes the node key and reports whether the key of a
+// registered node was missing. Detecting it does not cost a round trip,
+// the key is written by SET with the GET flag (redis 6.2+) that returns
+// the previous value. Without an OnRegistrationLost callback the plain
+// SETEX is used, so older servers keep working. With WithRefreshOnly the
+// key is refreshed by SET XX and claimed again if it is gone.
+// With claim the key is only written if no other driver instance holds it.
+//
+// A heartbeat that finds its key is a single round trip: the node key
+// alone is one command, the hash storage and the commands of the register
+// hook are queued in one pipeline with it and sent by a single Exec.
+// Sending the hook commands one by one would cost 1+N round trips per
+// heartbeat, e.g. three instead of one for a hook that refreshes two
+// keys. The other writes cost more round trips:
+//   - the claim of Start is one SET NX if the key is free, a GET and a
+//     SETEX follow if it exists. With a register hook it is a WATCH, a
+//     GET, the MULTI/EXEC and an UNWATCH.
+//   - the claim of the hash storage reads the field before the pipeline.
+//   - with WithRefreshOnly a key that is gone is claimed again after the
+//     SET XX, like by Start.
+//   - the keys of RegisterService take one more pipeline.
+func (rd *RedisDriver) writeServiceNode(ctx context.Context, claim bool) (lost bool, err error) {
+	rd.registerMu.Lock()
+	defer rd.registerMu.Unlock()
+	if rd.drained || rd.draining {
+		return false, nil
+	}
+	value, err := rd.nodeValue()
+	if err != nil {
+		return false, err
+	}
+	timeout := rd.getTimeout()
+	ctx, cancel := context.WithTimeout(ctx, rd.getCommandTimeout())
+	defer cancel()
+	var missing bool
+	switch {
+	case rd.hashStorage:
+		missing, err = rd.writeHashNode(ctx, value, timeout, claim)
+	case claim:
+		err = rd.claimNodeKey(ctx, value, timeout)
+	case rd.registerHook != nil:
+		missing, err = rd.setNodeKeyWithHook(ctx, value, timeout)
+	case rd.refreshOnly:
+		missing, err = rd.refreshNodeKey(ctx, value, timeout)
+	case rd.onRegistrationLost == nil:
+		ctx, span := rd.startSpan(ctx, "redisdriver.register", "SETEX")
+		err = rd.c.SetEx(ctx, rd.nodeKey(rd.nodeID), value, timeout).Err()
+		endSpan(span, err)
+	default:
+		ctx, span := rd.startSpan(ctx, "redisdriver.register", "SET")
+		err = rd.c.SetArgs(ctx, rd.nodeKey(rd.nodeID), value, redis.SetArgs{TTL: timeout, Get: true}).Err()
+		missing = errors.Is(err, redis.Nil)
+		if missing {
+			err = nil
+		}
+		endSpan(span, err)
+	}
+	if err != nil {
+		return false, err
+	}
+	if services := rd.registeredServices(); len(services) > 0 {
+		if err = rd.writeServiceKeys(ctx, services, value, timeout); err != nil {
+			return false, err
+		}
+	}
+	lost = missing && rd.registered
+	rd.markRegistered()
+	rd.extendGeneration(ctx)
+	return lost, nil
+}
+
+// setNodeKeyWithHook writes the node key and the commands of the register
+// hook in one MULTI/EXEC, it reports whether the key was missing like
+// writeServiceNode. An error of the hook fails the write, nothing is sent.
+func (rd *RedisDriver) setNodeKeyWithHook(ctx context.Context, value string, timeout time.Duration) (missing bool, err error) {
+	ctx, span := rd.startSpan(ctx, "redisdriver.register", "EXEC")
+	defer func() { endSpan(span, err) }()
+	var set redis.Cmder
+	cmds, err := rd.c.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		set = rd.queueNodeKey(ctx, pipe, value, timeout)
+		return rd.runRegisterHook(ctx, pipe)
+	})
+	if len(cmds) == 0 {
+		return false, err
+	}
+	// the pipeline returns the redis.Nil of SET GET and SET XX as its
+	// error as well.
+	for _, cmd := range cmds {
+		cmdErr := cmd.Err()
+		if cmd == set && errors.Is(cmdErr, redis.Nil) {
+			missing = true
+			continue
+		}
+		if cmdErr != nil {
+			return false, cmdErr
+		}
+	}
+	if missing && rd.refreshOnly {
+		// SET XX did not write the key, the hook ran already.
+		return true, rd.claimNodeKeyOnly(ctx, value, timeout)
+	}
+	return missing, nil
+}
+
+// refreshNodeKey refreshes the node key by SET XX, a key that is gone is
+// claimed again like by Start and reported as missing.
+func (rd *RedisDriver) refreshNodeKey(ctx context.Context, value string, timeout time.Duration) (missing bool, err error) {
+	ctx, span := rd.startSpan(ctx, "redisdriver.register", "SET")
+	defer func() { endSpan(span, err) }()
+	key := rd.nodeKey(rd.nodeID)
+	err = rd.c.SetArgs(ctx, key, value, redis.SetArgs{TTL: timeout, Mode: "XX"}).Err()
+	if !errors.Is(err, redis.Nil) {
+		return false, err
+	}
+	// another process may have taken the free node ID meanwhile
+	return true, rd.claimNodeKey(ctx, value, timeout)
+}
+
+// queueNodeKey adds the write of the node key to pipe, SET XX or SET GET
+// if the loss of the key is detected.
+func (rd *RedisDriver) queueNodeKey(ctx context.Context, pipe redis.Pipeliner, value string, timeout time.Duration) redis.Cmder {
+	key := rd.nodeKey(rd.nodeID)
+	switch {
+	case rd.refreshOnly:
+		return pipe.SetArgs(ctx, key, value, redis.SetArgs{TTL: timeout, Mode: "XX"})
+	case rd.onRegistrationLost == nil:
+		return pipe.SetEx(ctx, key, value, timeout)
+	}
+	return pipe.SetArgs(ctx, key, value, redis.SetArgs{TTL: timeout, Get: true})
+}
+
+func (rd *RedisDriver) runRegisterHook(ctx context.Context, pipe redis.Pipeliner) error {
+	if err := rd.registerHook(ctx, pipe); err != nil {
+		return fmt.Errorf("register hook: %w", err)
+	}
+	return nil
+}
+
+// markRegistered records a successful write of the node key,
+// registerMu must be held.
+func (rd *RedisDriver) markRegistered() {
+	rd.registered = true
+	rd.joined = true
+	rd.statusMu.Lock()
+	rd.lastHeartbeatOK = rd.clock.Now()
+	rd.statusMu.Unlock()
+}
+
+// claimNodeKey writes the node key by SET NX. A key that exists already
+// is only overwritten if this driver instance wrote it, otherwise another
+// process runs with the same node ID and ErrNodeIDCollision is returned.
+// With a register hook the key is watched instead, the check runs on the
+// watched key and the write of the key and the hook run in one
+// MULTI/EXEC, which fails if the key changed meanwhile.
+func (rd *RedisDriver) claimNodeKey(ctx context.Context, value string, timeout time.Duration) (err error) {
+	ctx, span := rd.startSpan(ctx, "redisdriver.register", "SET")
+	defer func() { endSpan(span, err) }()
+	key := rd.nodeKey(rd.nodeID)
+	if rd.registerHook != nil {
+		return rd.c.Watch(ctx, func(tx *redis.Tx) error {
+			if err := rd.checkNodeKey(ctx, tx, key); err != nil {
+				return err
+			}
+			_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, key, value, timeout)
+				return rd.runRegisterHook(ctx, pipe)
+			})
+			return err
+		}, key)
+	}
+	return rd.claimNodeKeyOnly(ctx, value, timeout)
+}
+
+// claimNodeKeyOnly is claimNodeKey without the register hook.
+func (rd *RedisDriver) claimNodeKeyOnly(ctx context.Context, value string, timeout time.Duration) error {
+	key := rd.nodeKey(rd.nodeID)
+	ok, err := rd.c.SetNX(ctx, key, value, timeout).Result()
+	if err != nil {
+		return err
+	}
+	if ok {
+		return nil
+	}
+	if err = rd.checkNodeKey(ctx, rd.c, key); err != nil {
+		return err
+	}
+	return rd.c.SetEx(ctx, key, value, timeout).Err()
+}
+
+// checkNodeKey returns ErrNodeIDCollision if the node key holds the value
+// of another driver instance.
+func (rd *RedisDriver) checkNodeKey(ctx context.Context, c redis.Cmdable, key string) error {
+	current, err := c.Get(ctx, key).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	// an expired key is free as well
+	if err == nil {
+		info, err := rd.decodeNodeValue(rd.nodeID, key, current)
+		if err != nil || info.Instance != rd.instance {
+			return fmt.Errorf("%w: node %s", ErrNodeIDCollision, rd.nodeID)
 		}
-		ret = append(ret, iter.Val())
+	}
+	return nil
+}
+
+// nextGeneration increments the generation counter of the node, the
+// counter outlives the node key so a restart with the same node ID
+// gets a greater generation.
+func (rd *RedisDriver) nextGeneration(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, rd.getCommandTimeout())
+	defer cancel()
+	key := rd.generationKey()
+	var incr *redis.IntCmd
+	_, err := rd.c.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, key)
+		pipe.Expire(ctx, key, rd.generationTTL())
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+// generationTTL is the expiry of the generation counter. It outlives the
+// node values WithStaleCleanup keeps, so no reader holds a value of a
+// greater generation than the counter.
+func (rd *RedisDriver) generationTTL() time.Duration {
+	if rd.staleCleanup > generationTTL {
+		return rd.staleCleanup
+	}
+	return generationTTL
+}
+
+// extendGeneration extends the expiry of the generation counter once half
+// of it passed, so a node running for longer keeps its counter. An error
+// is only logged, the next heartbeat tries again. registerMu must be held.
+func (rd *RedisDriver) extendGeneration(ctx context.Context) {
+	now := rd.clock.Now()
+	ttl := rd.generationTTL()
+	if now.Sub(rd.generationExtended) < ttl/2 {
+		return
+	}
+	if err := rd.c.Expire(ctx, rd.generationKey(), ttl).Err(); err != nil {
+		rd.logCtx(ctx, "heartbeat").Warnf("extend node generation error %+v, node=%s", err, rd.nodeID)
+		return
+	}
+	rd.generationExtended = now
+}
+
+func (rd *RedisDriver) generationKey() string {
+	return rd.serviceKey("generation-" + rd.nodeID)
+}
+
+// readClient is the client of the discovery reads, the replica if one is set.
+func (rd *RedisDriver) readClient() redis.UniversalClient {
+	if rd.replica != nil {
+		return rd.replica
+	}
+	return rd.c
+}
+
+// servicePrefix is the prefix of all node keys of this service.
+func (rd *RedisDriver) servicePrefix() string {
+	if rd.prefix != "" {
+		return rd.prefix
+	}
+	return rd.prefixOf(rd.serviceName)
+}
+
+// prefixOf is the prefix of the node keys of service.
+func (rd *RedisDriver) prefixOf(service string) string {
+	if rd.clusterMode {
+		// {distributed-cron:<service>}: keeps all keys of the service in one slot.
+		return rd.keyPrefix + "{" + strings.TrimSuffix(commons.GetKeyPre(service), ":") + "}:"
+	}
+	return rd.keyPrefix + commons.GetKeyPre(service)
+}
+
+// sameSlot reports whether keys are served together, so a MULTI/EXEC may
+// write all of them. On a cluster client they must share the hash tag.
+func (rd *RedisDriver) sameSlot(keys ...string) bool {
+	if _, ok := rd.c.(*redis.ClusterClient); !ok {
+		return true
+	}
+	for _, key := range keys[1:] {
+		if hashTag(key) != hashTag(keys[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+// hashTag is the part of key that cluster hashes to pick the slot, the
+// first non-empty {tag}, or the whole key.
+func hashTag(key string) string {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			return key[start+1 : start+1+end]
+		}
+	}
+	return key
+}
+
+func (rd *RedisDriver) nodeKey(nodeID string) string {
+	if rd.keyBuilder != nil {
+		return rd.keyBuilder(rd.serviceName, nodeID)
+	}
+	return rd.servicePrefix() + nodeID
+}
+
+// nodeKeyOf is nodeKey for the node keys of service.
+func (rd *RedisDriver) nodeKeyOf(service, nodeID string) string {
+	if rd.keyBuilder != nil {
+		return rd.keyBuilder(service, nodeID)
+	}
+	return rd.prefixOf(service) + nodeID
+}
+
+// nodeMatch is the SCAN pattern of all node keys of this service, it is
+// read by every discovery call and built once per key layout.
+func (rd *RedisDriver) nodeMatch() string {
+	if rd.match != "" {
+		return rd.match
+	}
+	return rd.nodeKey("*")
+}
+
+// updateKeyLayout builds the cached prefix and match pattern again after
+// the service name, key prefix, cluster mode or key builder changed.
+func (rd *RedisDriver) updateKeyLayout() {
+	rd.prefix = rd.prefixOf(rd.serviceName)
+	rd.match = rd.nodeKey("*")
+}
+
+// serviceKey builds the key of a service wide resource.
+// It shares the service namespace, but never matches the node keys pattern.
+func (rd *RedisDriver) serviceKey(name string) string {
+	return strings.TrimSuffix(rd.servicePrefix(), ":") + "@" + name
+}
+
+// checkServiceKeys rejects a SCAN pattern that may match the service keys
+// "<prefix>@<name>", e.g. the leader, events, mutex-* or job-* keys, since
+// GetNodes would list them as nodes. The names of the mutex and job keys
+// are chosen by the caller, so only the literal prefix of pattern tells.
+func (rd *RedisDriver) checkServiceKeys(pattern string) error {
+	prefix, _, _ := strings.Cut(pattern, "*")
+	serviceKeys := rd.serviceKey("")
+	if strings.HasPrefix(serviceKeys, prefix) || strings.HasPrefix(prefix, serviceKeys) {
+		return fmt.Errorf("%w: scan pattern %q may match the service keys %s*", ErrInvalidOption, pattern, serviceKeys)
+	}
+	return nil
+}
+
+func (rd *RedisDriver) nodeIDFromKey(key string) (string, bool) {
+	return nodeIDFromMatch(key, rd.nodeMatch())
+}
+
+// nodeIDFromMatch returns the node id of key, a key matching the node
+// key pattern match.
+func nodeIDFromMatch(key, match string) (string, bool) {
+	// the node id takes the place of the only "*" of the pattern
+	prefix, suffix, _ := strings.Cut(match, "*")
+	if len(key) < len(prefix)+len(suffix) || !strings.HasPrefix(key, prefix) || !strings.HasSuffix(key, suffix) {
+		return "", false
+	}
+	nodeID := key[len(prefix) : len(key)-len(suffix)]
+	// the pattern also matches e.g. the node keys of a service named
+	// "<service>:<name>", node ids never contain ':'.
+	if nodeID == "" || strings.ContainsAny(nodeID, ":*?[]\\") {
+		return "", false
+	}
+	return nodeID, true
+}
+
+// scannedNodeID is nodeIDFromKey for a key found by SCAN. A key without
+// the prefix of the node keys is logged at debug level, every scan finds
+// it again. A key with the prefix whose node id does not parse, e.g. a
+// node key of the service "<service>:<name>", is logged at warn level.
+func (rd *RedisDriver) scannedNodeID(key string) (string, bool) {
+	nodeID, ok := rd.nodeIDFromKey(key)
+	if !ok {
+		match := rd.nodeMatch()
+		prefix, suffix, _ := strings.Cut(match, "*")
+		if strings.HasPrefix(key, prefix) && strings.HasSuffix(key, suffix) {
+			rd.log("get_nodes").Warnf("skip key=%s with an invalid node id, matching %s", key, match)
+		} else {
+			rd.debugf("get_nodes", "skip key=%s not matching %s", key, match)
+		}
+	}
+	return nodeID, ok
+}
+
+// checkMatchPattern rejects a SCAN pattern without a literal prefix,
+// it would scan the whole keyspace and match unrelated keys.
+func checkMatchPattern(pattern string) error {
+	if i := strings.IndexAny(pattern, "*?["); i <= 0 {
+		return fmt.Errorf("%w: scan pattern %q has no literal prefix", ErrInvalidOption, pattern)
+	}
+	return nil
+}
+
+// scan returns the keys matching matchStr. SCAN may return a key more
+// than once, e.g. while the keyspace is rehashed or a slot migrates
+// between masters, it is returned once.
+func (rd *RedisDriver) scan(ctx context.Context, matchStr string) (ret []string, err error) {
+	ret = make([]string, 0)
+	seen := make(map[string]struct{})
+	err = rd.scanEach(ctx, matchStr, func(key string) {
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			ret = append(ret, key)
+		}
+	})
+	if errors.Is(err, ErrPartialScan) {
+		return ret, err
+	}
+	if err != nil {
+		return nil, err
 	}
 	return ret, nil
 }
 
+// scanEach calls fn for every key matching matchStr that SCAN returns,
+// a key may come more than once, see scan. Only the callers that collect
+// the keys or ids drop the duplicates, a count does not pay for it.
+func (rd *RedisDriver) scanEach(ctx context.Context, matchStr string, fn func(key string)) (err error) {
+	if err = rd.waitScan(ctx); err != nil {
+		return err
+	}
+	begin := time.Now()
+	defer func() {
+		duration := time.Since(begin)
+		rd.metrics.ObserveScanDuration(duration)
+		rd.stats.scans.Add(1)
+		rd.stats.lastScanDuration.Store(int64(duration))
+		if err != nil {
+			rd.stats.setLastError(err)
+		}
+	}()
+	if err = checkMatchPattern(matchStr); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, rd.getCommandTimeout())
+	defer cancel()
+	ctx, span := rd.startSpan(ctx, "redisdriver.scan", "SCAN")
+	defer func() { endSpan(span, err) }()
+	client := rd.readClient()
+	if cluster, ok := client.(*redis.ClusterClient); ok {
+		// the masters are scanned concurrently, fn is called by one at a time.
+		var mu sync.Mutex
+		err = rd.scanMasters(ctx, cluster, matchStr, func(key string) {
+			mu.Lock()
+			defer mu.Unlock()
+			fn(key)
+		})
+	} else {
+		err = rd.scanClient(ctx, client, matchStr, fn)
+	}
+	if err != nil {
+		if rd.lenientScan {
+			return &partialScanError{err: fmt.Errorf("scan node keys: %w", err)}
+		}
+		return fmt.Errorf("scan node keys: %w", err)
+	}
+	return nil
+}
+
+// scanMasters runs SCAN on every master of the cluster, a cluster client
+// only scans the one node it talks to. fn is called concurrently.
+func (rd *RedisDriver) scanMasters(ctx context.Context, cluster *redis.ClusterClient, matchStr string, fn func(key string)) error {
+	return cluster.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+		return rd.scanClient(ctx, master, matchStr, fn)
+	})
+}
+
+func (rd *RedisDriver) scanClient(ctx context.Context, client scanner, matchStr string, fn func(key string)) error {
+	iter := client.Scan(ctx, 0, matchStr, rd.scanCount).Iterator()
+	for iter.Next(ctx) {
+		fn(iter.Val())
+	}
+	return iter.Err()
+}
+
+// SetLogger replaces the logger, also while the driver is running.
+func (rd *RedisDriver) SetLogger(logger dlog.Logger) {
+	rd.logger.set(logger)
+}
+
+// SetTimeout replaces the node key TTL, also while the driver is running.
+// The new TTL is used by the next heartbeat and the heartbeat ticker
+// follows the new interval from then on. It fails if timeout is not
+// greater than the configured heartbeat interval.
+func (rd *RedisDriver) SetTimeout(timeout time.Duration) error {
+	if timeout <= 0 {
+		return fmt.Errorf("%w: timeout %v must be positive", ErrInvalidOption, timeout)
+	}
+	return rd.setTimeout(timeout)
+}
+
+// Timeout returns the node key TTL, including the changes of SetTimeout.
+func (rd *RedisDriver) Timeout() time.Duration {
+	return rd.getTimeout()
+}
+
+// HeartbeatInterval returns the refresh period of the node key, the
+// timeout divided by the ratio of WithTTLRefreshRatio, 2 by default,
+// unless WithHeartbeatInterval is set. It follows SetTimeout.
+func (rd *RedisDriver) HeartbeatInterval() time.Duration {
+	return rd.effectiveHeartbeatInterval()
+}
+
+func (rd *RedisDriver) setTimeout(timeout time.Duration) error {
+	rd.cfgMu.Lock()
+	defer rd.cfgMu.Unlock()
+	if timeout < rd.minTimeout {
+		return fmt.Errorf("%w: timeout %v must be at least %v", ErrInvalidOption, timeout, rd.minTimeout)
+	}
+	if rd.heartbeatInterval > 0 && rd.heartbeatInterval >= timeout {
+		return fmt.Errorf("%w: timeout %v must be greater than heartbeat interval %v",
+			ErrInvalidOption, timeout, rd.heartbeatInterval)
+	}
+	rd.timeout = timeout
+	return nil
+}
+
 func (rd *RedisDriver) WithOption(opt commons.Option) (err error) {
 	switch opt.Type() {
 	case commons.OptionTypeTimeout:
 		{
-			rd.timeout = opt.(commons.TimeoutOption).Timeout
+			timeout := opt.(commons.TimeoutOption).Timeout
+			if timeout <= 0 {
+				err = fmt.Errorf("%w: timeout %v must be positive", ErrInvalidOption, timeout)
+				return
+			}
+			err = rd.setTimeout(timeout)
 		}
 	case commons.OptionTypeLogger:
 		{
-			rd.logger = opt.(commons.LoggerOption).Logger
+			rd.logger.set(opt.(commons.LoggerOption).Logger)
+		}
+	case OptionTypeHeartbeatInterval:
+		{
+			interval := opt.(HeartbeatIntervalOption).Interval
+			rd.cfgMu.Lock()
+			defer rd.cfgMu.Unlock()
+			if interval <= 0 || interval >= rd.timeout {
+				err = fmt.Errorf("%w: heartbeat interval %v must be positive and less than timeout %v",
+					ErrInvalidOption, interval, rd.timeout)
+				return
+			}
+			rd.heartbeatInterval = interval
+		}
+	case OptionTypeScanCount:
+		{
+			count := opt.(ScanCountOption).Count
+			if count <= 0 {
+				err = fmt.Errorf("%w: scan count %d must be positive", ErrInvalidOption, count)
+				return
+			}
+			rd.scanCount = count
+		}
+	case OptionTypeNodeMetadata:
+		{
+			labels := make(map[string]string, len(opt.(NodeMetadataOption).Labels))
+			for k, v := range opt.(NodeMetadataOption).Labels {
+				labels[k] = v
+			}
+			rd.labels = labels
+		}
+	case OptionTypeOnLeadershipLost:
+		{
+			rd.onLeadershipLost = opt.(OnLeadershipLostOption).Callback
+		}
+	case OptionTypeKeyspaceNotifications:
+		{
+			rd.keyspaceNotifications = opt.(KeyspaceNotificationsOption).Enabled
+		}
+	case OptionTypeMetrics:
+		{
+			metrics := opt.(MetricsOption).Collector
+			if metrics == nil {
+				metrics = nopMetrics{}
+			}
+			rd.metrics = metrics
+		}
+	case OptionTypeMaxRetries:
+		{
+			retries := opt.(MaxRetriesOption).MaxRetries
+			if retries < 0 {
+				err = fmt.Errorf("%w: max retries %d must not be negative", ErrInvalidOption, retries)
+				return
+			}
+			rd.maxRetries = retries
+		}
+	case OptionTypeRetryBackoff:
+		{
+			backoff := opt.(RetryBackoffOption).Backoff
+			if backoff <= 0 {
+				err = fmt.Errorf("%w: retry backoff %v must be positive", ErrInvalidOption, backoff)
+				return
+			}
+			rd.retryBackoff = backoff
+		}
+	case OptionTypeTracer:
+		{
+			tracer := opt.(TracerOption).Tracer
+			if tracer == nil {
+				tracer = defaultTracer()
+			}
+			rd.tracer = tracer
+		}
+	case OptionTypeClock:
+		{
+			clock := opt.(ClockOption).Clock
+			if clock == nil {
+				clock = realClock{}
+			}
+			rd.clock = clock
+		}
+	case OptionTypeHeartbeatJitter:
+		{
+			fraction := opt.(HeartbeatJitterOption).Fraction
+			if fraction < 0 || fraction >= 1 {
+				err = fmt.Errorf("%w: heartbeat jitter %v must be in [0, 1)", ErrInvalidOption, fraction)
+				return
+			}
+			rd.heartbeatJitter = fraction
+		}
+	case OptionTypeOnRegistrationLost:
+		{
+			rd.onRegistrationLost = opt.(OnRegistrationLostOption).Callback
+		}
+	case OptionTypeClusterMode:
+		{
+			rd.clusterMode = opt.(ClusterModeOption).Enabled
+			rd.updateKeyLayout()
+		}
+	case OptionTypeReadFromReplica:
+		{
+			rd.replica = opt.(ReadFromReplicaOption).Replica
+		}
+	case OptionTypeNodeID:
+		{
+			nodeID := opt.(NodeIDOption).NodeID
+			if nodeID == "" || strings.ContainsAny(nodeID, ":*?[]\\") {
+				err = fmt.Errorf("%w: node id %q must not be empty or contain ':' or glob characters", ErrInvalidOption, nodeID)
+				return
+			}
+			if rd.IsStarted() {
+				err = fmt.Errorf("%w: node id can not change while the driver is started", ErrInvalidOption)
+				return
+			}
+			rd.configuredNodeID = nodeID
+			rd.nodeID = nodeID
+		}
+	case OptionTypeKeyBuilder:
+		{
+			builder := opt.(KeyBuilderOption).Builder
+			if builder != nil {
+				if pattern := builder("service", "*"); strings.Count(pattern, "*") != 1 || strings.ContainsAny(pattern, "?[]\\") {
+					err = fmt.Errorf("%w: key builder pattern %q must contain exactly one '*' and no other glob characters", ErrInvalidOption, pattern)
+					return
+				}
+				if err = checkMatchPattern(builder(rd.serviceName, "*")); err != nil {
+					return
+				}
+				if err = rd.checkServiceKeys(builder(rd.serviceName, "*")); err != nil {
+					return
+				}
+			}
+			rd.keyBuilder = builder
+			rd.updateKeyLayout()
+		}
+	case OptionTypeNodeCache:
+		{
+			ttl := opt.(NodeCacheOption).TTL
+			if ttl < 0 {
+				err = fmt.Errorf("%w: node cache ttl %v must not be negative", ErrInvalidOption, ttl)
+				return
+			}
+			rd.nodeCache = nil
+			if ttl > 0 {
+				rd.nodeCache = &nodeCache{ttl: ttl}
+			}
+		}
+	case OptionTypeLastKnownFallback:
+		{
+			rd.lastKnownFallback = opt.(LastKnownFallbackOption).Enabled
+		}
+	case OptionTypeLenientScan:
+		{
+			rd.lenientScan = opt.(LenientScanOption).Enabled
+		}
+	case OptionTypeHeartbeatTimeout:
+		{
+			timeout := opt.(HeartbeatTimeoutOption).Timeout
+			if timeout <= 0 {
+				err = fmt.Errorf("%w: heartbeat timeout %v must be positive", ErrInvalidOption, timeout)
+				return
+			}
+			rd.cfgMu.Lock()
+			rd.heartbeatTimeout = timeout
+			rd.cfgMu.Unlock()
+		}
+	case OptionTypeStopGracePeriod:
+		{
+			period := opt.(StopGracePeriodOption).Period
+			if period < 0 {
+				err = fmt.Errorf("%w: stop grace period %v must not be negative", ErrInvalidOption, period)
+				return
+			}
+			rd.stopGracePeriod = period
+		}
+	case OptionTypeClockSkewAlert:
+		{
+			alert := opt.(ClockSkewAlertOption)
+			if alert.Threshold <= 0 {
+				err = fmt.Errorf("%w: clock skew threshold %v must be positive", ErrInvalidOption, alert.Threshold)
+				return
+			}
+			rd.clockSkewAlert = &clockSkewAlert{threshold: alert.Threshold, callback: alert.Callback}
+		}
+	case OptionTypeExternalHeartbeat:
+		{
+			rd.externalHeartbeat = opt.(ExternalHeartbeatOption).Enabled
+		}
+	case OptionTypeAdvertiseAddr:
+		{
+			addr := opt.(AdvertiseAddrOption).Addr
+			if err = validateAdvertiseAddr(addr); err != nil {
+				err = fmt.Errorf("%w: advertise address: %v", ErrInvalidOption, err)
+				return
+			}
+			rd.advertiseAddr = addr
+		}
+	case OptionTypeNodeWeight:
+		{
+			err = rd.SetNodeWeight(opt.(NodeWeightOption).Weight)
+		}
+	case OptionTypeSerializer:
+		{
+			serializer := opt.(SerializerOption).Serializer
+			if serializer == nil {
+				err = fmt.Errorf("%w: serializer must not be nil", ErrInvalidOption)
+				return
+			}
+			rd.serializer = serializer
+		}
+	case OptionTypeMetadataCompression:
+		{
+			threshold := opt.(MetadataCompressionOption).Threshold
+			if threshold < 0 {
+				err = fmt.Errorf("%w: compression threshold %d must not be negative", ErrInvalidOption, threshold)
+				return
+			}
+			rd.compressionThreshold = threshold
+		}
+	case OptionTypeMinTimeout:
+		{
+			minTimeout := opt.(MinTimeoutOption).MinTimeout
+			rd.cfgMu.Lock()
+			defer rd.cfgMu.Unlock()
+			if minTimeout <= 0 || minTimeout > rd.timeout {
+				err = fmt.Errorf("%w: min timeout %v must be positive and at most timeout %v",
+					ErrInvalidOption, minTimeout, rd.timeout)
+				return
+			}
+			rd.minTimeout = minTimeout
+		}
+	case OptionTypeOnNodeJoin:
+		{
+			rd.onNodeJoin = opt.(OnNodeJoinOption).Callback
+		}
+	case OptionTypeOnNodeLeave:
+		{
+			rd.onNodeLeave = opt.(OnNodeLeaveOption).Callback
+		}
+	case OptionTypeDB:
+		{
+			db := opt.(DBOption).DB
+			if db < 0 {
+				err = fmt.Errorf("%w: db %d must not be negative", ErrInvalidOption, db)
+				return
+			}
+			switch client := rd.c.(type) {
+			case *redis.ClusterClient:
+				if db != 0 {
+					err = fmt.Errorf("%w: a cluster has db 0 only, not db %d", ErrInvalidOption, db)
+					return
+				}
+			case *redis.Client:
+				if client.Options().DB != db {
+					err = fmt.Errorf("%w: the client selects db %d, not db %d", ErrInvalidOption, client.Options().DB, db)
+					return
+				}
+			}
+		}
+	case OptionTypeInitialRegisterDelay:
+		{
+			delay := opt.(InitialRegisterDelayOption).Delay
+			if delay < 0 {
+				err = fmt.Errorf("%w: initial register delay %v must not be negative", ErrInvalidOption, delay)
+				return
+			}
+			rd.initialRegisterDelay = delay
+		}
+	case OptionTypeTopologyWatch:
+		{
+			if opt.(TopologyWatchOption).Enabled && rd.clusterWatch != nil && rd.topologyChanged == nil {
+				rd.topologyChanged = make(chan struct{}, 1)
+			}
+		}
+	case OptionTypeConsistentSnapshot:
+		{
+			rd.consistentSnapshot = opt.(ConsistentSnapshotOption).Enabled
+		}
+	case OptionTypeCommandTimeout:
+		{
+			timeout := opt.(CommandTimeoutOption).Timeout
+			if timeout < 0 {
+				err = fmt.Errorf("%w: command timeout %v must not be negative", ErrInvalidOption, timeout)
+				return
+			}
+			rd.cfgMu.Lock()
+			rd.commandTimeout = timeout
+			rd.cfgMu.Unlock()
+		}
+	case OptionTypeRefreshOnly:
+		{
+			rd.refreshOnly = opt.(RefreshOnlyOption).Enabled
+		}
+	case OptionTypeScanRateLimit:
+		{
+			rps := opt.(ScanRateLimitOption).RPS
+			if !(rps >= 0) || math.IsInf(rps, 1) {
+				err = fmt.Errorf("%w: scan rate limit %v must be a finite number not below 0", ErrInvalidOption, rps)
+				return
+			}
+			rd.scanLimiter = nil
+			if rps > 0 {
+				rd.scanLimiter = newScanLimiter(rps)
+			}
+		}
+	case OptionTypeRegisterHook:
+		{
+			rd.registerHook = opt.(RegisterHookOption).Hook
+		}
+	case OptionTypeReconnectRegister:
+		{
+			if opt.(ReconnectRegisterOption).Enabled && rd.reconnected == nil {
+				rd.reconnected = make(chan struct{}, 1)
+				if rd.clusterWatch == nil {
+					// the shards of a cluster client are hooked on Start.
+					rd.c.AddHook(reconnectHook{reconnected: rd.reconnected})
+				}
+			}
+		}
+	case OptionTypeMembershipEvents:
+		{
+			rd.membershipEvents = opt.(MembershipEventsOption).Enabled
+		}
+	case OptionTypeHashStorage:
+		{
+			rd.hashStorage = opt.(HashStorageOption).Enabled
+		}
+	case OptionTypeStaleCleanup:
+		{
+			maxAge := opt.(StaleCleanupOption).MaxAge
+			if maxAge <= 0 {
+				err = fmt.Errorf("%w: stale cleanup max age %v must be positive", ErrInvalidOption, maxAge)
+				return
+			}
+			rd.staleCleanup = maxAge
+		}
+	case OptionTypeTTLRefreshRatio:
+		{
+			ratio := opt.(TTLRefreshRatioOption).Ratio
+			if !(ratio > 1) || math.IsInf(ratio, 1) {
+				err = fmt.Errorf("%w: ttl refresh ratio %v must be a finite number greater than 1", ErrInvalidOption, ratio)
+				return
+			}
+			rd.cfgMu.Lock()
+			rd.refreshRatio = ratio
+			rd.cfgMu.Unlock()
+		}
+	case OptionTypeKeyPrefix:
+		{
+			prefix := opt.(KeyPrefixOption).Prefix
+			if prefix == "" {
+				err = fmt.Errorf("%w: key prefix must not be empty", ErrInvalidOption)
+				return
+			}
+			rd.keyPrefix = prefix
+			rd.updateKeyLayout()
 		}
 	}
 	return
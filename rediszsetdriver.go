@@ -2,9 +2,9 @@ package redisdriver
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"sync"
 	"time"
 
@@ -16,15 +16,23 @@ import (
 type RedisZSetDriver struct {
 	c           redis.UniversalClient
 	serviceName string
-	nodeID      string
-	timeout     time.Duration
-	logger      dlog.Logger
-	started     bool
+	// key is the sorted set of the service, built once by Init.
+	key    string
+	nodeID string
+	// member is the node id with the service prefix, the member of the
+	// node in the sorted set, like older drivers stored it.
+	member  string
+	timeout time.Duration
+	logger  dlog.Logger
+	started bool
 
 	// this context is used to define
 	// the lifetime of this driver.
 	runtimeCtx    context.Context
 	runtimeCancel context.CancelFunc
+	// heartbeatDone receives the deregister result
+	// when the heartbeat goroutine exits.
+	heartbeatDone chan error
 
 	sync.Mutex
 }
@@ -43,7 +51,11 @@ func NewZSetDriver(redisClient redis.UniversalClient) *RedisZSetDriver {
 
 func (rd *RedisZSetDriver) Init(serviceName string, opts ...commons.Option) {
 	rd.serviceName = serviceName
-	rd.nodeID = commons.GetNodeId(serviceName)
+	rd.key = commons.GetKeyPre(serviceName)
+	// the node id is the unique part of commons.GetNodeId, like the one of
+	// RedisDriver, the member keeps the service prefix.
+	rd.member = commons.GetNodeId(serviceName)
+	rd.nodeID = strings.TrimPrefix(rd.member, rd.key)
 	for _, opt := range opts {
 		rd.WithOption(opt)
 	}
@@ -53,46 +65,89 @@ func (rd *RedisZSetDriver) NodeID() string {
 	return rd.nodeID
 }
 
+// GetNodes returns the ids of the alive nodes, without the service prefix
+// of their members, so they compare equal to NodeID like the ones of
+// RedisDriver.GetNodes.
 func (rd *RedisZSetDriver) GetNodes(ctx context.Context) (nodes []string, err error) {
+	nodes, err = rd.GetRawMembers(ctx)
+	for i, member := range nodes {
+		nodes[i] = strings.TrimPrefix(member, rd.key)
+	}
+	return
+}
+
+// GetRawMembers returns the members of the alive nodes as they are stored
+// in the sorted set, with the service prefix.
+func (rd *RedisZSetDriver) GetRawMembers(ctx context.Context) (members []string, err error) {
 	rd.Lock()
 	defer rd.Unlock()
-	sliceCmd := rd.c.ZRangeByScore(ctx, commons.GetKeyPre(rd.serviceName), &redis.ZRangeBy{
+	sliceCmd := rd.c.ZRangeByScore(ctx, rd.key, &redis.ZRangeBy{
 		Min: fmt.Sprintf("%d", commons.TimePre(time.Now(), rd.timeout)),
 		Max: "+inf",
 	})
 	if err = sliceCmd.Err(); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("range service nodes: %w", err)
 	} else {
-		nodes = make([]string, len(sliceCmd.Val()))
-		copy(nodes, sliceCmd.Val())
+		members = make([]string, len(sliceCmd.Val()))
+		copy(members, sliceCmd.Val())
 	}
-	rd.logger.Infof("nodes=%v", nodes)
+	rd.logger.Infof("nodes=%v", members)
 	return
 }
 func (rd *RedisZSetDriver) Start(ctx context.Context) (err error) {
 	rd.Lock()
 	defer rd.Unlock()
 	if rd.started {
-		err = errors.New("this driver is started")
+		err = ErrAlreadyStarted
 		return
 	}
-	rd.runtimeCtx, rd.runtimeCancel = context.WithCancel(context.TODO())
-	rd.started = true
+	if ctx == nil {
+		err = ErrNilContext
+		return
+	}
+	rd.runtimeCtx, rd.runtimeCancel = context.WithCancel(ctx)
 	// register
-	err = rd.registerServiceNode()
+	err = rd.registerServiceNode(rd.runtimeCtx)
 	if err != nil {
 		rd.logger.Errorf("register service error=%v", err)
+		err = fmt.Errorf("register service node: %w", err)
+		rd.runtimeCancel()
 		return
 	}
+	rd.started = true
 	// heartbeat timer
-	go rd.heartBeat()
+	rd.heartbeatDone = make(chan error, 1)
+	go rd.heartBeat(rd.runtimeCtx, rd.heartbeatDone)
 	return
 }
+
+// Stop cancels the heartbeat and waits until the node is deregistered,
+// or ctx is done. The deregister error is returned.
 func (rd *RedisZSetDriver) Stop(ctx context.Context) (err error) {
 	rd.Lock()
-	defer rd.Unlock()
+	if !rd.started || rd.runtimeCancel == nil {
+		rd.Unlock()
+		err = ErrNotStarted
+		return
+	}
 	rd.runtimeCancel()
 	rd.started = false
+	done := rd.heartbeatDone
+	rd.heartbeatDone = nil
+	rd.Unlock()
+
+	if done == nil {
+		// the registration in Start failed, there is no heartbeat.
+		return
+	}
+	select {
+	case err = <-done:
+		if err != nil {
+			err = fmt.Errorf("unregister service node: %w", err)
+		}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
 	return
 }
 
@@ -112,30 +167,38 @@ func (rd *RedisZSetDriver) WithOption(opt commons.Option) (err error) {
 
 // private function
 
-func (rd *RedisZSetDriver) heartBeat() {
+// heartBeat refreshes the node until ctx, the runtime context of the Start
+// that launched it, is done. A later Start replaces rd.runtimeCtx.
+func (rd *RedisZSetDriver) heartBeat(ctx context.Context, done chan<- error) {
 	tick := time.NewTicker(rd.timeout / 2)
 	for {
 		select {
 		case <-tick.C:
 			{
-				if err := rd.registerServiceNode(); err != nil {
+				if err := rd.registerServiceNode(ctx); err != nil {
 					rd.logger.Errorf("register service node error %+v", err)
 				}
 			}
-		case <-rd.runtimeCtx.Done():
+		case <-ctx.Done():
 			{
-				if err := rd.c.Del(context.Background(), rd.nodeID, rd.nodeID).Err(); err != nil {
+				releaseCtx, cancel := context.WithTimeout(context.Background(), rd.timeout)
+				err := rd.c.ZRem(releaseCtx, rd.key, rd.member).Err()
+				if err != nil {
 					rd.logger.Errorf("unregister service node error %+v", err)
 				}
+				cancel()
+				done <- err
 				return
 			}
 		}
 	}
 }
 
-func (rd *RedisZSetDriver) registerServiceNode() error {
-	return rd.c.ZAdd(context.Background(), commons.GetKeyPre(rd.serviceName), redis.Z{
+func (rd *RedisZSetDriver) registerServiceNode(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, rd.timeout)
+	defer cancel()
+	return rd.c.ZAdd(ctx, rd.key, redis.Z{
 		Score:  float64(time.Now().Unix()),
-		Member: rd.nodeID,
+		Member: rd.member,
 	}).Err()
 }
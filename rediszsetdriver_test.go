@@ -2,6 +2,7 @@ package redisdriver_test
 
 import (
 	"context"
+	"strings"
 	"testing"
 	"time"
 
@@ -92,3 +93,64 @@ func TestRedisZSetDriver_Stop(t *testing.T) {
 	drv2.Stop(context.Background())
 	drv1.Stop(context.Background())
 }
+
+func TestRedisZSetDriver_StopWithoutStart(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := testFuncNewRedisZSetDriver(rds.Addr())
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(5*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)))
+
+	require.ErrorIs(t, drv.Stop(context.Background()), redisdriver.ErrNotStarted)
+
+	require.Nil(t, drv.Start(context.Background()))
+	require.Nil(t, drv.Stop(context.Background()))
+	require.ErrorIs(t, drv.Stop(context.Background()), redisdriver.ErrNotStarted)
+}
+
+func TestRedisZSetDriver_StopRemovesMember(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := testFuncNewRedisZSetDriver(rds.Addr())
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(5*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)))
+
+	require.Nil(t, drv.Start(context.Background()))
+	members, err := rds.ZMembers(commons.GetKeyPre(t.Name()))
+	require.Nil(t, err)
+	require.Equal(t, []string{commons.GetKeyPre(t.Name()) + drv.NodeID()}, members)
+
+	require.Nil(t, drv.Stop(context.Background()))
+	require.False(t, rds.Exists(commons.GetKeyPre(t.Name())))
+}
+
+func TestRedisZSetDriver_StartTwice(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := testFuncNewRedisZSetDriver(rds.Addr())
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(5*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)))
+
+	require.Nil(t, drv.Start(context.Background()))
+	require.ErrorIs(t, drv.Start(context.Background()), redisdriver.ErrAlreadyStarted)
+	require.Nil(t, drv.Stop(context.Background()))
+}
+
+func TestRedisZSetDriver_NodeID(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := redisdriver.NewZSetDriver(redis.NewClient(&redis.Options{Addr: rds.Addr()}))
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(5*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)))
+	require.Nil(t, drv.Start(context.Background()))
+	defer drv.Stop(context.Background())
+
+	// the ids are bare like the ones of RedisDriver, the members keep the prefix.
+	require.False(t, strings.HasPrefix(drv.NodeID(), commons.GetKeyPre(t.Name())))
+	nodes, err := drv.GetNodes(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, []string{drv.NodeID()}, nodes)
+	members, err := drv.GetRawMembers(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, []string{commons.GetKeyPre(t.Name()) + drv.NodeID()}, members)
+}
@@ -0,0 +1,183 @@
+package redisdriver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// refreshAndListScript writes the node key KEYS[1] with the value ARGV[1]
+// and the TTL ARGV[2] in milliseconds, then runs one SCAN of ARGV[4] keys
+// matching ARGV[3]. It returns whether the key was missing, the cursor
+// of the SCAN and the keys it found, the caller continues a scan that
+// did not complete. A script never scans the whole keyspace, it would
+// block the server for as long.
+var refreshAndListScript = redis.NewScript(`
+local missing = 0
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	missing = 1
+end
+redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+local res = redis.call("SCAN", "0", "MATCH", ARGV[3], "COUNT", ARGV[4])
+return {missing, res[1], res[2]}`)
+
+// RefreshAndList refreshes the node key and lists the sorted ids of the
+// alive nodes, the list includes this node. The key is written and the
+// first SCAN runs in one script, a small service costs one round trip.
+// The script is loaded again if redis lost it. A node that is not
+// registered, e.g. drained or before the delayed registration claimed its
+// key, is only listed. If redis rejects the script, e.g. scripting is
+// disabled, or the client is a cluster client, where a script only sees
+// one shard, the key is refreshed and the nodes are listed by separate
+// calls. A lost registration is reported like by the heartbeat.
+func (rd *RedisDriver) RefreshAndList(ctx context.Context) (nodes []string, err error) {
+	if !rd.IsStarted() {
+		return nil, ErrNotStarted
+	}
+	if _, ok := rd.c.(*redis.ClusterClient); ok || rd.hashStorage || rd.registerHook != nil || rd.refreshOnly || len(rd.registeredServices()) > 0 {
+		return rd.refreshThenList(ctx)
+	}
+	keys, refreshed, err := rd.refreshAndListKeys(ctx)
+	if !refreshed && err == nil {
+		// not registered, only list
+		return rd.GetNodes(ctx)
+	}
+	var redisErr redis.Error
+	if errors.As(err, &redisErr) {
+		rd.log("refresh").Warnf("refresh and list script error %+v, fall back to separate calls", err)
+		return rd.refreshThenList(ctx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("refresh and list nodes: %w", err)
+	}
+	if rd.staleCleanup > 0 {
+		keys = rd.removeStaleKeys(ctx, keys)
+	}
+	nodes = make([]string, 0, len(keys))
+	for _, key := range keys {
+		if nodeID, ok := rd.scannedNodeID(key); ok {
+			nodes = append(nodes, nodeID)
+		}
+	}
+	sort.Strings(nodes)
+	rd.keepScannedNodes(nodes)
+	if cache := rd.nodeCache; cache != nil {
+		cache.set(copyNodes(nodes), rd.clock.Now())
+	}
+	return nodes, nil
+}
+
+// refreshAndListKeys runs refreshAndListScript and completes its scan,
+// refreshed is false if the node is not registered and the script did
+// not run.
+func (rd *RedisDriver) refreshAndListKeys(ctx context.Context) (keys []string, refreshed bool, err error) {
+	lost, keys, cursor, err := rd.refreshAndScan(ctx)
+	if lost {
+		rd.logCtx(ctx, "refresh").Warnf("registration of node %s is lost", rd.nodeID)
+		if rd.onRegistrationLost != nil {
+			rd.onRegistrationLost()
+		}
+	}
+	if err != nil {
+		return nil, true, err
+	}
+	if keys == nil {
+		return nil, false, nil
+	}
+	if cursor != 0 {
+		if keys, err = rd.continueScan(ctx, keys, cursor); err != nil {
+			return nil, true, err
+		}
+	}
+	return keys, true, nil
+}
+
+// refreshAndScan writes the node key by the script and returns the keys
+// of the first SCAN, keys is nil if the node is not registered. Like
+// writeServiceNode it reports whether the key of a registered node was
+// missing.
+func (rd *RedisDriver) refreshAndScan(ctx context.Context) (lost bool, keys []string, cursor uint64, err error) {
+	rd.registerMu.Lock()
+	defer rd.registerMu.Unlock()
+	if rd.drained || rd.draining || !rd.registered {
+		return false, nil, 0, nil
+	}
+	value, err := rd.nodeValue()
+	if err != nil {
+		return false, nil, 0, err
+	}
+	timeout := rd.getTimeout()
+	ctx, cancel := context.WithTimeout(ctx, rd.getCommandTimeout())
+	defer cancel()
+	ctx, span := rd.startSpan(ctx, "redisdriver.refresh_and_list", "EVALSHA")
+	defer func() { endSpan(span, err) }()
+	res, err := refreshAndListScript.Run(ctx, rd.c, []string{rd.nodeKey(rd.nodeID)},
+		value, timeout.Milliseconds(), rd.nodeMatch(), rd.scanCount).Slice()
+	if err != nil {
+		return false, nil, 0, err
+	}
+	missing, cursor, keys, err := parseRefreshAndList(res)
+	if err != nil {
+		return false, nil, 0, err
+	}
+	rd.markRegistered()
+	rd.extendGeneration(ctx)
+	return missing, keys, cursor, nil
+}
+func (rd *RedisDriver) refreshThenList(ctx context.Context) ([]string, error) {
+	if err := rd.registerServiceNode(ctx); err != nil {
+		return nil, fmt.Errorf("register service node: %w", err)
+	}
+	return rd.GetNodes(ctx)
+}
+
+// continueScan continues the SCAN of the script from cursor, the keys
+// that SCAN returns twice are kept once.
+func (rd *RedisDriver) continueScan(ctx context.Context, keys []string, cursor uint64) (ret []string, err error) {
+	ctx, cancel := context.WithTimeout(ctx, rd.getCommandTimeout())
+	defer cancel()
+	ctx, span := rd.startSpan(ctx, "redisdriver.scan", "SCAN")
+	defer func() { endSpan(span, err) }()
+	seen := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		seen[key] = struct{}{}
+	}
+	iter := rd.c.Scan(ctx, cursor, rd.nodeMatch(), rd.scanCount).Iterator()
+	for iter.Next(ctx) {
+		if _, ok := seen[iter.Val()]; !ok {
+			seen[iter.Val()] = struct{}{}
+			keys = append(keys, iter.Val())
+		}
+	}
+	if err = iter.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// parseRefreshAndList reads the reply of refreshAndListScript.
+func parseRefreshAndList(res []interface{}) (missing bool, cursor uint64, keys []string, err error) {
+	if len(res) != 3 {
+		return false, 0, nil, fmt.Errorf("refresh and list script: unexpected reply %v", res)
+	}
+	flag, ok := res[0].(int64)
+	cursorStr, ok2 := res[1].(string)
+	found, ok3 := res[2].([]interface{})
+	if !ok || !ok2 || !ok3 {
+		return false, 0, nil, fmt.Errorf("refresh and list script: unexpected reply %v", res)
+	}
+	if cursor, err = strconv.ParseUint(cursorStr, 10, 64); err != nil {
+		return false, 0, nil, fmt.Errorf("refresh and list script: cursor %q: %w", cursorStr, err)
+	}
+	keys = make([]string, 0, len(found))
+	for _, key := range found {
+		if key, ok := key.(string); ok {
+			keys = append(keys, key)
+		}
+	}
+	return flag == 1, cursor, keys, nil
+}
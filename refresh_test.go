@@ -0,0 +1,155 @@
+package redisdriver_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dcron-contrib/commons"
+	"github.com/dcron-contrib/commons/dlog"
+	"github.com/dcron-contrib/redisdriver"
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+// testRedisError is an error reply of the server.
+type testRedisError string
+
+func (e testRedisError) Error() string { return string(e) }
+func (e testRedisError) RedisError()   {}
+
+func TestRedisDriver_RefreshAndList(t *testing.T) {
+	rds := miniredis.RunT(t)
+	var scripts int32
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{
+		process: func(ctx context.Context, cmd redis.Cmder, next redis.ProcessHook) error {
+			if cmd.Name() == "evalsha" || cmd.Name() == "eval" {
+				atomic.AddInt32(&scripts, 1)
+			}
+			return next(ctx, cmd)
+		},
+	})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(2*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)))
+	_, err := drv.RefreshAndList(context.Background())
+	require.ErrorIs(t, err, redisdriver.ErrNotStarted)
+
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+	require.Nil(t, rds.Set(testFuncNodeKey(t.Name(), "node-1"), "{}"))
+	key := testFuncNodeKey(t.Name(), drv.NodeID())
+	rds.FastForward(time.Second)
+
+	nodes, err := drv.RefreshAndList(context.Background())
+	require.Nil(t, err)
+	require.ElementsMatch(t, []string{drv.NodeID(), "node-1"}, nodes)
+	require.Equal(t, 2*time.Second, rds.TTL(key))
+	require.Greater(t, atomic.LoadInt32(&scripts), int32(0))
+
+	// the script is loaded again after a flush of the script cache.
+	rds.Del(key)
+	require.Nil(t, drv.Client().ScriptFlush(context.Background()).Err())
+	nodes, err = drv.RefreshAndList(context.Background())
+	require.Nil(t, err)
+	require.ElementsMatch(t, []string{drv.NodeID(), "node-1"}, nodes)
+
+	// a drained node is listed only while its key lives.
+	require.Nil(t, drv.Unregister(context.Background()))
+	nodes, err = drv.RefreshAndList(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, []string{"node-1"}, nodes)
+	require.Nil(t, drv.Register(context.Background()))
+}
+
+func TestRedisDriver_RefreshAndListFallback(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{
+		process: func(ctx context.Context, cmd redis.Cmder, next redis.ProcessHook) error {
+			if cmd.Name() == "evalsha" || cmd.Name() == "eval" {
+				cmd.SetErr(testRedisError("ERR scripting is disabled"))
+				return cmd.Err()
+			}
+			return next(ctx, cmd)
+		},
+	})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(2*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+	key := testFuncNodeKey(t.Name(), drv.NodeID())
+	rds.Del(key)
+
+	nodes, err := drv.RefreshAndList(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, []string{drv.NodeID()}, nodes)
+	require.Equal(t, 2*time.Second, rds.TTL(key))
+}
+
+func TestRedisDriver_RefreshAndListBounded(t *testing.T) {
+	rds := miniredis.RunT(t)
+	var lost int32
+	drv := testFuncNewRedisDriver(rds.Addr()).(*redisdriver.RedisDriver)
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(2*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithScanCount(2),
+		redisdriver.WithNodeCache(time.Minute),
+		redisdriver.WithOnRegistrationLost(func() { atomic.AddInt32(&lost, 1) }))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+	want := []string{drv.NodeID()}
+	for _, nodeID := range []string{"node-1", "node-2", "node-3", "node-4", "node-5"} {
+		require.Nil(t, rds.Set(testFuncNodeKey(t.Name(), nodeID), "{}"))
+		want = append(want, nodeID)
+	}
+	require.Nil(t, rds.Set("unrelated", "1"))
+
+	// the script scans 2 keys, the rest of the scan follows it.
+	nodes, err := drv.RefreshAndList(context.Background())
+	require.Nil(t, err)
+	require.ElementsMatch(t, want, nodes)
+	require.Equal(t, int32(0), atomic.LoadInt32(&lost))
+
+	// a lost key is reported and the cache keeps the list.
+	rds.Del(testFuncNodeKey(t.Name(), drv.NodeID()))
+	nodes, err = drv.RefreshAndList(context.Background())
+	require.Nil(t, err)
+	require.ElementsMatch(t, want, nodes)
+	require.Equal(t, int32(1), atomic.LoadInt32(&lost))
+	rds.Del(testFuncNodeKey(t.Name(), "node-1"))
+	nodes, err = drv.GetNodes(context.Background())
+	require.Nil(t, err)
+	require.ElementsMatch(t, want, nodes)
+}
+
+func TestRedisDriver_RefreshAndListDelayed(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := testFuncNewRedisDriver(rds.Addr()).(*redisdriver.RedisDriver)
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(2*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithInitialRegisterDelay(time.Hour))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+	require.Nil(t, rds.Set(testFuncNodeKey(t.Name(), "node-1"), "{}"))
+
+	// the key is claimed by the delayed registration, not by the script.
+	nodes, err := drv.RefreshAndList(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, []string{"node-1"}, nodes)
+	require.False(t, rds.Exists(testFuncNodeKey(t.Name(), drv.NodeID())))
+}
+
+// BenchmarkRedisDriver_RefreshAndList refreshes the node key and lists
+// the nodes, by one script with the key per node storage. The hash
+// storage falls back to a heartbeat and a list.
+func BenchmarkRedisDriver_RefreshAndList(b *testing.B) {
+	benchmarkFuncDiscovery(b, true, func(ctx context.Context, drv *redisdriver.RedisDriver) error {
+		_, err := drv.RefreshAndList(ctx)
+		return err
+	})
+}
@@ -0,0 +1,51 @@
+package redisdriver
+
+import (
+	"fmt"
+
+	"github.com/dcron-contrib/commons"
+	redis "github.com/redis/go-redis/v9"
+)
+
+// SentinelOptions are the settings of NewSentinelDriver.
+type SentinelOptions struct {
+	// Username and Password authenticate against the redis servers,
+	// Username is the ACL user of redis 6 or later.
+	Username string
+	Password string
+	// SentinelUsername and SentinelPassword authenticate against the sentinels.
+	SentinelUsername string
+	SentinelPassword string
+	// DB is the database of the node keys.
+	DB int
+	// Options are applied to the driver.
+	Options []commons.Option
+}
+
+// NewSentinelDriver creates a driver on a failover client, which sends the
+// heartbeat to the master that the sentinels report for masterName.
+func NewSentinelDriver(masterName string, sentinelAddrs []string, opts SentinelOptions) (*RedisDriver, error) {
+	if masterName == "" {
+		return nil, fmt.Errorf("%w: sentinel master name must not be empty", ErrInvalidOption)
+	}
+	if len(sentinelAddrs) == 0 {
+		return nil, fmt.Errorf("%w: sentinel addresses must not be empty", ErrInvalidOption)
+	}
+	client := redis.NewFailoverClient(&redis.FailoverOptions{
+		MasterName:       masterName,
+		SentinelAddrs:    sentinelAddrs,
+		SentinelUsername: opts.SentinelUsername,
+		SentinelPassword: opts.SentinelPassword,
+		Username:         opts.Username,
+		Password:         opts.Password,
+		DB:               opts.DB,
+	})
+	rd := NewDriver(client)
+	for _, opt := range opts.Options {
+		if err := rd.WithOption(opt); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	return rd, nil
+}
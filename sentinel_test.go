@@ -0,0 +1,38 @@
+package redisdriver_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dcron-contrib/commons"
+	"github.com/dcron-contrib/redisdriver"
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSentinelDriver(t *testing.T) {
+	drv, err := redisdriver.NewSentinelDriver("mymaster", []string{"127.0.0.1:26379"}, redisdriver.SentinelOptions{
+		Username: "cron",
+		Password: "secret",
+		DB:       2,
+		Options:  []commons.Option{commons.NewTimeoutOption(2 * time.Second)},
+	})
+	require.Nil(t, err)
+	defer drv.Client().Close()
+	client, ok := drv.Client().(*redis.Client)
+	require.True(t, ok)
+	require.Equal(t, "cron", client.Options().Username)
+	require.Equal(t, "secret", client.Options().Password)
+	require.Equal(t, 2, client.Options().DB)
+}
+
+func TestNewSentinelDriverInvalid(t *testing.T) {
+	_, err := redisdriver.NewSentinelDriver("", []string{"127.0.0.1:26379"}, redisdriver.SentinelOptions{})
+	require.ErrorIs(t, err, redisdriver.ErrInvalidOption)
+	_, err = redisdriver.NewSentinelDriver("mymaster", nil, redisdriver.SentinelOptions{})
+	require.ErrorIs(t, err, redisdriver.ErrInvalidOption)
+	_, err = redisdriver.NewSentinelDriver("mymaster", []string{"127.0.0.1:26379"}, redisdriver.SentinelOptions{
+		Options: []commons.Option{redisdriver.WithScanCount(0)},
+	})
+	require.ErrorIs(t, err, redisdriver.ErrInvalidOption)
+}
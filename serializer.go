@@ -0,0 +1,48 @@
+package redisdriver
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/dcron-contrib/commons"
+)
+
+// Serializer encodes the NodeInfo stored as the value of a node key.
+// All nodes of a service must use the same Serializer.
+type Serializer interface {
+	Marshal(info NodeInfo) ([]byte, error)
+	Unmarshal(data []byte) (NodeInfo, error)
+}
+
+// JSONSerializer is the default Serializer.
+type JSONSerializer struct{}
+
+func (JSONSerializer) Marshal(info NodeInfo) ([]byte, error) {
+	return json.Marshal(info)
+}
+
+func (JSONSerializer) Unmarshal(data []byte) (info NodeInfo, err error) {
+	err = json.Unmarshal(data, &info)
+	return
+}
+
+// private function
+
+// decodeNodeValue decodes the value of the node key of nodeID. Drivers
+// before NodeInfo stored the bare node id, such a value decodes to a
+// NodeInfo with the ID only.
+func (rd *RedisDriver) decodeNodeValue(nodeID, key, value string) (NodeInfo, error) {
+	if value == nodeID || value == key || value == commons.GetKeyPre(rd.serviceName)+nodeID {
+		return NodeInfo{ID: nodeID}, nil
+	}
+	data, err := decompressNodeValue([]byte(value))
+	if err != nil {
+		return NodeInfo{}, err
+	}
+	info, err := rd.serializer.Unmarshal(data)
+	if err != nil {
+		return info, fmt.Errorf("decode node info: %w", err)
+	}
+	info.ID = nodeID
+	return info, nil
+}
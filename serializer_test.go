@@ -0,0 +1,134 @@
+package redisdriver_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dcron-contrib/commons"
+	"github.com/dcron-contrib/commons/dlog"
+	"github.com/dcron-contrib/redisdriver"
+	"github.com/stretchr/testify/require"
+)
+
+// testBase64Serializer stands for a custom encoding, e.g. an encrypted one.
+type testBase64Serializer struct {
+	marshals int32
+}
+
+func (s *testBase64Serializer) Marshal(info redisdriver.NodeInfo) ([]byte, error) {
+	atomic.AddInt32(&s.marshals, 1)
+	value, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(base64.StdEncoding.EncodeToString(value)), nil
+}
+
+func (s *testBase64Serializer) Unmarshal(data []byte) (info redisdriver.NodeInfo, err error) {
+	value, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(value, &info)
+	return
+}
+
+func TestJSONSerializer(t *testing.T) {
+	info := redisdriver.NodeInfo{
+		ID:           "node-1",
+		Hostname:     "host-1",
+		PID:          42,
+		RegisteredAt: time.Now().UTC().Truncate(time.Millisecond),
+		Labels:       map[string]string{"region": "eu"},
+		Weight:       4,
+		State:        redisdriver.NodeBusy,
+	}
+	value, err := redisdriver.JSONSerializer{}.Marshal(info)
+	require.Nil(t, err)
+	decoded, err := redisdriver.JSONSerializer{}.Unmarshal(value)
+	require.Nil(t, err)
+	require.Equal(t, info, decoded)
+
+	_, err = redisdriver.JSONSerializer{}.Unmarshal([]byte("not json"))
+	require.NotNil(t, err)
+}
+
+func TestRedisDriver_Serializer(t *testing.T) {
+	rds := miniredis.RunT(t)
+	serializer := &testBase64Serializer{}
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(5*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithNodeMetadata(map[string]string{"region": "eu"}),
+		redisdriver.WithSerializer(serializer))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+
+	value, err := rds.Get(testFuncNodeKey(t.Name(), drv.NodeID()))
+	require.Nil(t, err)
+	_, err = base64.StdEncoding.DecodeString(value)
+	require.Nil(t, err)
+	require.Greater(t, atomic.LoadInt32(&serializer.marshals), int32(0))
+
+	nodes, err := drv.GetNodesWithMeta(context.Background())
+	require.Nil(t, err)
+	require.Len(t, nodes, 1)
+	require.Equal(t, drv.NodeID(), nodes[0].ID)
+	require.Equal(t, map[string]string{"region": "eu"}, nodes[0].Labels)
+
+	self, ok, err := drv.GetSelfNode(context.Background())
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.Equal(t, nodes[0].Instance, self.Instance)
+
+	require.ErrorIs(t, drv.WithOption(redisdriver.WithSerializer(nil)), redisdriver.ErrInvalidOption)
+}
+
+type testFailingSerializer struct{ redisdriver.JSONSerializer }
+
+func (testFailingSerializer) Marshal(redisdriver.NodeInfo) ([]byte, error) {
+	return nil, errors.New("encode failed")
+}
+
+func TestRedisDriver_SerializerError(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name(),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithSerializer(testFailingSerializer{}))
+	require.NotNil(t, drv.Start(context.Background()))
+}
+
+func TestRedisDriver_LegacyNodeValue(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(5*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+
+	// older drivers stored the node id as the value.
+	legacyKey := testFuncNodeKey(t.Name(), "legacy-node")
+	require.Nil(t, rds.Set(legacyKey, legacyKey))
+	shortKey := testFuncNodeKey(t.Name(), "short-node")
+	require.Nil(t, rds.Set(shortKey, "short-node"))
+
+	nodes, err := drv.GetNodesWithMeta(context.Background())
+	require.Nil(t, err)
+	require.Len(t, nodes, 3)
+	byID := make(map[string]redisdriver.NodeInfo)
+	for _, node := range nodes {
+		byID[node.ID] = node
+	}
+	require.Equal(t, redisdriver.NodeInfo{ID: "legacy-node"}, byID["legacy-node"])
+	require.Equal(t, redisdriver.NodeInfo{ID: "short-node"}, byID["short-node"])
+	require.Equal(t, drv.NodeID(), byID[drv.NodeID()].ID)
+}
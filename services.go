@@ -0,0 +1,183 @@
+package redisdriver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dcron-contrib/commons"
+	redis "github.com/redis/go-redis/v9"
+)
+
+// ListServices returns the sorted names of the services that have alive
+// nodes in redis. It reads the default key layout under the key prefix and
+// cluster mode of this driver, keys of a custom KeyBuilder are not found.
+// Keys that are no node keys, e.g. the leader key, are ignored.
+func (rd *RedisDriver) ListServices(ctx context.Context) (services []string, err error) {
+	// "distributed-cron:"
+	namespace := strings.TrimSuffix(commons.GetKeyPre(""), ":")
+	prefix, separator := rd.keyPrefix+namespace, ":"
+	if rd.clusterMode {
+		prefix, separator = rd.keyPrefix+"{"+namespace, "}:"
+	}
+	seen := make(map[string]struct{})
+	err = rd.scanEach(ctx, prefix+"*", func(key string) {
+		if service, ok := serviceFromKey(strings.TrimPrefix(key, prefix), separator); ok {
+			seen[service] = struct{}{}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	services = make([]string, 0, len(seen))
+	for service := range seen {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	return services, nil
+}
+
+// serviceFromKey parses "<service><separator><node id>". The node id
+// holds no ':', and the service keys, which hold '@', are no node keys.
+func serviceFromKey(rest, separator string) (string, bool) {
+	i := strings.LastIndex(rest, separator)
+	if i <= 0 || strings.Contains(rest, "@") {
+		return "", false
+	}
+	service, nodeID := rest[:i], rest[i+len(separator):]
+	if nodeID == "" || strings.Contains(nodeID, ":") {
+		return "", false
+	}
+	return service, true
+}
+
+// RegisterService registers this node in service as well, with the same
+// node ID and value as in the service of Init, so a process running
+// several services needs one driver. The node key of every registered
+// service is refreshed by the heartbeat of the driver, in one pipeline
+// after the node key of the own service, and removed by Unregister and
+// Stop. On a started driver the key is written right away, otherwise by
+// Start. It does not work with WithHashStorage.
+func (rd *RedisDriver) RegisterService(ctx context.Context, service string) error {
+	if err := rd.checkService(service); err != nil {
+		return err
+	}
+	rd.servicesMu.Lock()
+	if rd.services == nil {
+		rd.services = make(map[string]struct{})
+	}
+	rd.services[service] = struct{}{}
+	rd.servicesMu.Unlock()
+	if !rd.IsStarted() {
+		return nil
+	}
+	rd.registerMu.Lock()
+	defer rd.registerMu.Unlock()
+	if rd.drained || rd.draining {
+		return nil
+	}
+	value, err := rd.nodeValue()
+	if err != nil {
+		return err
+	}
+	timeout := rd.getTimeout()
+	ctx, cancel := context.WithTimeout(ctx, rd.getCommandTimeout())
+	defer cancel()
+	if err := rd.writeServiceKeys(ctx, []string{service}, value, timeout); err != nil {
+		return fmt.Errorf("register service %s: %w", service, err)
+	}
+	return nil
+}
+
+// UnregisterService removes this node from a service of RegisterService,
+// its node key is deleted right away on a started driver.
+func (rd *RedisDriver) UnregisterService(ctx context.Context, service string) error {
+	rd.servicesMu.Lock()
+	_, ok := rd.services[service]
+	delete(rd.services, service)
+	rd.servicesMu.Unlock()
+	if !ok || !rd.IsStarted() {
+		return nil
+	}
+	rd.registerMu.Lock()
+	defer rd.registerMu.Unlock()
+	ctx, cancel := context.WithTimeout(ctx, rd.getCommandTimeout())
+	defer cancel()
+	if err := rd.c.Del(ctx, rd.nodeKeyOf(service, rd.nodeID)).Err(); err != nil {
+		return fmt.Errorf("unregister service %s: %w", service, err)
+	}
+	return nil
+}
+
+// GetServiceNodes returns the sorted ids of the alive nodes of service,
+// like GetNodes for the service of Init. The keys of service are scanned
+// in the key layout of this driver.
+func (rd *RedisDriver) GetServiceNodes(ctx context.Context, service string) (nodes []string, err error) {
+	if service == rd.serviceName {
+		return rd.GetNodes(ctx)
+	}
+	if rd.hashStorage {
+		return nil, fmt.Errorf("%w: nodes of other services need the key per node storage", ErrInvalidOption)
+	}
+	if service == "" {
+		return nil, ErrEmptyServiceName
+	}
+	match := rd.nodeKeyOf(service, "*")
+	nodes = make([]string, 0)
+	seen := make(map[string]struct{})
+	err = rd.scanEach(ctx, match, func(key string) {
+		if nodeID, ok := nodeIDFromMatch(key, match); ok {
+			if _, dup := seen[nodeID]; !dup {
+				seen[nodeID] = struct{}{}
+				nodes = append(nodes, nodeID)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(nodes)
+	return nodes, nil
+}
+
+func (rd *RedisDriver) checkService(service string) error {
+	switch {
+	case service == "":
+		return ErrEmptyServiceName
+	case service == rd.serviceName:
+		return fmt.Errorf("%w: node is registered in service %s by Init", ErrInvalidOption, service)
+	case rd.hashStorage:
+		return fmt.Errorf("%w: services need the key per node storage", ErrInvalidOption)
+	}
+	return checkMatchPattern(rd.nodeKeyOf(service, "*"))
+}
+
+// registeredServices returns the services of RegisterService.
+func (rd *RedisDriver) registeredServices() []string {
+	rd.servicesMu.Lock()
+	defer rd.servicesMu.Unlock()
+	if len(rd.services) == 0 {
+		return nil
+	}
+	services := make([]string, 0, len(rd.services))
+	for service := range rd.services {
+		services = append(services, service)
+	}
+	return services
+}
+
+// writeServiceKeys writes the node keys of services in one pipeline,
+// registerMu must be held.
+func (rd *RedisDriver) writeServiceKeys(ctx context.Context, services []string, value string, timeout time.Duration) (err error) {
+	ctx, span := rd.startSpan(ctx, "redisdriver.register_services", "SETEX")
+	defer func() { endSpan(span, err) }()
+	_, err = rd.c.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, service := range services {
+			pipe.SetEx(ctx, rd.nodeKeyOf(service, rd.nodeID), value, timeout)
+		}
+		return nil
+	})
+	return err
+}
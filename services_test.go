@@ -0,0 +1,148 @@
+package redisdriver_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dcron-contrib/commons"
+	"github.com/dcron-contrib/commons/dlog"
+	"github.com/dcron-contrib/redisdriver"
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisDriver_ListServices(t *testing.T) {
+	rds := miniredis.RunT(t)
+	for _, svc := range []string{"billing", "mail", "billing"} {
+		drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+		drv.Init(svc, commons.NewLoggerOption(dlog.NewLoggerForTest(t)))
+		require.Nil(t, drv.Start(context.Background()))
+		defer testFuncStop(t, rds, drv)
+	}
+	leader := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	leader.Init("reports", commons.NewLoggerOption(dlog.NewLoggerForTest(t)))
+	require.Nil(t, leader.Start(context.Background()))
+	ok, err := leader.TryAcquireLeadership(context.Background())
+	require.Nil(t, err)
+	require.True(t, ok)
+	// the leader key and the generation counter are left after Stop.
+	testFuncStop(t, rds, leader)
+	require.Nil(t, rds.Set(commons.GetKeyPre("reports")+"@leader", "x"))
+	// malformed keys of the namespace
+	require.Nil(t, rds.Set("distributed-cron:", "x"))
+	require.Nil(t, rds.Set("distributed-cron:orphan", "x"))
+	require.Nil(t, rds.Set("distributed-cron:broken:", "x"))
+	require.Nil(t, rds.Set("other:svc:node", "x"))
+	// service names may hold ':'
+	require.Nil(t, rds.Set(testFuncNodeKey("team:jobs", "node-1"), "x"))
+
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name())
+	services, err := drv.ListServices(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, []string{"billing", "mail", "team:jobs"}, services)
+
+	cluster := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	cluster.Init("hashed", redisdriver.WithClusterMode())
+	require.Nil(t, cluster.Start(context.Background()))
+	defer cluster.Stop(context.Background())
+	services, err = cluster.ListServices(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, []string{"hashed"}, services)
+}
+
+func TestRedisDriver_RegisterService(t *testing.T) {
+	rds := miniredis.RunT(t)
+	ctx := context.Background()
+	multi := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	multi.Init("billing",
+		commons.NewTimeoutOption(2*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithClock(newTestClock()))
+	require.Nil(t, multi.RegisterService(ctx, "mail"))
+	mail := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	mail.Init("mail", commons.NewLoggerOption(dlog.NewLoggerForTest(t)))
+	require.Nil(t, multi.Start(ctx))
+	require.Nil(t, mail.Start(ctx))
+	defer mail.Stop(ctx)
+
+	// the services have their own membership.
+	nodes, err := multi.GetServiceNodes(ctx, "mail")
+	require.Nil(t, err)
+	require.ElementsMatch(t, []string{multi.NodeID(), mail.NodeID()}, nodes)
+	nodes, err = mail.GetNodes(ctx)
+	require.Nil(t, err)
+	require.ElementsMatch(t, []string{multi.NodeID(), mail.NodeID()}, nodes)
+	nodes, err = mail.GetServiceNodes(ctx, "billing")
+	require.Nil(t, err)
+	require.Equal(t, []string{multi.NodeID()}, nodes)
+	nodes, err = multi.GetServiceNodes(ctx, "billing")
+	require.Nil(t, err)
+	require.Equal(t, []string{multi.NodeID()}, nodes)
+
+	// a service registered on a started driver is written right away and
+	// refreshed by the heartbeat with the own service.
+	require.Nil(t, multi.RegisterService(ctx, "reports"))
+	reportsKey := testFuncNodeKey("reports", multi.NodeID())
+	mailKey := testFuncNodeKey("mail", multi.NodeID())
+	require.True(t, rds.Exists(reportsKey))
+	rds.FastForward(time.Second)
+	require.Nil(t, multi.Tick(ctx))
+	require.Equal(t, 2*time.Second, rds.TTL(reportsKey))
+	require.Equal(t, 2*time.Second, rds.TTL(mailKey))
+
+	require.Nil(t, multi.UnregisterService(ctx, "mail"))
+	require.False(t, rds.Exists(mailKey))
+	nodes, err = mail.GetNodes(ctx)
+	require.Nil(t, err)
+	require.Equal(t, []string{mail.NodeID()}, nodes)
+	require.Nil(t, multi.Tick(ctx))
+	require.False(t, rds.Exists(mailKey))
+	services, err := mail.ListServices(ctx)
+	require.Nil(t, err)
+	require.Equal(t, []string{"billing", "mail", "reports"}, services)
+
+	// Stop removes the keys of all services.
+	require.Nil(t, multi.Stop(ctx))
+	require.False(t, rds.Exists(reportsKey))
+	require.False(t, rds.Exists(testFuncNodeKey("billing", multi.NodeID())))
+
+	require.ErrorIs(t, multi.RegisterService(ctx, ""), redisdriver.ErrEmptyServiceName)
+	require.ErrorIs(t, multi.RegisterService(ctx, "billing"), redisdriver.ErrInvalidOption)
+	hash := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	hash.Init("billing", redisdriver.WithHashStorage())
+	require.ErrorIs(t, hash.RegisterService(ctx, "mail"), redisdriver.ErrInvalidOption)
+}
+
+func TestRedisDriver_UnregisterServiceTimeout(t *testing.T) {
+	rds := miniredis.RunT(t)
+	var slow atomic.Bool
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{
+		process: func(ctx context.Context, cmd redis.Cmder, next redis.ProcessHook) error {
+			if slow.Load() && cmd.Name() == "del" {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(5 * time.Second):
+				}
+			}
+			return next(ctx, cmd)
+		},
+	})
+	drv.Init(t.Name(),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithCommandTimeout(50*time.Millisecond))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+	require.Nil(t, drv.RegisterService(context.Background(), "billing"))
+
+	// the delete is cut off at the command timeout like every other write.
+	slow.Store(true)
+	begin := time.Now()
+	require.ErrorIs(t, drv.UnregisterService(context.Background(), "billing"), context.DeadlineExceeded)
+	require.Less(t, time.Since(begin), time.Second)
+	slow.Store(false)
+}
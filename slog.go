@@ -0,0 +1,60 @@
+//go:build go1.21
+
+package redisdriver
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/dcron-contrib/commons"
+	"github.com/dcron-contrib/commons/dlog"
+)
+
+// SlogLogger adapts a *slog.Logger to a FieldLogger, ContextLogger and
+// DebugLogger. Debugf logs at slog.LevelDebug, Infof and Printf at
+// slog.LevelInfo, Warnf at slog.LevelWarn and
+// Errorf at slog.LevelError, the fields of the driver become attributes
+// and the context is passed to the handler.
+type SlogLogger struct {
+	l   *slog.Logger
+	ctx context.Context
+}
+
+// NewSlogLogger returns a SlogLogger of l, slog.Default() if l is nil.
+func NewSlogLogger(l *slog.Logger) *SlogLogger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return &SlogLogger{l: l, ctx: context.Background()}
+}
+
+// WithSlog makes the driver log to l.
+func WithSlog(l *slog.Logger) commons.LoggerOption {
+	return commons.NewLoggerOption(NewSlogLogger(l))
+}
+
+func (sl *SlogLogger) Printf(format string, args ...any) { sl.log(slog.LevelInfo, format, args) }
+func (sl *SlogLogger) Debugf(format string, args ...any) { sl.log(slog.LevelDebug, format, args) }
+func (sl *SlogLogger) Infof(format string, args ...any)  { sl.log(slog.LevelInfo, format, args) }
+func (sl *SlogLogger) Warnf(format string, args ...any)  { sl.log(slog.LevelWarn, format, args) }
+func (sl *SlogLogger) Errorf(format string, args ...any) { sl.log(slog.LevelError, format, args) }
+
+func (sl *SlogLogger) WithFields(fields ...Field) dlog.Logger {
+	attrs := make([]any, 0, len(fields))
+	for _, field := range fields {
+		attrs = append(attrs, slog.Any(field.Key, field.Value))
+	}
+	return &SlogLogger{l: sl.l.With(attrs...), ctx: sl.ctx}
+}
+
+func (sl *SlogLogger) WithContext(ctx context.Context) dlog.Logger {
+	return &SlogLogger{l: sl.l, ctx: ctx}
+}
+
+func (sl *SlogLogger) log(level slog.Level, format string, args []any) {
+	if !sl.l.Enabled(sl.ctx, level) {
+		return
+	}
+	sl.l.Log(sl.ctx, level, fmt.Sprintf(format, args...))
+}
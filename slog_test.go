@@ -0,0 +1,81 @@
+//go:build go1.21
+
+package redisdriver_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dcron-contrib/commons"
+	"github.com/dcron-contrib/redisdriver"
+	"github.com/stretchr/testify/require"
+)
+
+// testSyncBuffer is written by the heartbeat goroutine and read by the test.
+type testSyncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *testSyncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *testSyncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRedisDriver_WithSlog(t *testing.T) {
+	rds := miniredis.RunT(t)
+	out := &testSyncBuffer{}
+	clock := newTestClock()
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(time.Second),
+		redisdriver.WithSlog(slog.New(slog.NewJSONHandler(out, nil))),
+		redisdriver.WithMaxRetries(0),
+		redisdriver.WithClock(clock))
+	require.Nil(t, drv.Start(context.Background()))
+	ticker := clock.nextTicker(t)
+
+	rds.SetError("heartbeat failed")
+	ticker.tick()
+	var record map[string]any
+	require.Eventually(t, func() bool {
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			if err := json.Unmarshal([]byte(line), &record); err == nil && record["level"] == "ERROR" {
+				return true
+			}
+		}
+		return false
+	}, time.Second, time.Millisecond)
+	rds.SetError("")
+	require.Contains(t, record["msg"], "heartbeat failed")
+	require.Equal(t, t.Name(), record["service"])
+	require.Equal(t, drv.NodeID(), record["node_id"])
+	require.Equal(t, "heartbeat", record["op"])
+	testFuncStop(t, rds, drv)
+}
+
+func TestNewSlogLogger_Levels(t *testing.T) {
+	out := &testSyncBuffer{}
+	l := redisdriver.NewSlogLogger(slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{Level: slog.LevelWarn})))
+	l.Debugf("hidden %d", 0)
+	l.Infof("hidden %d", 1)
+	l.Warnf("shown %d", 2)
+	l.Errorf("shown %d", 3)
+	require.NotContains(t, out.String(), "hidden")
+	require.Contains(t, out.String(), `level=WARN msg="shown 2"`)
+	require.Contains(t, out.String(), `level=ERROR msg="shown 3"`)
+}
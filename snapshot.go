@@ -0,0 +1,68 @@
+package redisdriver
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// NodeSnapshot is the node list of one scan, its methods do not call
+// redis, so related reads within a scheduler tick agree with each other.
+type NodeSnapshot struct {
+	ids     []string
+	takenAt time.Time
+}
+
+// Snapshot scans the nodes once and returns them as a snapshot. With
+// WithConsistentSnapshot GetNodes and GetNodeCount return the latest
+// snapshot as well, until the next Snapshot call or for one heartbeat
+// interval.
+func (rd *RedisDriver) Snapshot(ctx context.Context) (*NodeSnapshot, error) {
+	ids, err := rd.scanNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &NodeSnapshot{ids: ids, takenAt: rd.clock.Now()}
+	if rd.consistentSnapshot {
+		rd.snapshotMu.Lock()
+		rd.snapshot = snapshot
+		rd.snapshotMu.Unlock()
+	}
+	return snapshot, nil
+}
+
+// IDs returns the sorted ids of the nodes.
+func (s *NodeSnapshot) IDs() []string {
+	return copyNodes(s.ids)
+}
+
+// Count returns the number of nodes.
+func (s *NodeSnapshot) Count() int {
+	return len(s.ids)
+}
+
+// Has reports whether the node id is in the snapshot.
+func (s *NodeSnapshot) Has(id string) bool {
+	i := sort.SearchStrings(s.ids, id)
+	return i < len(s.ids) && s.ids[i] == id
+}
+
+// TakenAt returns the time of the scan.
+func (s *NodeSnapshot) TakenAt() time.Time {
+	return s.takenAt
+}
+
+// currentSnapshot returns the snapshot GetNodes and GetNodeCount answer
+// from, nil if there is none or it is older than the heartbeat interval.
+func (rd *RedisDriver) currentSnapshot() *NodeSnapshot {
+	if !rd.consistentSnapshot {
+		return nil
+	}
+	rd.snapshotMu.Lock()
+	snapshot := rd.snapshot
+	rd.snapshotMu.Unlock()
+	if snapshot == nil || rd.clock.Now().Sub(snapshot.takenAt) >= rd.effectiveHeartbeatInterval() {
+		return nil
+	}
+	return snapshot
+}
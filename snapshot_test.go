@@ -0,0 +1,107 @@
+package redisdriver_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dcron-contrib/commons"
+	"github.com/dcron-contrib/commons/dlog"
+	"github.com/dcron-contrib/redisdriver"
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisDriver_Snapshot(t *testing.T) {
+	rds := miniredis.RunT(t)
+	var scans int32
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{
+		process: func(ctx context.Context, cmd redis.Cmder, next redis.ProcessHook) error {
+			if cmd.Name() == "scan" {
+				atomic.AddInt32(&scans, 1)
+			}
+			return next(ctx, cmd)
+		},
+	})
+	drv.Init(t.Name(), commons.NewLoggerOption(dlog.NewLoggerForTest(t)))
+	for _, nodeID := range []string{"node-2", "node-1", "node-3"} {
+		require.Nil(t, rds.Set(testFuncNodeKey(t.Name(), nodeID), "{}"))
+	}
+
+	begin := time.Now()
+	snapshot, err := drv.Snapshot(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&scans))
+	require.Equal(t, []string{"node-1", "node-2", "node-3"}, snapshot.IDs())
+	require.Equal(t, 3, snapshot.Count())
+	require.True(t, snapshot.Has("node-2"))
+	require.False(t, snapshot.Has("node-0"))
+	require.False(t, snapshot.Has("node-4"))
+	require.False(t, snapshot.Has(""))
+	require.False(t, snapshot.TakenAt().Before(begin))
+
+	// the snapshot does not change with redis or its callers.
+	rds.Del(testFuncNodeKey(t.Name(), "node-2"))
+	ids := snapshot.IDs()
+	ids[0] = "changed"
+	require.Equal(t, []string{"node-1", "node-2", "node-3"}, snapshot.IDs())
+	require.True(t, snapshot.Has("node-2"))
+	require.Equal(t, int32(1), atomic.LoadInt32(&scans))
+
+	// without WithConsistentSnapshot the other calls scan.
+	count, err := drv.GetNodeCount(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, 2, count)
+	require.Equal(t, int32(2), atomic.LoadInt32(&scans))
+
+	rds.FlushAll()
+	empty, err := drv.Snapshot(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, []string{}, empty.IDs())
+	require.Equal(t, 0, empty.Count())
+	require.False(t, empty.Has("node-1"))
+}
+
+func TestRedisDriver_ConsistentSnapshotOption(t *testing.T) {
+	rds := miniredis.RunT(t)
+	var scans int32
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{
+		process: func(ctx context.Context, cmd redis.Cmder, next redis.ProcessHook) error {
+			if cmd.Name() == "scan" {
+				atomic.AddInt32(&scans, 1)
+			}
+			return next(ctx, cmd)
+		},
+	})
+	drv.Init(t.Name(),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithHeartbeatInterval(100*time.Millisecond),
+		redisdriver.WithConsistentSnapshot())
+	require.Nil(t, rds.Set(testFuncNodeKey(t.Name(), "node-1"), "{}"))
+
+	// before the first snapshot the calls scan.
+	count, err := drv.GetNodeCount(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, 1, count)
+	require.Equal(t, int32(1), atomic.LoadInt32(&scans))
+
+	snapshot, err := drv.Snapshot(context.Background())
+	require.Nil(t, err)
+	require.Nil(t, rds.Set(testFuncNodeKey(t.Name(), "node-2"), "{}"))
+	// the count and the list of the tick share the snapshot.
+	count, err = drv.GetNodeCount(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, snapshot.Count(), count)
+	nodes, err := drv.GetNodes(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, snapshot.IDs(), nodes)
+	require.Equal(t, int32(2), atomic.LoadInt32(&scans))
+
+	// an old snapshot is not used.
+	require.Eventually(t, func() bool {
+		nodes, err := drv.GetNodes(context.Background())
+		return err == nil && len(nodes) == 2
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,79 @@
+package redisdriver
+
+import (
+	"context"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// staleDeleteScript deletes KEYS[1] only if its value is still ARGV[1],
+// a node that refreshed its key meanwhile keeps it.
+var staleDeleteScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// removeStaleKeys deletes the node keys whose latest heartbeat is older
+// than the stale max age and returns the remaining keys. The own key is
+// never deleted. A key that can not be read, decoded or deleted is kept,
+// a failed cleanup is retried by the next GetNodes.
+func (rd *RedisDriver) removeStaleKeys(ctx context.Context, keys []string) []string {
+	if len(keys) == 0 {
+		return keys
+	}
+	values, err := getValues(ctx, rd.c, keys)
+	if err != nil {
+		rd.log("stale_cleanup").Warnf("get node values error %+v", err)
+		return keys
+	}
+	maxAge := rd.staleMaxAge()
+	now := rd.clock.Now()
+	alive := keys[:0]
+	for i, key := range keys {
+		value, ok := values[i].(string)
+		if !ok || key == rd.nodeKey(rd.nodeID) || !rd.isStale(key, value, now, maxAge) {
+			alive = append(alive, key)
+			continue
+		}
+		deleted, err := staleDeleteScript.Run(ctx, rd.c, []string{key}, value).Int()
+		if err != nil {
+			rd.log("stale_cleanup").Warnf("delete stale node key=%s error %+v", key, err)
+			alive = append(alive, key)
+			continue
+		}
+		if deleted == 0 {
+			// refreshed since it was read
+			alive = append(alive, key)
+			continue
+		}
+		rd.log("stale_cleanup").Warnf("deleted stale node key=%s", key)
+	}
+	return alive
+}
+
+// isStale reports whether the value of key was written more than maxAge
+// before now. Values without a heartbeat time, e.g. of older drivers,
+// are never stale.
+func (rd *RedisDriver) isStale(key, value string, now time.Time, maxAge time.Duration) bool {
+	nodeID, ok := rd.nodeIDFromKey(key)
+	if !ok {
+		return false
+	}
+	info, err := rd.decodeNodeValue(nodeID, key, value)
+	if err != nil || info.UpdatedAt.IsZero() {
+		return false
+	}
+	return now.Sub(info.UpdatedAt) > maxAge
+}
+
+// staleMaxAge is the max age of WithStaleCleanup, at least twice the
+// timeout so that the clock skew of a healthy node is tolerated.
+func (rd *RedisDriver) staleMaxAge() time.Duration {
+	maxAge := rd.staleCleanup
+	if floor := 2 * rd.getTimeout(); maxAge < floor {
+		maxAge = floor
+	}
+	return maxAge
+}
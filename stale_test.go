@@ -0,0 +1,94 @@
+package redisdriver_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dcron-contrib/commons"
+	"github.com/dcron-contrib/commons/dlog"
+	"github.com/dcron-contrib/redisdriver"
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisDriver_StaleCleanup(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithStaleCleanup(time.Minute))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+
+	// restored without TTL, the node wrote it an hour ago.
+	staleKey := testFuncNodeKey(t.Name(), "stale")
+	require.Nil(t, rds.Set(staleKey, fmt.Sprintf(`{"id":"stale","updated_at":%q}`,
+		time.Now().Add(-time.Hour).Format(time.RFC3339Nano))))
+	// slightly behind, within the skew tolerance of twice the timeout.
+	skewedKey := testFuncNodeKey(t.Name(), "skewed")
+	require.Nil(t, rds.Set(skewedKey, fmt.Sprintf(`{"id":"skewed","updated_at":%q}`,
+		time.Now().Add(-1500*time.Millisecond).Format(time.RFC3339Nano))))
+	// a value of an older driver has no heartbeat time.
+	legacyKey := testFuncNodeKey(t.Name(), "legacy")
+	require.Nil(t, rds.Set(legacyKey, "legacy"))
+
+	nodes, err := drv.GetNodes(context.Background())
+	require.Nil(t, err)
+	require.ElementsMatch(t, []string{drv.NodeID(), "skewed", "legacy"}, nodes)
+	require.False(t, rds.Exists(staleKey))
+	require.True(t, rds.Exists(skewedKey))
+	require.True(t, rds.Exists(legacyKey))
+}
+
+func TestRedisDriver_StaleCleanupCluster(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := redisdriver.NewDriver(testFuncNewClusterClient(t, rds))
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithStaleCleanup(time.Minute))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+
+	staleKey := testFuncNodeKey(t.Name(), "stale")
+	require.Nil(t, rds.Set(staleKey, fmt.Sprintf(`{"id":"stale","updated_at":%q}`,
+		time.Now().Add(-time.Hour).Format(time.RFC3339Nano))))
+
+	nodes, err := drv.GetNodes(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, []string{drv.NodeID()}, nodes)
+	require.False(t, rds.Exists(staleKey))
+}
+
+func TestRedisDriver_StaleCleanupRefreshed(t *testing.T) {
+	rds := miniredis.RunT(t)
+	staleKey := ""
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{
+		process: func(ctx context.Context, cmd redis.Cmder, next redis.ProcessHook) error {
+			if cmd.Name() == "evalsha" || cmd.Name() == "eval" {
+				// the node refreshes its key between the read and the delete.
+				require.Nil(t, rds.Set(staleKey, fmt.Sprintf(`{"id":"stale","updated_at":%q}`,
+					time.Now().Format(time.RFC3339Nano))))
+			}
+			return next(ctx, cmd)
+		},
+	})
+	drv.Init(t.Name(),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithStaleCleanup(time.Minute))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+	staleKey = testFuncNodeKey(t.Name(), "stale")
+	require.Nil(t, rds.Set(staleKey, fmt.Sprintf(`{"id":"stale","updated_at":%q}`,
+		time.Now().Add(-time.Hour).Format(time.RFC3339Nano))))
+
+	nodes, err := drv.GetNodes(context.Background())
+	require.Nil(t, err)
+	require.ElementsMatch(t, []string{drv.NodeID(), "stale"}, nodes)
+	require.True(t, rds.Exists(staleKey))
+	require.ErrorIs(t, drv.WithOption(redisdriver.WithStaleCleanup(0)), redisdriver.ErrInvalidOption)
+}
@@ -0,0 +1,79 @@
+package redisdriver
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// DriverStats is a snapshot of the runtime counters of the driver.
+type DriverStats struct {
+	// HeartbeatSuccesses and HeartbeatFailures count the heartbeat ticks,
+	// a tick that succeeds after retries counts as one success.
+	HeartbeatSuccesses int64
+	HeartbeatFailures  int64
+	// Scans counts the SCAN iterations over the node keys.
+	Scans int64
+	// ScansThrottled counts the scans that waited for WithScanRateLimit.
+	ScansThrottled int64
+	// LastScanDuration is the duration of the latest scan.
+	LastScanDuration time.Duration
+	// LastError is the latest heartbeat or scan error, nil if there was none.
+	LastError error
+	// ConsecutiveFailures counts the failed heartbeat ticks since the latest
+	// success. While it is above one the heartbeat is backed off.
+	ConsecutiveFailures int64
+	// Degraded reports whether the latest heartbeat tick failed.
+	Degraded bool
+	// Started reports whether the driver is running.
+	Started bool
+}
+
+// driverStats holds the counters of DriverStats.
+type driverStats struct {
+	heartbeatSuccesses  atomic.Int64
+	heartbeatFailures   atomic.Int64
+	consecutiveFailures atomic.Int64
+	scans               atomic.Int64
+	scansThrottled      atomic.Int64
+	lastScanDuration    atomic.Int64
+	lastError           atomic.Value
+}
+
+// errorHolder lets atomic.Value store errors of different types.
+type errorHolder struct{ err error }
+
+func (s *driverStats) setLastError(err error) {
+	s.lastError.Store(errorHolder{err: err})
+}
+
+// recordHeartbeat counts the result of a heartbeat.
+func (rd *RedisDriver) recordHeartbeat(err error) {
+	if err != nil {
+		rd.metrics.IncHeartbeatFailure()
+		rd.stats.heartbeatFailures.Add(1)
+		rd.stats.consecutiveFailures.Add(1)
+		rd.stats.setLastError(err)
+		return
+	}
+	rd.metrics.IncHeartbeatSuccess()
+	rd.stats.heartbeatSuccesses.Add(1)
+	rd.stats.consecutiveFailures.Store(0)
+}
+
+// Stats returns the runtime counters of the driver.
+func (rd *RedisDriver) Stats() DriverStats {
+	stats := DriverStats{
+		HeartbeatSuccesses:  rd.stats.heartbeatSuccesses.Load(),
+		HeartbeatFailures:   rd.stats.heartbeatFailures.Load(),
+		Scans:               rd.stats.scans.Load(),
+		ScansThrottled:      rd.stats.scansThrottled.Load(),
+		LastScanDuration:    time.Duration(rd.stats.lastScanDuration.Load()),
+		ConsecutiveFailures: rd.stats.consecutiveFailures.Load(),
+		Started:             rd.IsStarted(),
+	}
+	stats.Degraded = stats.ConsecutiveFailures > 0
+	if holder, ok := rd.stats.lastError.Load().(errorHolder); ok {
+		stats.LastError = holder.err
+	}
+	return stats
+}
@@ -0,0 +1,187 @@
+package redisdriver_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dcron-contrib/commons"
+	"github.com/dcron-contrib/commons/dlog"
+	"github.com/dcron-contrib/redisdriver"
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisDriver_Stats(t *testing.T) {
+	rds := miniredis.RunT(t)
+	clock := newTestClock()
+	errHeartbeat := errors.New("heartbeat failed")
+	var failing int32
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{
+		process: func(ctx context.Context, cmd redis.Cmder, next redis.ProcessHook) error {
+			if cmd.Name() == "setex" && atomic.LoadInt32(&failing) == 1 {
+				cmd.SetErr(errHeartbeat)
+				return cmd.Err()
+			}
+			return next(ctx, cmd)
+		},
+	})
+	drv.Init(t.Name(),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithMaxRetries(0),
+		redisdriver.WithClock(clock))
+	require.Equal(t, redisdriver.DriverStats{}, drv.Stats())
+
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+	ticker := clock.nextTicker(t)
+	ticker.tick()
+	require.Eventually(t, func() bool {
+		return drv.Stats().HeartbeatSuccesses == 1
+	}, time.Second, 10*time.Millisecond)
+	atomic.StoreInt32(&failing, 1)
+	ticker.tick()
+	require.Eventually(t, func() bool {
+		return drv.Stats().HeartbeatFailures == 1
+	}, time.Second, 10*time.Millisecond)
+	atomic.StoreInt32(&failing, 0)
+	_, err := drv.GetNodes(context.Background())
+	require.Nil(t, err)
+
+	stats := drv.Stats()
+	require.True(t, stats.Started)
+	require.Equal(t, int64(1), stats.HeartbeatSuccesses)
+	require.Equal(t, int64(1), stats.HeartbeatFailures)
+	require.ErrorIs(t, stats.LastError, errHeartbeat)
+	require.Equal(t, int64(1), stats.Scans)
+	require.Greater(t, stats.LastScanDuration, time.Duration(0))
+
+	rds.SetError("scan failed")
+	_, err = drv.GetNodes(context.Background())
+	require.NotNil(t, err)
+	rds.SetError("")
+	stats = drv.Stats()
+	require.Equal(t, int64(2), stats.Scans)
+	require.NotErrorIs(t, stats.LastError, errHeartbeat)
+}
+
+func TestRedisDriver_HeartbeatBackoff(t *testing.T) {
+	rds := miniredis.RunT(t)
+	clock := newTestClock()
+	var failing int32 = 1
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{
+		process: func(ctx context.Context, cmd redis.Cmder, next redis.ProcessHook) error {
+			if cmd.Name() == "setex" && atomic.LoadInt32(&failing) == 1 {
+				cmd.SetErr(errors.New("connection refused"))
+				return cmd.Err()
+			}
+			return next(ctx, cmd)
+		},
+	})
+	logger := &testLogger{}
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(time.Minute),
+		commons.NewLoggerOption(logger),
+		redisdriver.WithHeartbeatInterval(time.Second),
+		redisdriver.WithMaxRetries(0),
+		redisdriver.WithClock(clock))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+	ticker := clock.nextTicker(t)
+	require.Equal(t, time.Second, <-ticker.intervals)
+
+	nextInterval := func() time.Duration {
+		select {
+		case d := <-ticker.intervals:
+			return d
+		case <-time.After(time.Second):
+			t.Fatal("no interval is set")
+		}
+		return 0
+	}
+	// the first failure keeps the interval, the next ones double it.
+	ticker.tick()
+	for _, want := range []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 30 * time.Second} {
+		ticker.tick()
+		require.Equal(t, want, nextInterval())
+	}
+	// the cap is reached, the ticker is not reset again.
+	ticker.tick()
+	require.Eventually(t, func() bool {
+		return drv.Stats().ConsecutiveFailures == 7
+	}, time.Second, time.Millisecond)
+	require.True(t, drv.Stats().Degraded)
+
+	atomic.StoreInt32(&failing, 0)
+	ticker.tick()
+	require.Equal(t, time.Second, nextInterval())
+	ticker.tick()
+	ticker.tick()
+	require.Eventually(t, func() bool {
+		return drv.Stats().HeartbeatSuccesses == 3
+	}, time.Second, time.Millisecond)
+	require.False(t, drv.Stats().Degraded)
+
+	var errorLines, recoveryLines int
+	for _, line := range logger.Lines() {
+		if strings.Contains(line, "register service node error") {
+			errorLines++
+		}
+		if strings.Contains(line, "recovered") {
+			recoveryLines++
+		}
+	}
+	// the 1st, 2nd and 4th of the 7 failures are logged.
+	require.Equal(t, 3, errorLines)
+	require.Equal(t, 1, recoveryLines)
+}
+
+func TestRedisDriver_HeartbeatBackoffShortTimeout(t *testing.T) {
+	rds := miniredis.RunT(t)
+	clock := newTestClock()
+	var failing int32
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{
+		process: func(ctx context.Context, cmd redis.Cmder, next redis.ProcessHook) error {
+			if testFuncIsRegister(cmd) && atomic.LoadInt32(&failing) == 1 {
+				cmd.SetErr(errors.New("connection refused"))
+				return cmd.Err()
+			}
+			return next(ctx, cmd)
+		},
+	})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(2*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithHeartbeatInterval(250*time.Millisecond),
+		redisdriver.WithMaxRetries(0),
+		redisdriver.WithClock(clock))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+	ticker := clock.nextTicker(t)
+	require.Equal(t, 250*time.Millisecond, <-ticker.intervals)
+
+	// the backoff stops at half the TTL, the key is written again within
+	// one TTL once redis is back.
+	atomic.StoreInt32(&failing, 1)
+	var last time.Duration
+	for i := 0; i < 8; i++ {
+		ticker.tick()
+		select {
+		case last = <-ticker.intervals:
+			require.LessOrEqual(t, last, time.Second)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	require.Equal(t, time.Second, last)
+	key := testFuncNodeKey(t.Name(), drv.NodeID())
+	rds.FastForward(2 * time.Second)
+	require.False(t, rds.Exists(key))
+
+	atomic.StoreInt32(&failing, 0)
+	ticker.tick()
+	require.Eventually(t, func() bool { return rds.Exists(key) }, time.Second, time.Millisecond)
+}
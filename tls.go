@@ -0,0 +1,64 @@
+package redisdriver
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/dcron-contrib/commons"
+	redis "github.com/redis/go-redis/v9"
+)
+
+// NewTLSDriver creates a driver on a client that connects to addr over TLS.
+// For mutual TLS set the client certificate in tlsCfg.Certificates. The
+// client selects the database of WithDB, if it is in opts.
+func NewTLSDriver(addr string, tlsCfg *tls.Config, opts ...commons.Option) (*RedisDriver, error) {
+	if tlsCfg == nil {
+		return nil, fmt.Errorf("%w: tls config must not be nil", ErrInvalidOption)
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr:      addr,
+		TLSConfig: tlsCfg.Clone(),
+		DB:        dbOf(opts),
+	})
+	return newTLSDriver(client, tlsCfg, opts)
+}
+
+// NewTLSClusterDriver creates a driver on a cluster client that connects
+// to the cluster nodes over TLS, see NewTLSDriver.
+func NewTLSClusterDriver(addrs []string, tlsCfg *tls.Config, opts ...commons.Option) (*RedisDriver, error) {
+	if tlsCfg == nil {
+		return nil, fmt.Errorf("%w: tls config must not be nil", ErrInvalidOption)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("%w: cluster addresses must not be empty", ErrInvalidOption)
+	}
+	client := redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs:     addrs,
+		TLSConfig: tlsCfg.Clone(),
+	})
+	return newTLSDriver(client, tlsCfg, opts)
+}
+
+func newTLSDriver(client redis.UniversalClient, tlsCfg *tls.Config, opts []commons.Option) (*RedisDriver, error) {
+	rd := NewDriver(client)
+	for _, opt := range opts {
+		if err := rd.WithOption(opt); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	if tlsCfg.InsecureSkipVerify {
+		rd.log("init").Warnf("tls certificate verification of redis is disabled")
+	}
+	return rd, nil
+}
+
+// dbOf returns the database of the last DBOption in opts, zero if none.
+func dbOf(opts []commons.Option) (db int) {
+	for _, opt := range opts {
+		if opt.Type() == OptionTypeDB {
+			db = opt.(DBOption).DB
+		}
+	}
+	return
+}
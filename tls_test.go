@@ -0,0 +1,120 @@
+package redisdriver_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dcron-contrib/commons"
+	"github.com/dcron-contrib/commons/dlog"
+	"github.com/dcron-contrib/redisdriver"
+	"github.com/stretchr/testify/require"
+)
+
+// testFuncCertificate creates a self signed certificate for 127.0.0.1,
+// usable by the server and by the client.
+func testFuncCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "redisdriver"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestNewTLSDriver(t *testing.T) {
+	cert, pool := testFuncCertificate(t)
+	rds, err := miniredis.RunTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	require.Nil(t, err)
+	defer rds.Close()
+
+	drv, err := redisdriver.NewTLSDriver(rds.Addr(), &tls.Config{
+		RootCAs:      pool,
+		Certificates: []tls.Certificate{cert},
+	}, commons.NewLoggerOption(dlog.NewLoggerForTest(t)))
+	require.Nil(t, err)
+	defer drv.Client().Close()
+	drv.Init(t.Name())
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+	require.True(t, rds.Exists(testFuncNodeKey(t.Name(), drv.NodeID())))
+
+	// the server asks for a client certificate.
+	drv2, err := redisdriver.NewTLSDriver(rds.Addr(), &tls.Config{RootCAs: pool})
+	require.Nil(t, err)
+	defer drv2.Client().Close()
+	drv2.Init(t.Name())
+	require.NotNil(t, drv2.Start(context.Background()))
+}
+
+func TestNewTLSDriverDB(t *testing.T) {
+	cert, pool := testFuncCertificate(t)
+	rds, err := miniredis.RunTLS(&tls.Config{Certificates: []tls.Certificate{cert}})
+	require.Nil(t, err)
+	defer rds.Close()
+
+	drv, err := redisdriver.NewTLSDriver(rds.Addr(), &tls.Config{RootCAs: pool},
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithDB(3))
+	require.Nil(t, err)
+	defer drv.Client().Close()
+	drv.Init(t.Name())
+	require.Nil(t, drv.Start(context.Background()))
+	defer drv.Stop(context.Background())
+	require.True(t, rds.DB(3).Exists(testFuncNodeKey(t.Name(), drv.NodeID())))
+	require.False(t, rds.Exists(testFuncNodeKey(t.Name(), drv.NodeID())))
+
+	_, err = redisdriver.NewTLSClusterDriver([]string{rds.Addr()}, &tls.Config{RootCAs: pool}, redisdriver.WithDB(3))
+	require.ErrorIs(t, err, redisdriver.ErrInvalidOption)
+}
+
+func TestNewTLSDriverInsecure(t *testing.T) {
+	logger := &testLogger{}
+	drv, err := redisdriver.NewTLSDriver("127.0.0.1:6379", &tls.Config{InsecureSkipVerify: true},
+		commons.NewLoggerOption(logger))
+	require.Nil(t, err)
+	defer drv.Client().Close()
+	require.Equal(t, []string{"[WARN] tls certificate verification of redis is disabled"}, logger.Lines())
+}
+
+func TestNewTLSDriverInvalid(t *testing.T) {
+	_, err := redisdriver.NewTLSDriver("127.0.0.1:6379", nil)
+	require.ErrorIs(t, err, redisdriver.ErrInvalidOption)
+	_, err = redisdriver.NewTLSClusterDriver(nil, &tls.Config{})
+	require.ErrorIs(t, err, redisdriver.ErrInvalidOption)
+	_, err = redisdriver.NewTLSClusterDriver([]string{"127.0.0.1:6379"}, &tls.Config{},
+		redisdriver.WithScanCount(0))
+	require.ErrorIs(t, err, redisdriver.ErrInvalidOption)
+
+	drv, err := redisdriver.NewTLSClusterDriver([]string{"127.0.0.1:6379"}, &tls.Config{})
+	require.Nil(t, err)
+	defer drv.Client().Close()
+}
@@ -0,0 +1,190 @@
+package redisdriver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// clusterWatches holds the clusterWatch of every cluster client a driver
+// was created on.
+var clusterWatches sync.Map
+
+// clusterWatch hooks every shard client of a cluster client once, however
+// many drivers share the cluster client, and forwards the dials and the
+// redirects of the shards to the drivers that subscribed. A hook on the
+// cluster client itself never sees them, the shard clients dial on their
+// own and the cluster client follows the redirects. The cluster client
+// keeps its OnNewNode callbacks, so a watch is never removed, a stopped
+// driver only unsubscribes.
+type clusterWatch struct {
+	mu     sync.Mutex
+	shards map[*redis.Client]struct{}
+	dialed map[chan struct{}]struct{}
+	// redirected are signalled on the MOVED and ASK redirects, the slot of
+	// a node key may have moved.
+	redirected map[chan struct{}]struct{}
+}
+
+// clusterWatchOf returns the watch of cluster, the first call hooks the
+// shard clients the cluster client creates from then on.
+func clusterWatchOf(cluster *redis.ClusterClient) *clusterWatch {
+	if w, ok := clusterWatches.Load(cluster); ok {
+		return w.(*clusterWatch)
+	}
+	v, loaded := clusterWatches.LoadOrStore(cluster, &clusterWatch{
+		shards:     make(map[*redis.Client]struct{}),
+		dialed:     make(map[chan struct{}]struct{}),
+		redirected: make(map[chan struct{}]struct{}),
+	})
+	w := v.(*clusterWatch)
+	if !loaded {
+		cluster.OnNewNode(w.hook)
+	}
+	return w
+}
+
+// hook adds the shard hook to shard, unless it has it already.
+func (w *clusterWatch) hook(shard *redis.Client) {
+	w.mu.Lock()
+	_, ok := w.shards[shard]
+	w.shards[shard] = struct{}{}
+	w.mu.Unlock()
+	if !ok {
+		shard.AddHook(shardHook{w: w})
+	}
+}
+
+// hookShards hooks the shard clients the cluster client created already.
+func (w *clusterWatch) hookShards(ctx context.Context, cluster *redis.ClusterClient) error {
+	return cluster.ForEachShard(ctx, func(ctx context.Context, shard *redis.Client) error {
+		w.hook(shard)
+		return nil
+	})
+}
+
+func (w *clusterWatch) subscribe(set map[chan struct{}]struct{}, ch chan struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	set[ch] = struct{}{}
+}
+
+func (w *clusterWatch) unsubscribe(set map[chan struct{}]struct{}, ch chan struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(set, ch)
+}
+
+// notify signals every channel of set, the signals are coalesced while a
+// driver does not read them.
+func (w *clusterWatch) notify(set map[chan struct{}]struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for ch := range set {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// shardHook is the hook of clusterWatch on a shard client.
+type shardHook struct {
+	w *clusterWatch
+}
+
+func (h shardHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := next(ctx, network, addr)
+		if err == nil {
+			h.w.notify(h.w.dialed)
+		}
+		return conn, err
+	}
+}
+
+func (h shardHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		err := next(ctx, cmd)
+		h.observe(err)
+		return err
+	}
+}
+
+func (h shardHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		err := next(ctx, cmds)
+		for _, cmd := range cmds {
+			h.observe(cmd.Err())
+		}
+		return err
+	}
+}
+
+func (h shardHook) observe(err error) {
+	if err == nil {
+		return
+	}
+	msg := err.Error()
+	if strings.HasPrefix(msg, "MOVED ") || strings.HasPrefix(msg, "ASK ") {
+		h.w.notify(h.w.redirected)
+	}
+}
+
+// watchShards subscribes the driver to the shards of a cluster client on
+// Start, and hooks the shards connected before.
+func (rd *RedisDriver) watchShards(ctx context.Context) {
+	if rd.clusterWatch == nil || (rd.reconnected == nil && rd.topologyChanged == nil) {
+		return
+	}
+	if rd.reconnected != nil {
+		rd.clusterWatch.subscribe(rd.clusterWatch.dialed, rd.reconnected)
+	}
+	if rd.topologyChanged != nil {
+		rd.clusterWatch.subscribe(rd.clusterWatch.redirected, rd.topologyChanged)
+	}
+	ctx, cancel := context.WithTimeout(ctx, rd.getCommandTimeout())
+	defer cancel()
+	if err := rd.clusterWatch.hookShards(ctx, rd.c.(*redis.ClusterClient)); err != nil {
+		rd.log("start").Warnf("hook cluster shards error=%v, only new shards are watched", err)
+	}
+}
+
+// unwatchShards reverses watchShards on Stop.
+func (rd *RedisDriver) unwatchShards() {
+	if rd.clusterWatch == nil {
+		return
+	}
+	if rd.reconnected != nil {
+		rd.clusterWatch.unsubscribe(rd.clusterWatch.dialed, rd.reconnected)
+	}
+	if rd.topologyChanged != nil {
+		rd.clusterWatch.unsubscribe(rd.clusterWatch.redirected, rd.topologyChanged)
+	}
+}
+
+// verifyRegistration checks that the node key still exists after a
+// change of the cluster topology, it reports false if it is missing.
+func (rd *RedisDriver) verifyRegistration(ctx context.Context) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, rd.effectiveHeartbeatTimeout())
+	defer cancel()
+	var n int64
+	var err error
+	if rd.hashStorage {
+		var ok bool
+		ok, err = rd.c.HExists(ctx, rd.hashKey(), rd.nodeID).Result()
+		if ok {
+			n = 1
+		}
+	} else {
+		n, err = rd.c.Exists(ctx, rd.nodeKey(rd.nodeID)).Result()
+	}
+	if err != nil {
+		return false, fmt.Errorf("verify node key: %w", err)
+	}
+	return n == 1, nil
+}
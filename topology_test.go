@@ -0,0 +1,103 @@
+package redisdriver_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dcron-contrib/commons"
+	"github.com/dcron-contrib/commons/dlog"
+	"github.com/dcron-contrib/redisdriver"
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisDriver_ClusterTopologyChange(t *testing.T) {
+	rds := miniredis.RunT(t)
+	client := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{rds.Addr()}})
+	defer client.Close()
+	clock := newTestClock()
+	drv := redisdriver.NewDriver(client)
+	drv.Init(t.Name(),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithTopologyWatch(),
+		redisdriver.WithClock(clock))
+	// the shard answers the first GET of "moved" by a redirect to itself.
+	var moved int32
+	client.OnNewNode(func(shard *redis.Client) {
+		shard.AddHook(&testHook{
+			process: func(ctx context.Context, cmd redis.Cmder, next redis.ProcessHook) error {
+				if cmd.Name() == "get" && cmd.Args()[1] == "moved" && atomic.CompareAndSwapInt32(&moved, 0, 1) {
+					rds.SetError("MOVED 1234 " + rds.Addr())
+					defer rds.SetError("")
+				}
+				return next(ctx, cmd)
+			},
+		})
+	})
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+	clock.nextTicker(t)
+	key := testFuncNodeKey(t.Name(), drv.NodeID())
+
+	// the key got lost while its slot moved.
+	rds.Del(key)
+	require.ErrorIs(t, client.Get(context.Background(), "moved").Err(), redis.Nil)
+	require.Equal(t, int32(1), atomic.LoadInt32(&moved))
+	// the heartbeat writes it again without waiting for a tick.
+	require.Eventually(t, func() bool { return rds.Exists(key) }, time.Second, time.Millisecond)
+}
+
+func TestRedisDriver_ClusterTopologyChangeSharedClient(t *testing.T) {
+	rds := miniredis.RunT(t)
+	client := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{rds.Addr()}})
+	defer client.Close()
+	// the shard client exists before the drivers, Start hooks it.
+	require.Nil(t, client.Ping(context.Background()).Err())
+	var moved int32
+	require.Nil(t, client.ForEachShard(context.Background(), func(ctx context.Context, shard *redis.Client) error {
+		shard.AddHook(&testHook{
+			process: func(ctx context.Context, cmd redis.Cmder, next redis.ProcessHook) error {
+				if cmd.Name() == "get" && cmd.Args()[1] == "moved" && atomic.CompareAndSwapInt32(&moved, 0, 1) {
+					rds.SetError("MOVED 1234 " + rds.Addr())
+					defer rds.SetError("")
+				}
+				return next(ctx, cmd)
+			},
+		})
+		return nil
+	}))
+	keys := make([]string, 2)
+	for i := range keys {
+		clock := newTestClock()
+		drv := redisdriver.NewDriver(client)
+		drv.Init(t.Name(),
+			commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+			redisdriver.WithTopologyWatch(),
+			redisdriver.WithClock(clock))
+		require.Nil(t, drv.Start(context.Background()))
+		defer testFuncStop(t, rds, drv)
+		clock.nextTicker(t)
+		keys[i] = testFuncNodeKey(t.Name(), drv.NodeID())
+	}
+	// a driver without the option does not watch the shards.
+	quiet := redisdriver.NewDriver(client)
+	quiet.Init(t.Name(), commons.NewLoggerOption(dlog.NewLoggerForTest(t)), redisdriver.WithClock(newTestClock()))
+	require.Nil(t, quiet.Start(context.Background()))
+	defer testFuncStop(t, rds, quiet)
+	quietKey := testFuncNodeKey(t.Name(), quiet.NodeID())
+
+	for _, key := range append(keys, quietKey) {
+		rds.Del(key)
+	}
+	require.ErrorIs(t, client.Get(context.Background(), "moved").Err(), redis.Nil)
+	// every driver of the shared client sees the redirect of the one shard hook.
+	for _, key := range keys {
+		key := key
+		require.Eventually(t, func() bool { return rds.Exists(key) }, time.Second, time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	require.False(t, rds.Exists(quietKey))
+}
@@ -0,0 +1,37 @@
+package redisdriver
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/dcron-contrib/redisdriver"
+
+func defaultTracer() trace.Tracer {
+	return trace.NewNoopTracerProvider().Tracer(tracerName)
+}
+
+// startSpan starts a client span for a redis round trip, the returned
+// context must be passed to the redis call so its instrumentation
+// links to the span.
+func (rd *RedisDriver) startSpan(ctx context.Context, name, command string) (context.Context, trace.Span) {
+	return rd.tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("service.name", rd.serviceName),
+			attribute.String("node.id", rd.nodeID),
+			attribute.String("db.system", "redis"),
+			attribute.String("db.operation", command),
+		))
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
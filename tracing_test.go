@@ -0,0 +1,96 @@
+package redisdriver_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dcron-contrib/commons"
+	"github.com/dcron-contrib/commons/dlog"
+	"github.com/dcron-contrib/redisdriver"
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type testSpan struct {
+	trace.Span
+	sc trace.SpanContext
+}
+
+func (s *testSpan) SpanContext() trace.SpanContext { return s.sc }
+
+type testTracer struct {
+	sync.Mutex
+	spans map[string][]attribute.KeyValue
+}
+
+func (tr *testTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	tr.Lock()
+	defer tr.Unlock()
+	cfg := trace.NewSpanStartConfig(opts...)
+	tr.spans[name] = cfg.Attributes()
+	span := &testSpan{
+		Span: trace.SpanFromContext(context.Background()),
+		sc: trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID: trace.TraceID{1},
+			SpanID:  trace.SpanID{byte(len(tr.spans))},
+		}),
+	}
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+func (tr *testTracer) attributes(name string) ([]attribute.KeyValue, bool) {
+	tr.Lock()
+	defer tr.Unlock()
+	attrs, ok := tr.spans[name]
+	return attrs, ok
+}
+
+func TestRedisDriver_Tracer(t *testing.T) {
+	rds := miniredis.RunT(t)
+	tracer := &testTracer{spans: make(map[string][]attribute.KeyValue)}
+	var mu sync.Mutex
+	traced := make(map[string]bool)
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{
+		process: func(ctx context.Context, cmd redis.Cmder, next redis.ProcessHook) error {
+			mu.Lock()
+			traced[cmd.Name()] = trace.SpanContextFromContext(ctx).IsValid()
+			mu.Unlock()
+			return next(ctx, cmd)
+		},
+	})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(5*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithTracer(tracer))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+	_, err := drv.GetNodes(context.Background())
+	require.Nil(t, err)
+	_, err = drv.TryAcquireLeadership(context.Background())
+	require.Nil(t, err)
+	require.Nil(t, drv.ResignLeadership(context.Background()))
+
+	attrs, ok := tracer.attributes("redisdriver.register")
+	require.True(t, ok)
+	require.Contains(t, attrs, attribute.String("service.name", t.Name()))
+	require.Contains(t, attrs, attribute.String("node.id", drv.NodeID()))
+	// Start claims the node key by SET NX.
+	require.Contains(t, attrs, attribute.String("db.operation", "SET"))
+	attrs, ok = tracer.attributes("redisdriver.scan")
+	require.True(t, ok)
+	require.Contains(t, attrs, attribute.String("db.operation", "SCAN"))
+	_, ok = tracer.attributes("redisdriver.acquire_leadership")
+	require.True(t, ok)
+	_, ok = tracer.attributes("redisdriver.resign_leadership")
+	require.True(t, ok)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.True(t, traced["scan"])
+	require.True(t, traced["set"])
+}
@@ -0,0 +1,351 @@
+package redisdriver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+type NodeEventType int
+
+const (
+	NodeJoin NodeEventType = iota + 1
+	NodeLeave
+)
+
+func (t NodeEventType) String() string {
+	switch t {
+	case NodeJoin:
+		return "join"
+	case NodeLeave:
+		return "leave"
+	}
+	return "unknown"
+}
+
+// NodeEvent is a membership change of the service.
+type NodeEvent struct {
+	Type   NodeEventType
+	NodeID string
+}
+
+// Watch emits the membership changes of this service until ctx is done,
+// then the channel is closed. The nodes alive when Watch is called are
+// emitted as NodeJoin first.
+//
+// Changes are detected by polling GetNodes every heartbeat interval, so a
+// join is seen within one interval and a leave within one interval after
+// the node key is removed, which is up to the timeout for a crashed node.
+// With WithKeyspaceNotifications, leaves are emitted as soon as the server
+// notifies the expiry or deletion of a node key.
+func (rd *RedisDriver) Watch(ctx context.Context) (<-chan NodeEvent, error) {
+	nodes, err := rd.GetNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pubsub subscription
+	if rd.keyspaceNotifications && !rd.hashStorage {
+		pubsub = rd.subscribeKeyspace(ctx)
+	}
+	events := make(chan NodeEvent)
+	go rd.watchNodes(ctx, events, nodes, pubsub, rd.keyeventNodeEvent, true)
+	return events, nil
+}
+
+// maxWaitPollInterval bounds the poll interval of WaitForNodes.
+const maxWaitPollInterval = time.Second
+
+// WaitForNodes polls GetNodes every heartbeat interval, at most every
+// second, until at least n nodes are alive and returns them. When ctx is
+// done first it returns the latest nodes with the error of ctx. Failed
+// polls are logged and retried.
+func (rd *RedisDriver) WaitForNodes(ctx context.Context, n int) ([]string, error) {
+	interval := rd.effectiveHeartbeatInterval()
+	if interval > maxWaitPollInterval {
+		interval = maxWaitPollInterval
+	}
+	tick := rd.clock.NewTicker(interval)
+	defer tick.Stop()
+	var nodes []string
+	for {
+		current, err := rd.GetNodes(ctx)
+		if err == nil {
+			nodes = current
+			if len(nodes) >= n {
+				return nodes, nil
+			}
+		} else if ctx.Err() == nil {
+			rd.log("watch").Warnf("wait for nodes error %+v", err)
+		}
+		select {
+		case <-tick.C():
+		case <-ctx.Done():
+			return nodes, ctx.Err()
+		}
+	}
+}
+
+// private function
+
+// notifyMembership runs the callbacks of WithOnNodeJoin and WithOnNodeLeave
+// for the events of Watch until ctx is done. Every callback runs in its own
+// goroutine, so a slow callback does not hold up the poller.
+func (rd *RedisDriver) notifyMembership(ctx context.Context) {
+	events, err := rd.Watch(ctx)
+	for err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		rd.log("watch").Warnf("watch membership error %+v, retry in %v", err, rd.effectiveHeartbeatInterval())
+		if rd.sleep(ctx, rd.effectiveHeartbeatInterval()) != nil {
+			return
+		}
+		events, err = rd.Watch(ctx)
+	}
+	for event := range events {
+		callback := rd.onNodeJoin
+		if event.Type == NodeLeave {
+			callback = rd.onNodeLeave
+		}
+		if callback != nil {
+			go callback(event.NodeID)
+		}
+	}
+}
+
+// watchNodes emits the membership changes found by polling GetNodes and
+// the events that decode reads from the messages of pubsub, if it is set.
+// With followDriver the subscription ends when the driver stops.
+func (rd *RedisDriver) watchNodes(ctx context.Context, events chan<- NodeEvent, nodes []string,
+	pubsub subscription, decode func(msg *redis.Message) (NodeEvent, bool), followDriver bool) {
+	defer close(events)
+	var messages <-chan *redis.Message
+	var runtimeDone <-chan struct{}
+	if pubsub != nil {
+		defer pubsub.Close()
+		messages = pubsub.Channel()
+		rd.Lock()
+		if followDriver && rd.runtimeCtx != nil {
+			runtimeDone = rd.runtimeCtx.Done()
+		}
+		rd.Unlock()
+	}
+	known := make(map[string]struct{}, len(nodes))
+	for _, nodeID := range nodes {
+		known[nodeID] = struct{}{}
+		if !sendNodeEvent(ctx, events, NodeEvent{Type: NodeJoin, NodeID: nodeID}) {
+			return
+		}
+	}
+
+	tick := rd.clock.NewTicker(rd.effectiveHeartbeatInterval())
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C():
+			{
+				nodes, err := rd.GetNodes(ctx)
+				if err != nil {
+					rd.log("watch").Warnf("watch nodes error %+v", err)
+					continue
+				}
+				current := make(map[string]struct{}, len(nodes))
+				for _, nodeID := range nodes {
+					current[nodeID] = struct{}{}
+					if _, ok := known[nodeID]; ok {
+						continue
+					}
+					if !sendNodeEvent(ctx, events, NodeEvent{Type: NodeJoin, NodeID: nodeID}) {
+						return
+					}
+				}
+				for nodeID := range known {
+					if _, ok := current[nodeID]; ok {
+						continue
+					}
+					if !sendNodeEvent(ctx, events, NodeEvent{Type: NodeLeave, NodeID: nodeID}) {
+						return
+					}
+				}
+				known = current
+			}
+		case msg, ok := <-messages:
+			{
+				if !ok {
+					messages = nil
+					continue
+				}
+				event, ok := decode(msg)
+				if !ok {
+					continue
+				}
+				_, isKnown := known[event.NodeID]
+				if event.Type == NodeJoin {
+					if isKnown {
+						continue
+					}
+					known[event.NodeID] = struct{}{}
+				} else {
+					if !isKnown {
+						continue
+					}
+					delete(known, event.NodeID)
+				}
+				if !sendNodeEvent(ctx, events, event) {
+					return
+				}
+			}
+		case <-runtimeDone:
+			{
+				// the driver is stopped, keep on polling only.
+				pubsub.Close()
+				runtimeDone = nil
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// subscription is the pubsub of watchNodes, a *redis.PubSub or the
+// subscriptions of all masters of a cluster.
+type subscription interface {
+	Channel(opts ...redis.ChannelOption) <-chan *redis.Message
+	Close() error
+}
+
+// subscribeKeyspace subscribes the expired and del key events, it returns
+// nil if the server does not notify them. The server publishes a keyevent
+// on the node holding the key only, on a cluster every master is
+// subscribed.
+func (rd *RedisDriver) subscribeKeyspace(ctx context.Context) subscription {
+	cluster, ok := rd.c.(*redis.ClusterClient)
+	if !ok {
+		if pubsub := rd.subscribeKeyevents(ctx, rd.c); pubsub != nil {
+			return pubsub
+		}
+		return nil
+	}
+	var mu sync.Mutex
+	shards := &shardSubscription{done: make(chan struct{})}
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+		pubsub := rd.subscribeKeyevents(ctx, master)
+		if pubsub == nil {
+			return fmt.Errorf("master %s", master.Options().Addr)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		shards.pubsubs = append(shards.pubsubs, pubsub)
+		return nil
+	})
+	if err != nil {
+		rd.log("watch").Warnf("subscribe keyevent of %v failed, fall back to polling", err)
+		shards.Close()
+		return nil
+	}
+	return shards
+}
+
+// subscribeKeyevents subscribes the key events of c, it returns nil if the
+// server does not notify them.
+func (rd *RedisDriver) subscribeKeyevents(ctx context.Context, c redis.UniversalClient) *redis.PubSub {
+	config, err := c.ConfigGet(ctx, "notify-keyspace-events").Result()
+	if err != nil {
+		rd.log("watch").Warnf("get notify-keyspace-events error %+v, fall back to polling", err)
+		return nil
+	}
+	flags := config["notify-keyspace-events"]
+	if !strings.Contains(flags, "E") || !strings.ContainsAny(flags, "Ax") {
+		rd.log("watch").Warnf("keyevent notifications for expired keys are disabled (notify-keyspace-events=%q), fall back to polling", flags)
+		return nil
+	}
+	channels := []string{rd.keyeventChannel("expired")}
+	if strings.ContainsAny(flags, "Ag") {
+		channels = append(channels, rd.keyeventChannel("del"))
+	}
+	pubsub := c.Subscribe(ctx, channels...)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		rd.log("watch").Warnf("subscribe keyevent error %+v, fall back to polling", err)
+		pubsub.Close()
+		return nil
+	}
+	return pubsub
+}
+
+// shardSubscription merges the subscriptions of the masters of a cluster.
+// A master added later, e.g. by a resharding, is not subscribed, the
+// leaves of its keys are found by polling.
+type shardSubscription struct {
+	pubsubs []*redis.PubSub
+
+	once      sync.Once
+	messages  chan *redis.Message
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// Channel forwards the messages of all masters, it is closed once all
+// subscriptions are closed.
+func (s *shardSubscription) Channel(opts ...redis.ChannelOption) <-chan *redis.Message {
+	s.once.Do(func() {
+		s.messages = make(chan *redis.Message)
+		var wg sync.WaitGroup
+		for _, pubsub := range s.pubsubs {
+			wg.Add(1)
+			go func(messages <-chan *redis.Message) {
+				defer wg.Done()
+				for msg := range messages {
+					select {
+					case s.messages <- msg:
+					case <-s.done:
+						return
+					}
+				}
+			}(pubsub.Channel(opts...))
+		}
+		go func() {
+			wg.Wait()
+			close(s.messages)
+		}()
+	})
+	return s.messages
+}
+
+func (s *shardSubscription) Close() (err error) {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		for _, pubsub := range s.pubsubs {
+			if closeErr := pubsub.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+	})
+	return err
+}
+
+// keyeventNodeEvent reads the leave of a node from a keyevent message.
+func (rd *RedisDriver) keyeventNodeEvent(msg *redis.Message) (NodeEvent, bool) {
+	nodeID, ok := rd.nodeIDFromKey(msg.Payload)
+	return NodeEvent{Type: NodeLeave, NodeID: nodeID}, ok
+}
+
+func (rd *RedisDriver) keyeventChannel(event string) string {
+	db := 0
+	if c, ok := rd.c.(*redis.Client); ok {
+		db = c.Options().DB
+	}
+	return fmt.Sprintf("__keyevent@%d__:%s", db, event)
+}
+
+func sendNodeEvent(ctx context.Context, events chan<- NodeEvent, event NodeEvent) bool {
+	select {
+	case events <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
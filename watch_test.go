@@ -0,0 +1,306 @@
+package redisdriver_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dcron-contrib/commons"
+	"github.com/dcron-contrib/commons/dlog"
+	"github.com/dcron-contrib/redisdriver"
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func testFuncNextEvent(t *testing.T, events <-chan redisdriver.NodeEvent) redisdriver.NodeEvent {
+	select {
+	case event, ok := <-events:
+		require.True(t, ok, "events channel is closed")
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatal("no node event")
+	}
+	return redisdriver.NodeEvent{}
+}
+
+func TestRedisDriver_Watch(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv1 := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv1.Init(t.Name(),
+		commons.NewTimeoutOption(2*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithHeartbeatInterval(20*time.Millisecond))
+	drv2 := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv2.Init(t.Name(),
+		commons.NewTimeoutOption(2*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)))
+	require.Nil(t, drv1.Start(context.Background()))
+	defer testFuncStop(t, rds, drv1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := drv1.Watch(ctx)
+	require.Nil(t, err)
+	require.Equal(t, redisdriver.NodeEvent{Type: redisdriver.NodeJoin, NodeID: drv1.NodeID()}, testFuncNextEvent(t, events))
+
+	require.Nil(t, drv2.Start(context.Background()))
+	require.Equal(t, redisdriver.NodeEvent{Type: redisdriver.NodeJoin, NodeID: drv2.NodeID()}, testFuncNextEvent(t, events))
+
+	testFuncStop(t, rds, drv2)
+	require.Equal(t, redisdriver.NodeEvent{Type: redisdriver.NodeLeave, NodeID: drv2.NodeID()}, testFuncNextEvent(t, events))
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		require.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("events channel is not closed")
+	}
+}
+
+func TestRedisDriver_WatchKeyspaceNotifications(t *testing.T) {
+	rds := miniredis.RunT(t)
+	// miniredis does not implement CONFIG, pretend notifications are enabled.
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{
+		process: func(ctx context.Context, cmd redis.Cmder, next redis.ProcessHook) error {
+			if cmd.Name() == "config" {
+				cmd.(*redis.MapStringStringCmd).SetVal(map[string]string{"notify-keyspace-events": "Exg"})
+				return nil
+			}
+			return next(ctx, cmd)
+		},
+	})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(10*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithKeyspaceNotifications(true))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+
+	otherKey := testFuncNodeKey(t.Name(), "other")
+	require.Nil(t, rds.Set(otherKey, "other"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := drv.Watch(ctx)
+	require.Nil(t, err)
+	joined := []string{testFuncNextEvent(t, events).NodeID, testFuncNextEvent(t, events).NodeID}
+	require.ElementsMatch(t, []string{drv.NodeID(), "other"}, joined)
+
+	// the poller only runs every 5s, the leave comes from the notification.
+	rds.Del(otherKey)
+	rds.Publish("__keyevent@0__:expired", otherKey)
+	select {
+	case event := <-events:
+		require.Equal(t, redisdriver.NodeEvent{Type: redisdriver.NodeLeave, NodeID: "other"}, event)
+	case <-time.After(time.Second):
+		t.Fatal("no leave event from keyspace notification")
+	}
+}
+
+func TestRedisDriver_WatchKeyspaceNotificationsFallback(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(2*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithHeartbeatInterval(20*time.Millisecond),
+		redisdriver.WithKeyspaceNotifications(true))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := drv.Watch(ctx)
+	require.Nil(t, err)
+	require.Equal(t, redisdriver.NodeEvent{Type: redisdriver.NodeJoin, NodeID: drv.NodeID()}, testFuncNextEvent(t, events))
+
+	otherKey := testFuncNodeKey(t.Name(), "other")
+	require.Nil(t, rds.Set(otherKey, "other"))
+	require.Equal(t, redisdriver.NodeEvent{Type: redisdriver.NodeJoin, NodeID: "other"}, testFuncNextEvent(t, events))
+	rds.Del(otherKey)
+	require.Equal(t, redisdriver.NodeEvent{Type: redisdriver.NodeLeave, NodeID: "other"}, testFuncNextEvent(t, events))
+}
+
+func TestRedisDriver_WaitForNodes(t *testing.T) {
+	rds := miniredis.RunT(t)
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithHeartbeatInterval(20*time.Millisecond))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+
+	// the nodes join one after another.
+	go func() {
+		for _, nodeID := range []string{"node-1", "node-2", "node-3"} {
+			time.Sleep(50 * time.Millisecond)
+			rds.Set(testFuncNodeKey(t.Name(), nodeID), "{}")
+		}
+	}()
+	begin := time.Now()
+	// this node and the three others
+	nodes, err := drv.WaitForNodes(context.Background(), 4)
+	require.Nil(t, err)
+	require.GreaterOrEqual(t, time.Since(begin), 150*time.Millisecond)
+	require.Len(t, nodes, 4)
+	require.Contains(t, nodes, drv.NodeID())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	nodes, err = drv.WaitForNodes(ctx, 10)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Len(t, nodes, 4)
+}
+
+func TestRedisDriver_OnNodeJoinLeave(t *testing.T) {
+	rds := miniredis.RunT(t)
+	joins := make(chan string, 16)
+	leaves := make(chan string, 16)
+	block := make(chan struct{})
+	defer close(block)
+	drv1 := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv1.Init(t.Name(),
+		commons.NewTimeoutOption(2*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithHeartbeatInterval(20*time.Millisecond),
+		redisdriver.WithOnNodeJoin(func(nodeID string) {
+			joins <- nodeID
+			// a slow callback does not hold up the others.
+			<-block
+		}),
+		redisdriver.WithOnNodeLeave(func(nodeID string) {
+			leaves <- nodeID
+		}))
+	drv2 := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{})
+	drv2.Init(t.Name(),
+		commons.NewTimeoutOption(2*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)))
+	require.Nil(t, drv1.Start(context.Background()))
+	defer testFuncStop(t, rds, drv1)
+
+	next := func(ids <-chan string) string {
+		select {
+		case id := <-ids:
+			return id
+		case <-time.After(2 * time.Second):
+			t.Fatal("callback is not called")
+		}
+		return ""
+	}
+	require.Equal(t, drv1.NodeID(), next(joins))
+	require.Nil(t, drv2.Start(context.Background()))
+	require.Equal(t, drv2.NodeID(), next(joins))
+	testFuncStop(t, rds, drv2)
+	require.Equal(t, drv2.NodeID(), next(leaves))
+}
+
+func TestRedisDriver_OnNodeJoinRetry(t *testing.T) {
+	rds := miniredis.RunT(t)
+	clock := newTestClock()
+	var failing atomic.Bool
+	failing.Store(true)
+	joins := make(chan string, 16)
+	drv := testFuncNewRedisDriverWithHook(rds.Addr(), &testHook{
+		process: func(ctx context.Context, cmd redis.Cmder, next redis.ProcessHook) error {
+			if cmd.Name() == "scan" && failing.Load() {
+				cmd.SetErr(errors.New("scan failed"))
+				return cmd.Err()
+			}
+			return next(ctx, cmd)
+		},
+	})
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(2*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithClock(clock),
+		redisdriver.WithOnNodeJoin(func(nodeID string) { joins <- nodeID }))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, rds, drv)
+
+	// the tickers of the heartbeat and of the wait before the next Watch.
+	first, second := clock.nextTicker(t), clock.nextTicker(t)
+	failing.Store(false)
+	select {
+	case <-joins:
+		t.Fatal("watch is retried before the clock ticks")
+	case <-time.After(50 * time.Millisecond):
+	}
+	first.tick()
+	second.tick()
+	select {
+	case nodeID := <-joins:
+		require.Equal(t, drv.NodeID(), nodeID)
+	case <-time.After(time.Second):
+		t.Fatal("callback is not called")
+	}
+}
+
+func TestRedisDriver_WatchKeyspaceNotificationsCluster(t *testing.T) {
+	shards := []*miniredis.Miniredis{miniredis.RunT(t), miniredis.RunT(t)}
+	client := redis.NewClusterClient(&redis.ClusterOptions{
+		ClusterSlots: func(ctx context.Context) ([]redis.ClusterSlot, error) {
+			return []redis.ClusterSlot{
+				{Start: 0, End: 8191, Nodes: []redis.ClusterNode{{Addr: shards[0].Addr()}}},
+				{Start: 8192, End: 16383, Nodes: []redis.ClusterNode{{Addr: shards[1].Addr()}}},
+			}, nil
+		},
+	})
+	defer client.Close()
+	// miniredis does not implement CONFIG, pretend notifications are enabled.
+	client.OnNewNode(func(shard *redis.Client) {
+		shard.AddHook(&testHook{
+			process: func(ctx context.Context, cmd redis.Cmder, next redis.ProcessHook) error {
+				if cmd.Name() == "config" {
+					cmd.(*redis.MapStringStringCmd).SetVal(map[string]string{"notify-keyspace-events": "Exg"})
+					return nil
+				}
+				return next(ctx, cmd)
+			},
+		})
+	})
+	drv := redisdriver.NewDriver(client)
+	drv.Init(t.Name(),
+		commons.NewTimeoutOption(10*time.Second),
+		commons.NewLoggerOption(dlog.NewLoggerForTest(t)),
+		redisdriver.WithKeyspaceNotifications(true))
+	require.Nil(t, drv.Start(context.Background()))
+	defer testFuncStop(t, shards[0], drv)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nodeIDs := []string{"other-0", "other-1", "other-2", "other-3"}
+	for _, nodeID := range nodeIDs {
+		require.Nil(t, client.Set(ctx, testFuncNodeKey(t.Name(), nodeID), "{}", 0).Err())
+	}
+	events, err := drv.Watch(ctx)
+	require.Nil(t, err)
+	joined := make([]string, 0)
+	for i := 0; i <= len(nodeIDs); i++ {
+		joined = append(joined, testFuncNextEvent(t, events).NodeID)
+	}
+	require.ElementsMatch(t, append([]string{drv.NodeID()}, nodeIDs...), joined)
+
+	// every master publishes the events of its own keys.
+	for i, shard := range shards {
+		nodeID := nodeIDs[i]
+		for _, candidate := range nodeIDs {
+			if shard.Exists(testFuncNodeKey(t.Name(), candidate)) {
+				nodeID = candidate
+				break
+			}
+		}
+		key := testFuncNodeKey(t.Name(), nodeID)
+		require.True(t, shard.Exists(key), "no node key on shard %d", i)
+		shard.Del(key)
+		shard.Publish("__keyevent@0__:expired", key)
+		select {
+		case event := <-events:
+			require.Equal(t, redisdriver.NodeEvent{Type: redisdriver.NodeLeave, NodeID: nodeID}, event)
+		case <-time.After(time.Second):
+			t.Fatalf("no leave event from the keyspace notification of shard %d", i)
+		}
+	}
+}